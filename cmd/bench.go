@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+)
+
+// Command line flags for the bench command
+var (
+	flagBenchCases      []string
+	flagBenchIterations int
+	flagBenchSeed       int64
+	flagBenchCSV        string
+	flagBenchParallel   int
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure how fast this build simulates generated maps",
+	Long:  "Simulates aliens on generated grid maps of increasing size, reporting the throughput and memory use of this build so that it can be compared with others.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cases := aliensim.BenchmarkCases
+		if len(flagBenchCases) > 0 {
+			cases = []aliensim.BenchmarkCase{}
+			for _, s := range flagBenchCases {
+				c, err := aliensim.ParseBenchmarkCase(s)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(2)
+				}
+				cases = append(cases, c)
+			}
+		}
+
+		fmt.Println(
+			fmt.Sprintf(
+				"Running %d benchmarks of up to %d iterations (%s, %s/%s, %d CPUs)...",
+				len(cases),
+				flagBenchIterations,
+				runtime.Version(),
+				runtime.GOOS,
+				runtime.GOARCH,
+				runtime.NumCPU(),
+			),
+		)
+		if flagBenchParallel > 0 {
+			fmt.Println(fmt.Sprintf("Moving the aliens using %d goroutines.", flagBenchParallel))
+		}
+		fmt.Println("")
+		fmt.Println(
+			fmt.Sprintf(
+				"%-20s %10s %12s %14s %10s %12s %12s",
+				"benchmark", "iterations", "ticks/sec", "moves/sec", "parse", "allocated", "heap in use",
+			),
+		)
+		results := []*aliensim.BenchmarkResult{}
+		for _, c := range cases {
+			res, err := aliensim.RunBenchmark(c, flagBenchIterations, flagBenchSeed, flagBenchParallel)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(3)
+			}
+			results = append(results, res)
+			fmt.Println(
+				fmt.Sprintf(
+					"%-20s %10d %12.1f %14.0f %10s %12s %12s",
+					c,
+					res.Iterations,
+					res.TicksPerSecond(),
+					res.MovesPerSecond(),
+					res.ParseDuration.Round(time.Millisecond),
+					formatBytes(res.BytesAllocated),
+					formatBytes(res.HeapInUse),
+				),
+			)
+		}
+
+		if len(flagBenchCSV) > 0 {
+			if err := writeBenchmarkResults(flagBenchCSV, results); err != nil {
+				fmt.Println(err)
+				os.Exit(4)
+			}
+			fmt.Println("")
+			fmt.Println(fmt.Sprintf("Wrote benchmark results to: %s", flagBenchCSV))
+		}
+	},
+}
+
+// formatBytes formats the given number of bytes in the largest unit in which
+// there's at least one of them.
+func formatBytes(n uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	v := float64(n)
+	unit := 0
+	for v >= 1024 && unit < len(units)-1 {
+		v /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", v, units[unit])
+}
+
+// writeBenchmarkResults writes the given benchmark results to the given file as
+// CSV.
+func writeBenchmarkResults(filename string, results []*aliensim.BenchmarkResult) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = aliensim.WriteBenchmarkResultsCSV(f, results)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func initBenchCmd() {
+	benchCmd.Flags().StringSliceVar(
+		&flagBenchCases,
+		"case",
+		[]string{},
+		"a benchmark to run instead of the default ones, as WIDTHxHEIGHT/ALIENS (e.g. 300x300/9000); may be given more than once",
+	)
+	benchCmd.Flags().IntVar(
+		&flagBenchIterations,
+		"iterations",
+		100,
+		"the maximum number of iterations to simulate for each benchmark",
+	)
+	benchCmd.Flags().Int64Var(
+		&flagBenchSeed,
+		"seed",
+		1,
+		"the random seed to use, so that builds are compared on the same simulations",
+	)
+	benchCmd.Flags().IntVar(
+		&flagBenchParallel,
+		"parallel",
+		0,
+		"move the aliens using this many goroutines during each iteration (see the --parallel flag of the simulator)",
+	)
+	benchCmd.Flags().StringVar(
+		&flagBenchCSV,
+		"csv",
+		"",
+		"also write the results to this CSV file",
+	)
+	rootCmd.AddCommand(benchCmd)
+}
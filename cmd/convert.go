@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+)
+
+// Command line flags for the convert command
+var (
+	flagConvertTo            string
+	flagConvertMaxLineLength int
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert INPUT OUTPUT",
+	Short: "Convert a world map between the text and binary formats",
+	Long:  "Reads a world map (in either format) from INPUT and writes it to OUTPUT in the format given by --to. Binary maps are read much faster than text ones, since the layout of their cities has already been worked out.",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if flagConvertTo != "binary" && flagConvertTo != "text" {
+			fmt.Println(fmt.Sprintf("Unknown map format: %s (expected binary or text)", flagConvertTo))
+			os.Exit(2)
+		}
+		if flagConvertMaxLineLength <= 0 {
+			fmt.Println(fmt.Sprintf("The maximum line length must be positive, but got %d.", flagConvertMaxLineLength))
+			os.Exit(2)
+		}
+		mapFile, err := aliensim.OpenMapFile(args[0], false)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		worldMap, err := aliensim.NewMapParser().WithMaxLineLength(flagConvertMaxLineLength).Parse(mapFile)
+		mapFile.Close()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(3)
+		}
+		if err := writeWorldMap(args[1], worldMap, flagConvertTo == "binary"); err != nil {
+			fmt.Println(err)
+			os.Exit(4)
+		}
+		fmt.Println(
+			fmt.Sprintf(
+				"Wrote %d cities and %d roads to %s in the %s format.",
+				len(worldMap.Cities()),
+				len(worldMap.Roads()),
+				args[1],
+				flagConvertTo,
+			),
+		)
+	},
+}
+
+// writeWorldMap writes the given world map to the given file, either in the
+// binary format or as text.
+func writeWorldMap(filename string, worldMap *aliensim.WorldMap, binary bool) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if binary {
+		err = aliensim.WriteBinaryMap(f, worldMap)
+	} else {
+		_, err = io.WriteString(f, worldMap.Render())
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func initConvertCmd() {
+	convertCmd.Flags().StringVar(
+		&flagConvertTo,
+		"to",
+		"binary",
+		"the format to convert the world map to (binary or text)",
+	)
+	convertCmd.Flags().IntVar(
+		&flagConvertMaxLineLength,
+		"max-line-length",
+		aliensim.DefaultMaxLineLength,
+		"the longest line (in bytes) a text world map may contain",
+	)
+	rootCmd.AddCommand(convertCmd)
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+	"github.com/thanethomson/alien-invasion/pkg/metrics"
 )
 
 // Command line flags
@@ -15,6 +20,41 @@ var (
 	flagAlienCount       int
 	flagUseExampleMap    bool
 	flagWorldMapFilename string
+	flagRenderGrid       bool
+	flagRoadEncounters   bool
+	flagRoadCollapse     float64
+	flagDefenderKill     float64
+	flagRebuildAfter     int
+	flagRebuildNearby    bool
+	flagSpawnCities      []string
+	flagSpawnPattern     string
+	flagPlacement        string
+	flagPlacementFile    string
+	flagWaveSize         int
+	flagWaveStart        int
+	flagWaveEvery        int
+	flagWaveCount        int
+	flagSpeciesFilename  string
+	flagReproduceAfter   int
+	flagReproduceChance  float64
+	flagPopulationCap    int
+	flagSightRadius      int
+	flagMisjudgeChance   float64
+	flagEnergy           int
+	flagRechargeRate     int
+	flagMaxAlienMoves    int
+	flagMaxTotalMoves    int
+	flagTimeLimit        time.Duration
+	flagQuietIterations  int
+	flagMaxIterations    int
+	flagTrajectories     string
+	flagStatsCSV         string
+	flagMetricsFile      string
+	flagParallel         int
+	flagMaxLineLength    int
+	flagMemoryMap        bool
+	flagParseProgress    bool
+	flagSnapshot         string
 )
 
 var rootCmd = &cobra.Command{
@@ -25,69 +65,604 @@ var rootCmd = &cobra.Command{
 		var reader io.Reader
 		var err error
 
+		if flagMaxLineLength <= 0 {
+			fmt.Println(fmt.Sprintf("The maximum line length must be positive, but got %d.", flagMaxLineLength))
+			os.Exit(2)
+		}
 		if flagUseExampleMap {
 			reader = strings.NewReader(aliensim.ExampleWorld)
 			fmt.Println("Using example world for simulation.")
 		} else {
-			reader, err = os.Open(flagWorldMapFilename)
+			mapFile, err := aliensim.OpenMapFile(flagWorldMapFilename, flagMemoryMap)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+			defer mapFile.Close()
+			reader = mapFile
+			if mapFile.MemoryMapped() {
+				fmt.Println(fmt.Sprintf("Reading world from memory-mapped file: %s", flagWorldMapFilename))
+			} else {
+				fmt.Println(fmt.Sprintf("Reading world from file: %s", flagWorldMapFilename))
+			}
+		}
+
+		spawnPattern, err := aliensim.ParseSpawnPattern(flagSpawnPattern)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		var placement aliensim.PlacementStrategy
+		if len(flagPlacementFile) > 0 {
+			placementFile, err := os.Open(flagPlacementFile)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+			placement, err = aliensim.NewExplicitPlacement(placementFile)
+			placementFile.Close()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+		} else {
+			placement, err = aliensim.ParsePlacementStrategy(flagPlacement)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+		}
+
+		var species []*aliensim.Species
+		if len(flagSpeciesFilename) > 0 {
+			speciesFile, err := os.Open(flagSpeciesFilename)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+			species, err = aliensim.ParseSpecies(speciesFile)
+			speciesFile.Close()
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(2)
 			}
-			fmt.Println(fmt.Sprintf("Reading world from file: %s", flagWorldMapFilename))
 		}
 
 		fmt.Println(fmt.Sprintf("Executing simulation with %d aliens...", flagAlienCount))
 
-		sim := aliensim.NewSimulation(
-			aliensim.NewSimulationConfig(
-				reader,
-				flagAlienCount,
-			),
-		)
+		mapParser := aliensim.NewMapParser().WithMaxLineLength(flagMaxLineLength)
+		if flagParseProgress {
+			mapParser = mapParser.WithProgressHandler(&aliensim.StdoutParseProgressHandler{}, 0)
+		}
+
+		config := aliensim.NewSimulationConfig(
+			reader,
+			flagAlienCount,
+		).
+			WithMapParser(mapParser).
+			WithRoadEncounters(flagRoadEncounters).
+			WithRoadCollapseChance(flagRoadCollapse).
+			WithDefenderKillChance(flagDefenderKill).
+			WithRebuilding(flagRebuildAfter, flagRebuildNearby).
+			WithSpawnCities(flagSpawnCities).
+			WithSpawnPattern(spawnPattern).
+			WithPlacement(placement).
+			WithSpecies(species...).
+			WithReproduction(flagReproduceAfter, flagReproduceChance, flagPopulationCap).
+			WithEnergy(flagEnergy, flagRechargeRate).
+			WithTrajectories(len(flagTrajectories) > 0).
+			WithParallelism(flagParallel)
+		if flagMaxAlienMoves > 0 {
+			config = config.WithStopCriteria(aliensim.MaxMovesPerAlien{Moves: flagMaxAlienMoves})
+		}
+		if flagMaxTotalMoves > 0 {
+			config = config.WithStopCriteria(aliensim.MaxTotalMoves{Moves: flagMaxTotalMoves})
+		}
+		if flagTimeLimit > 0 {
+			config = config.WithStopCriteria(aliensim.WallClockLimit{Duration: flagTimeLimit})
+		}
+		if flagQuietIterations > 0 {
+			config = config.WithStopCriteria(aliensim.NoDestructionFor{Iterations: flagQuietIterations})
+		}
+		if flagMaxIterations > 0 {
+			config = config.WithStopCriteria(aliensim.MaxIterations{Iterations: flagMaxIterations})
+		}
+		if cmd.Flags().Changed("sight-radius") || flagMisjudgeChance > 0 {
+			config = config.WithPerception(flagSightRadius, flagMisjudgeChance)
+		}
+		if flagWaveSize > 0 {
+			config = config.WithReinforcements(
+				aliensim.AlienWave{
+					Count: flagWaveSize,
+					Start: flagWaveStart,
+					Every: flagWaveEvery,
+					Times: flagWaveCount,
+				},
+			)
+		}
+		var stats *aliensim.StatsCollector
+		if len(flagStatsCSV) > 0 {
+			stats = aliensim.NewStatsCollector()
+			config = config.WithProgressHandler(
+				aliensim.MultiSimulationProgressHandler{&aliensim.StdoutSimulationProgressHandler{}, stats},
+			)
+		}
+		var simMetrics *metrics.SimulationMetrics
+		if len(flagMetricsFile) > 0 {
+			simMetrics = metrics.NewSimulationMetrics()
+			config = config.WithInstrumentation(simMetrics)
+		}
+		sim := aliensim.NewSimulation(config)
 
-		res, err := sim.Simulate()
+		res, err := sim.SimulateContext(interruptible())
+		// write out the metrics even if the simulation failed, since the
+		// failure is one of the things they record
+		if simMetrics != nil {
+			if err := writeMetrics(flagMetricsFile, simMetrics); err != nil {
+				fmt.Println(err)
+				os.Exit(4)
+			}
+		}
 		if err != nil {
 			fmt.Println(err)
-			os.Exit(3)
+			if res == nil {
+				os.Exit(3)
+			}
 		}
 		fmt.Println("")
+		if res.Cancelled {
+			fmt.Println("Interrupted! The outcome below is that of the simulation so far.")
+		} else if err != nil {
+			fmt.Println("Failed! The outcome below is that of the simulation so far.")
+		}
+		fmt.Println(
+			fmt.Sprintf(
+				"Simulation stopped after %d iterations, because %s.",
+				res.IterationsSimulated,
+				res.StopReason,
+			),
+		)
+		fmt.Println("")
 		fmt.Println("Done. Remaining aliens:")
 		for _, alien := range res.FinalAliens {
 			fmt.Println(alien)
 		}
 		fmt.Println("")
+		fmt.Println(
+			fmt.Sprintf(
+				"%d aliens alive (%d exhausted, %d trapped), %d dead.",
+				res.AliensStillAlive,
+				res.AliensExhausted,
+				res.AliensTrapped,
+				res.AliensDead,
+			),
+		)
+		if len(res.CitiesHeldOut) > 0 {
+			fmt.Println("")
+			fmt.Println("Cities that held out:")
+			for _, cityName := range res.CitiesRemaining {
+				if defence, ok := res.CitiesHeldOut[cityName]; ok {
+					fmt.Println(fmt.Sprintf("%s (defence left: %d)", cityName, defence))
+				}
+			}
+		}
+		if len(res.Rebuilds) > 0 {
+			fmt.Println("")
+			fmt.Println("Rebuilt cities:")
+			for _, rebuild := range res.Rebuilds {
+				fmt.Println(
+					fmt.Sprintf(
+						"%s (destroyed during iteration %d, rebuilt during iteration %d)",
+						rebuild.CityName,
+						rebuild.DestroyedAt,
+						rebuild.RebuiltAt,
+					),
+				)
+			}
+		}
+		if len(res.AlienParents) > 0 {
+			peak := 0
+			for _, population := range res.Population {
+				if population > peak {
+					peak = population
+				}
+			}
+			fmt.Println("")
+			fmt.Println(fmt.Sprintf("%d aliens were born, with a peak population of %d.", len(res.AlienParents), peak))
+		}
+		if cmd.Flags().Changed("sight-radius") || flagMisjudgeChance > 0 {
+			total, most := 0, 0
+			for _, visited := range res.AlienVisitedCities {
+				total += visited
+				if visited > most {
+					most = visited
+				}
+			}
+			fmt.Println("")
+			fmt.Println(
+				fmt.Sprintf(
+					"Aliens visited %.1f different cities on average (at most %d).",
+					float64(total)/float64(len(res.AlienVisitedCities)),
+					most,
+				),
+			)
+		}
+		if len(species) > 0 {
+			fmt.Println("")
+			fmt.Println("Species:")
+			for _, sp := range species {
+				stats := res.SpeciesStats[sp.Name]
+				fmt.Println(
+					fmt.Sprintf(
+						"%s: %d still alive (%d arrived, %d born), %d fights won, %d cities destroyed, distance travelled: %d",
+						sp.Name,
+						stats.StillAlive,
+						stats.Spawned,
+						stats.Born,
+						stats.FightsWon,
+						stats.CitiesDestroyed,
+						stats.Distance,
+					),
+				)
+			}
+		}
+		if len(flagTrajectories) > 0 {
+			if err := writeTrajectories(flagTrajectories, res.Trajectories); err != nil {
+				fmt.Println(err)
+				os.Exit(4)
+			}
+			fmt.Println("")
+			fmt.Println(fmt.Sprintf("Wrote alien trajectories to: %s", flagTrajectories))
+		}
+		if stats != nil {
+			if err := writeStats(flagStatsCSV, stats); err != nil {
+				fmt.Println(err)
+				os.Exit(4)
+			}
+			fmt.Println("")
+			fmt.Println(fmt.Sprintf("Wrote per-iteration statistics to: %s", flagStatsCSV))
+		}
+		if len(flagSnapshot) > 0 {
+			if err := writeSnapshot(flagSnapshot, sim); err != nil {
+				fmt.Println(err)
+				os.Exit(4)
+			}
+			fmt.Println("")
+			fmt.Println(fmt.Sprintf("Wrote a snapshot of the simulation to: %s", flagSnapshot))
+		}
+		fmt.Println("")
 		fmt.Println("Final world map:")
 		fmt.Println("")
 		fmt.Println(res.FinalMap.Render())
+		if flagRenderGrid && !res.FinalMap.LaidOut() {
+			fmt.Println("The world map can't be drawn as a grid, as its links don't fit on one.")
+		} else if flagRenderGrid {
+			for _, level := range res.FinalMap.Levels() {
+				fmt.Println(fmt.Sprintf("Level %d:", level))
+				fmt.Println("")
+				fmt.Println(res.FinalMap.RenderGrid(level))
+			}
+		}
+		if err != nil {
+			os.Exit(3)
+		}
 	},
 }
 
+// interruptible returns a context that's cancelled when the user hits Ctrl-C,
+// so that the simulation can stop and report what happened so far. Hitting
+// Ctrl-C a second time exits immediately, as usual.
+func interruptible() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		cancel()
+	}()
+	return ctx
+}
+
+// writeTrajectories writes the given alien trajectories to the given file, as
+// JSON if its name ends in ".json" and as CSV otherwise.
+func writeTrajectories(filename string, trajectories []aliensim.Trajectory) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		err = aliensim.WriteTrajectoriesJSON(f, trajectories)
+	} else {
+		err = aliensim.WriteTrajectoriesCSV(f, trajectories)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeSnapshot writes a snapshot of where the given simulation stopped to the
+// given file.
+func writeSnapshot(filename string, sim *aliensim.Simulation) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = sim.WriteSnapshot(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeStats writes the statistics recorded for each iteration to the given
+// file as CSV.
+func writeStats(filename string, stats *aliensim.StatsCollector) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = stats.WriteCSV(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeMetrics writes the given simulation metrics to the given file in the
+// Prometheus text exposition format.
+func writeMetrics(filename string, simMetrics *metrics.SimulationMetrics) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = simMetrics.Registry.Write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
 func initCmd() {
-	rootCmd.PersistentFlags().IntVarP(
+	rootCmd.Flags().IntVarP(
 		&flagAlienCount,
 		"alien-count",
 		"N",
 		2,
 		"the number of aliens to simulate",
 	)
-	rootCmd.PersistentFlags().StringVarP(
+	rootCmd.Flags().StringVarP(
 		&flagWorldMapFilename,
 		"world-map",
 		"m",
 		"world-map.txt",
 		"the file from which to load the world map",
 	)
-	rootCmd.PersistentFlags().BoolVar(
+	rootCmd.Flags().IntVar(
+		&flagMaxLineLength,
+		"max-line-length",
+		aliensim.DefaultMaxLineLength,
+		"the longest line (in bytes) the world map may contain",
+	)
+	rootCmd.Flags().BoolVar(
+		&flagMemoryMap,
+		"mmap",
+		false,
+		"memory-map the world map file instead of reading it (where supported), which can help with very large maps",
+	)
+	rootCmd.Flags().BoolVar(
+		&flagParseProgress,
+		"parse-progress",
+		false,
+		"report how far parsing the world map has got every 64 MiB",
+	)
+	rootCmd.Flags().BoolVar(
 		&flagUseExampleMap,
 		"use-example-map",
 		false,
 		"use the example world map instead of loading one",
 	)
+	rootCmd.Flags().BoolVar(
+		&flagRenderGrid,
+		"grid",
+		false,
+		"also draw the final world map as a grid, one level at a time",
+	)
+	rootCmd.Flags().BoolVar(
+		&flagRoadEncounters,
+		"road-encounters",
+		false,
+		"aliens meeting head-on while travelling along a road fight each other",
+	)
+	rootCmd.Flags().Float64Var(
+		&flagRoadCollapse,
+		"road-collapse-chance",
+		0,
+		"the chance (between 0 and 1) of each road collapsing during any given iteration",
+	)
+	rootCmd.Flags().Float64Var(
+		&flagDefenderKill,
+		"defender-kill-chance",
+		0,
+		"the chance (between 0 and 1) of a defended city killing a lone alien during any given iteration",
+	)
+	rootCmd.Flags().IntVar(
+		&flagRebuildAfter,
+		"rebuild-after",
+		0,
+		"rebuild destroyed cities after this many iterations (0 means never)",
+	)
+	rootCmd.Flags().BoolVar(
+		&flagRebuildNearby,
+		"rebuild-near-survivors",
+		false,
+		"only rebuild destroyed cities with at least one neighbour still standing",
+	)
+	rootCmd.Flags().StringSliceVar(
+		&flagSpawnCities,
+		"spawn-cities",
+		nil,
+		"the cities in which aliens can spawn (overrides any spawn points in the map)",
+	)
+	rootCmd.Flags().StringVar(
+		&flagSpawnPattern,
+		"spawn-pattern",
+		"uniform",
+		"how to distribute aliens across the spawn cities (uniform, clustered or edge)",
+	)
+	rootCmd.Flags().StringVar(
+		&flagPlacement,
+		"placement",
+		"uniform",
+		"how to place aliens in the spawn cities (uniform, unique, weighted[:attr] or clustered)",
+	)
+	rootCmd.Flags().StringVar(
+		&flagPlacementFile,
+		"placement-file",
+		"",
+		"a file listing the city in which to place each alien, one per line (overrides --placement)",
+	)
+	rootCmd.Flags().IntVar(
+		&flagWaveSize,
+		"wave-size",
+		0,
+		"the number of aliens in each wave of reinforcements (0 means no reinforcements)",
+	)
+	rootCmd.Flags().IntVar(
+		&flagWaveStart,
+		"wave-start",
+		1,
+		"the iteration at which the first wave of reinforcements arrives",
+	)
+	rootCmd.Flags().IntVar(
+		&flagWaveEvery,
+		"wave-every",
+		0,
+		"the number of iterations between waves of reinforcements (0 means a single wave)",
+	)
+	rootCmd.Flags().IntVar(
+		&flagWaveCount,
+		"wave-count",
+		0,
+		"the maximum number of waves of reinforcements (0 means unlimited)",
+	)
+	rootCmd.Flags().StringVar(
+		&flagSpeciesFilename,
+		"species",
+		"",
+		"the file from which to load the species of alien taking part in the invasion",
+	)
+	rootCmd.Flags().IntVar(
+		&flagReproduceAfter,
+		"reproduce-after",
+		10,
+		"the number of iterations an alien must spend in intact cities before it can reproduce",
+	)
+	rootCmd.Flags().Float64Var(
+		&flagReproduceChance,
+		"reproduction-chance",
+		0,
+		"the chance (between 0 and 1) of an alien in an intact city giving birth during each iteration",
+	)
+	rootCmd.Flags().IntVar(
+		&flagPopulationCap,
+		"population-cap",
+		0,
+		"the maximum number of living aliens reproduction can lead to (0 means no limit)",
+	)
+	rootCmd.Flags().IntVar(
+		&flagSightRadius,
+		"sight-radius",
+		1,
+		"how many roads away aliens can see (cities further away are assumed to be standing)",
+	)
+	rootCmd.Flags().Float64Var(
+		&flagMisjudgeChance,
+		"misjudge-chance",
+		0,
+		"the chance (between 0 and 1) of an alien misjudging whether a city it can see has been destroyed",
+	)
+	rootCmd.Flags().IntVar(
+		&flagEnergy,
+		"energy",
+		10000,
+		"the number of moves each alien can make on a full charge of energy",
+	)
+	rootCmd.Flags().IntVar(
+		&flagRechargeRate,
+		"recharge",
+		0,
+		"the energy aliens regain for each iteration spent in an intact city",
+	)
+	rootCmd.Flags().IntVar(
+		&flagMaxAlienMoves,
+		"max-moves-per-alien",
+		0,
+		"stop once every alien that can move has made this many moves (0 means no limit)",
+	)
+	rootCmd.Flags().IntVar(
+		&flagMaxTotalMoves,
+		"max-total-moves",
+		0,
+		"stop once the aliens have made this many moves between them (0 means no limit)",
+	)
+	rootCmd.Flags().DurationVar(
+		&flagTimeLimit,
+		"time-limit",
+		0,
+		"stop once the simulation has been running for this long, e.g. 30s (0 means no limit)",
+	)
+	rootCmd.Flags().IntVar(
+		&flagQuietIterations,
+		"stop-after-quiet",
+		0,
+		"stop once no cities have been destroyed for this many iterations (0 means never)",
+	)
+	rootCmd.Flags().IntVar(
+		&flagMaxIterations,
+		"max-iterations",
+		0,
+		"stop after this many iterations (0 means the hard limit of 20,000 iterations)",
+	)
+	rootCmd.Flags().StringVar(
+		&flagTrajectories,
+		"trajectories",
+		"",
+		"write the path taken by each alien, and how it died, to this file (as JSON if it ends in .json, otherwise CSV)",
+	)
+	rootCmd.Flags().IntVar(
+		&flagParallel,
+		"parallel",
+		0,
+		"move the aliens using this many goroutines during each iteration (the results are the same as when moving them one at a time)",
+	)
+	rootCmd.Flags().StringVar(
+		&flagStatsCSV,
+		"stats-csv",
+		"",
+		"write statistics (living aliens, standing cities, moves, collisions, etc.) for each iteration to this CSV file",
+	)
+	rootCmd.Flags().StringVar(
+		&flagMetricsFile,
+		"metrics-file",
+		"",
+		"write metrics about the simulation to this file in the Prometheus text format (e.g. for a textfile collector)",
+	)
+	rootCmd.Flags().StringVar(
+		&flagSnapshot,
+		"snapshot",
+		"",
+		"write the final state of the world map and aliens to this file in the binary snapshot format",
+	)
 }
 
 func main() {
 	initCmd()
+	initServeCmd()
+	initBenchCmd()
+	initConvertCmd()
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
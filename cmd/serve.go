@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+	"github.com/thanethomson/alien-invasion/pkg/server"
+)
+
+// Command line flags for the serve command
+var (
+	flagListen    string
+	flagWorkers   int
+	flagQueueSize int
+	flagMapsDir   string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run simulations on request over HTTP",
+	Long:  "Serves a REST API through which other tools can upload maps, run simulations and follow their progress.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if flagWorkers <= 0 {
+			fmt.Println(fmt.Sprintf("The number of workers must be positive, but got %d.", flagWorkers))
+			os.Exit(2)
+		}
+		if flagQueueSize <= 0 {
+			fmt.Println(fmt.Sprintf("The queue size must be positive, but got %d.", flagQueueSize))
+			os.Exit(2)
+		}
+		srv := server.New(flagWorkers, flagQueueSize)
+		if err := srv.AddMap("example", aliensim.ExampleWorld); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+		if len(flagMapsDir) > 0 {
+			if err := srv.LoadMaps(flagMapsDir); err != nil {
+				fmt.Println(err)
+				os.Exit(2)
+			}
+		}
+		fmt.Println(fmt.Sprintf("Listening on %s, with %d workers...", flagListen, flagWorkers))
+		if err := http.ListenAndServe(flagListen, srv); err != nil {
+			fmt.Println(err)
+			os.Exit(3)
+		}
+	},
+}
+
+func initServeCmd() {
+	serveCmd.Flags().StringVar(
+		&flagListen,
+		"listen",
+		":8080",
+		"the address on which to listen for requests",
+	)
+	serveCmd.Flags().IntVar(
+		&flagWorkers,
+		"workers",
+		4,
+		"the number of simulations to run at the same time",
+	)
+	serveCmd.Flags().IntVar(
+		&flagQueueSize,
+		"queue-size",
+		100,
+		"the number of simulations that can be waiting for a free worker",
+	)
+	serveCmd.Flags().StringVar(
+		&flagMapsDir,
+		"maps-dir",
+		"",
+		"a directory from which to load maps (each .txt file is available under its name without the extension)",
+	)
+	rootCmd.AddCommand(serveCmd)
+}
@@ -2,41 +2,214 @@ package aliensim
 
 import "fmt"
 
+// AlienState describes where an alien currently is.
+type AlienState int
+
+// The possible states of an alien.
+const (
+	// AlienInCity means the alien is in a city, from which it will try to
+	// move during the next iteration.
+	AlienInCity AlienState = 0
+	// AlienOnRoad means the alien is busy travelling along a road that takes
+	// more than one iteration to traverse.
+	AlienOnRoad AlienState = 1
+)
+
 // Alien contains the location and state of a specific alien.
 type Alien struct {
-	id    int   // The ID of this alien.
-	city  *City // The city in which we currently find this alien.
-	alive bool  // Is this alien still alive?
+	id         int           // The ID of this alien.
+	city       *City         // The city in which we currently find this alien (or which it last left, if on a road).
+	alive      bool          // Is this alien still alive?
+	state      AlienState    // Is this alien in a city or on a road?
+	road       *Road         // The road along which the alien is travelling, if on a road.
+	progress   int           // How far along the road the alien has travelled so far.
+	distance   int           // The total distance this alien has travelled.
+	spawnedAt  int           // The iteration at the start of which this alien arrived.
+	species    *Species      // The species to which this alien belongs.
+	heading    int           // The direction in which the alien last set off (-1 if it hasn't moved yet).
+	parent     int           // The ID of the alien that gave birth to this one (-1 if it wasn't born on the map).
+	visited    map[*City]int // How many times this alien has been in each city it remembers (nil until it first arrives somewhere).
+	perception *Perception   // How much this alien knows about its surroundings (nil = all of its neighbours).
+	energy     int           // How many more moves this alien can make before it's exhausted.
+
+	path         []PathStep // The cities this alien has been in, if trajectories are being recorded.
+	blockedTurns int        // How many iterations this alien has spent unable to move, despite having energy.
+	timeInCities int        // How many iterations this alien has ended in an intact city, if reproduction is enabled.
+	diedAt       int        // The iteration during which this alien died (-1 if it's still alive).
+	causeOfDeath string     // How this alien died, if it's dead.
 }
 
-// NewAlien creates a living alien in the given city.
+// NewAlien creates a living alien of the default species in the given city.
 func NewAlien(id int, city *City) *Alien {
 	return &Alien{
-		id:    id,
-		city:  city,
-		alive: true,
+		id:      id,
+		city:    city,
+		alive:   true,
+		state:   AlienInCity,
+		species: defaultSpecies,
+		heading: -1,
+		parent:  -1,
+		diedAt:  -1,
 	}
 }
 
 func (a *Alien) String() string {
-	return fmt.Sprintf("Alien %d in %s (alive=%t)", a.id, a.city.name, a.alive)
+	name := fmt.Sprintf("Alien %d", a.id)
+	if a.species.Name != DefaultSpeciesName {
+		name = fmt.Sprintf("%s (%s)", name, a.species.Name)
+	}
+	if a.state == AlienOnRoad {
+		return fmt.Sprintf(
+			"%s on the road from %s to %s (alive=%t)",
+			name,
+			a.city.name,
+			a.road.Other(a.city).name,
+			a.alive,
+		)
+	}
+	return fmt.Sprintf("%s in %s (alive=%t)", name, a.city.name, a.alive)
+}
+
+// ID returns the ID of this alien.
+func (a *Alien) ID() int {
+	return a.id
+}
+
+// Alive returns whether this alien is still alive.
+func (a *Alien) Alive() bool {
+	return a.alive
+}
+
+// City returns the city this alien is in, or the city it last left if it's on
+// a road.
+func (a *Alien) City() *City {
+	return a.city
+}
+
+// Road returns the road along which this alien is travelling, or nil if it's in
+// a city.
+func (a *Alien) Road() *Road {
+	return a.road
+}
+
+// State returns whether the alien is currently in a city or on a road.
+func (a *Alien) State() AlienState {
+	return a.state
+}
+
+// Distance returns the total distance travelled by this alien so far, where
+// each iteration spent travelling along a road counts as a single unit.
+func (a *Alien) Distance() int {
+	return a.distance
+}
+
+// Move moves this alien one step further along the road it is on, or, if it is
+// in a city, attempts to move it along the road picked by its species'
+// movement strategy. Returns false if the alien could not move.
+func (a *Alien) Move(rnd RandomGenerator) bool {
+	if a.state == AlienOnRoad {
+		a.advance()
+		return true
+	}
+	return a.travel(a.species.Movement.ChooseRoad(rnd, a, a.availableRoads(rnd)))
 }
 
 // MoveInRandomDirection will attempt to move this alien in a random direction,
-// depending on which cities around it are not yet destroyed. If it cannot move,
-// this function will return false.
+// depending on which cities around it are not yet destroyed and which roads it
+// is allowed to travel along. If it cannot move, this function will return
+// false. If the chosen road is longer than a single step, the alien will still
+// be on the road after this move.
 func (a *Alien) MoveInRandomDirection(rnd RandomGenerator) bool {
-	availCities := []*City{}
-	for _, n := range a.city.neighbours {
-		if n != nil && !n.destroyed {
-			availCities = append(availCities, n)
+	return a.travel(RandomMovement{}.ChooseRoad(rnd, a, a.availableRoads(rnd)))
+}
+
+// availableRoads returns the roads, in direction order, that this alien can
+// travel along from the city it's in to cities it believes haven't been
+// destroyed.
+func (a *Alien) availableRoads(rnd RandomGenerator) []*Road {
+	availRoads := []*Road{}
+	for _, road := range a.city.roads {
+		if road == nil || !road.AllowsTravelFrom(a.city) {
+			continue
+		}
+		if n := road.Other(a.city); a.believesStanding(rnd, n, 1) {
+			availRoads = append(availRoads, road)
 		}
 	}
-	if len(availCities) > 0 {
-		cityID := rnd.Uint32() % uint32(len(availCities))
-		// move the alien to this new city
-		a.city = availCities[cityID]
-		return true
+	return availRoads
+}
+
+// standingRoads returns the roads that this alien can actually travel along
+// from the city it's in to cities that haven't been destroyed, regardless of
+// what the alien believes.
+func (a *Alien) standingRoads() []*Road {
+	standing := []*Road{}
+	for _, road := range a.city.roads {
+		if road != nil && road.AllowsTravelFrom(a.city) && !road.Other(a.city).Destroyed() {
+			standing = append(standing, road)
+		}
+	}
+	return standing
+}
+
+// travel sets the alien off along the given road from the city it's in.
+// Returns false if no road is given.
+func (a *Alien) travel(road *Road) bool {
+	if road == nil {
+		return false
+	}
+	for dir, r := range a.city.roads {
+		if r == road {
+			a.heading = dir
+		}
+	}
+	// start moving the alien towards the new city
+	a.state = AlienOnRoad
+	a.road = road
+	a.progress = 0
+	a.advance()
+	return true
+}
+
+// advance moves the alien a single step along its current road, placing it in
+// the city at the end of the road once it gets there.
+func (a *Alien) advance() {
+	a.progress++
+	a.distance++
+	if a.progress >= a.road.length {
+		next := a.road.Other(a.city)
+		a.visit(next)
+		a.city = next
+		a.state = AlienInCity
+		a.road = nil
+		a.progress = 0
+	}
+}
+
+// headingFromStart checks whether this alien is travelling along its road
+// from the road's declaring city towards the other end.
+func (a *Alien) headingFromStart() bool {
+	return a.city == a.road.from
+}
+
+// endOnRoad returns how far along the given road (from its declaring city) this
+// alien got while travelling along it in the given direction. Aliens that have
+// since left the road made it all the way to the end.
+func (a *Alien) endOnRoad(road *Road, forward bool) int {
+	if a.state == AlienOnRoad && a.road == road && a.headingFromStart() == forward {
+		return a.positionOnRoad()
+	}
+	if forward {
+		return road.length
+	}
+	return 0
+}
+
+// positionOnRoad returns how far this alien is from the declaring city of the
+// road it's travelling along.
+func (a *Alien) positionOnRoad() int {
+	if a.headingFromStart() {
+		return a.progress
 	}
-	return false
+	return a.road.length - a.progress
 }
@@ -0,0 +1,173 @@
+package aliensim
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// BenchmarkCase describes a benchmark: simulating the given number of aliens on
+// a generated grid map (see GenerateGridMap) of the given size.
+type BenchmarkCase struct {
+	Width  int
+	Height int
+	Aliens int
+}
+
+// BenchmarkCases are the benchmarks run by default, on maps of increasing size
+// with aliens in 1% and 10% of the cities.
+var BenchmarkCases = []BenchmarkCase{
+	{Width: 100, Height: 100, Aliens: 100},
+	{Width: 100, Height: 100, Aliens: 1000},
+	{Width: 300, Height: 300, Aliens: 900},
+	{Width: 300, Height: 300, Aliens: 9000},
+	{Width: 1000, Height: 1000, Aliens: 10000},
+	{Width: 1000, Height: 1000, Aliens: 100000},
+}
+
+// ParseBenchmarkCase parses a benchmark case of the form "WIDTHxHEIGHT/ALIENS"
+// (e.g. "300x300/9000").
+func ParseBenchmarkCase(s string) (BenchmarkCase, error) {
+	c := BenchmarkCase{}
+	var rest string
+	n, _ := fmt.Sscanf(s, "%dx%d/%d%s", &c.Width, &c.Height, &c.Aliens, &rest)
+	if n != 3 || c.Width < 1 || c.Height < 1 || c.Aliens < 1 {
+		return c, NewExtendedSimulationError(
+			ErrInvalidConfig,
+			fmt.Sprintf("Invalid benchmark \"%s\" (expected WIDTHxHEIGHT/ALIENS, e.g. 300x300/9000).", s),
+			nil,
+		)
+	}
+	return c, nil
+}
+
+func (c BenchmarkCase) String() string {
+	return fmt.Sprintf("%dx%d/%d", c.Width, c.Height, c.Aliens)
+}
+
+// GenerateMap generates the benchmark's world map.
+func (c BenchmarkCase) GenerateMap() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := GenerateGridMap(&buf, c.Width, c.Height); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BenchmarkResult is what was measured while running a benchmark.
+type BenchmarkResult struct {
+	Case             BenchmarkCase
+	Iterations       int           // The number of iterations simulated (fewer than asked for if the simulation ended early).
+	AlienMoves       int           // The number of moves made by all of the aliens between them.
+	ParseDuration    time.Duration // How long it took to parse the world map.
+	SimulateDuration time.Duration // How long it took to simulate, once the world map had been parsed.
+	BytesAllocated   uint64        // The number of bytes allocated, including parsing.
+	Allocations      uint64        // The number of heap objects allocated, including parsing.
+	HeapInUse        uint64        // The number of bytes in use on the heap once the simulation was over.
+}
+
+// TicksPerSecond is the number of iterations simulated per second.
+func (r *BenchmarkResult) TicksPerSecond() float64 {
+	return float64(r.Iterations) / r.SimulateDuration.Seconds()
+}
+
+// MovesPerSecond is the number of alien moves simulated per second.
+func (r *BenchmarkResult) MovesPerSecond() float64 {
+	return float64(r.AlienMoves) / r.SimulateDuration.Seconds()
+}
+
+// benchmarkTimer picks up how long parsing and simulating took from the
+// simulator's instrumentation.
+type benchmarkTimer struct {
+	NoopInstrumentation
+	parse    time.Duration
+	simulate time.Duration
+}
+
+func (t *benchmarkTimer) WorldMapParsed(duration time.Duration, err error) {
+	t.parse = duration
+}
+
+func (t *benchmarkTimer) SimulationFinished(result *SimulationResult, duration time.Duration) {
+	t.simulate = duration
+}
+
+// RunBenchmark simulates up to the given number of iterations of the given
+// benchmark, with aliens placed using the given random seed and moved using the
+// given number of goroutines (see WithParallelism). Progress is reported to a
+// handler that ignores it, so that the simulation's status is still worked out
+// after each iteration, as it would be when printing it.
+func RunBenchmark(c BenchmarkCase, iterations int, seed int64, parallelism int) (*BenchmarkResult, error) {
+	worldMap, err := c.GenerateMap()
+	if err != nil {
+		return nil, err
+	}
+	timer := &benchmarkTimer{}
+	sim := NewSimulation(
+		NewSimulationConfig(bytes.NewReader(worldMap), c.Aliens).
+			WithRandomGenerator(NewSeededGenerator(seed)).
+			WithProgressHandler(&NoopSimulationProgressHandler{}).
+			WithInstrumentation(timer).
+			WithParallelism(parallelism).
+			WithStopCriteria(MaxIterations{Iterations: iterations}),
+	)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	res, err := sim.Simulate()
+	if err != nil {
+		return nil, err
+	}
+	runtime.ReadMemStats(&after)
+	// the simulation must still be around for the heap in use to count it
+	runtime.KeepAlive(sim)
+
+	return &BenchmarkResult{
+		Case:             c,
+		Iterations:       res.IterationsSimulated,
+		AlienMoves:       res.TotalMoves,
+		ParseDuration:    timer.parse,
+		SimulateDuration: timer.simulate,
+		BytesAllocated:   after.TotalAlloc - before.TotalAlloc,
+		Allocations:      after.Mallocs - before.Mallocs,
+		HeapInUse:        after.HeapInuse,
+	}, nil
+}
+
+// WriteBenchmarkResultsCSV writes the given benchmark results to the given
+// writer as CSV, with a header row followed by one row for each result, so
+// that the results for different builds can be compared.
+func WriteBenchmarkResultsCSV(w io.Writer, results []*BenchmarkResult) error {
+	out := csv.NewWriter(w)
+	err := out.Write([]string{
+		"benchmark", "iterations", "alien_moves", "parse_seconds", "simulate_seconds",
+		"ticks_per_second", "moves_per_second", "bytes_allocated", "allocations", "heap_in_use",
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		err = out.Write([]string{
+			r.Case.String(),
+			strconv.Itoa(r.Iterations),
+			strconv.Itoa(r.AlienMoves),
+			strconv.FormatFloat(r.ParseDuration.Seconds(), 'f', 6, 64),
+			strconv.FormatFloat(r.SimulateDuration.Seconds(), 'f', 6, 64),
+			strconv.FormatFloat(r.TicksPerSecond(), 'f', 2, 64),
+			strconv.FormatFloat(r.MovesPerSecond(), 'f', 2, 64),
+			strconv.FormatUint(r.BytesAllocated, 10),
+			strconv.FormatUint(r.Allocations, 10),
+			strconv.FormatUint(r.HeapInUse, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,180 @@
+package aliensim
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseBenchmarkCase(t *testing.T) {
+	c, err := ParseBenchmarkCase("300x200/9000")
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if c != (BenchmarkCase{Width: 300, Height: 200, Aliens: 9000}) || c.String() != "300x200/9000" {
+		t.Error("Expected 300x200/9000, but got", c)
+	}
+	for _, s := range []string{"", "300x300", "300x300/", "0x300/10", "300x300/0", "300x300/10/4", "300by300/10"} {
+		if _, err := ParseBenchmarkCase(s); err == nil {
+			t.Errorf("Expected an error for \"%s\"", s)
+		}
+	}
+}
+
+func TestRunBenchmark(t *testing.T) {
+	c := BenchmarkCase{Width: 10, Height: 10, Aliens: 10}
+	res, err := RunBenchmark(c, 20, 1, 0)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if res.Case != c || res.Iterations < 1 || res.Iterations > 20 {
+		t.Error("Expected up to 20 iterations of", c, "but got", res.Iterations, "of", res.Case)
+	}
+	if res.AlienMoves < 1 || res.SimulateDuration <= 0 || res.BytesAllocated == 0 || res.Allocations == 0 {
+		t.Error("Expected the aliens' moves, the time taken and the memory used to be measured, but got", *res)
+	}
+	if again, err := RunBenchmark(c, 20, 1, 0); err != nil || again.Iterations != res.Iterations || again.AlienMoves != res.AlienMoves {
+		t.Error("Expected the same seed to simulate the same moves")
+	}
+}
+
+func TestWriteBenchmarkResultsCSV(t *testing.T) {
+	results := []*BenchmarkResult{
+		{
+			Case:             BenchmarkCase{Width: 10, Height: 10, Aliens: 10},
+			Iterations:       20,
+			AlienMoves:       150,
+			ParseDuration:    250 * time.Millisecond,
+			SimulateDuration: 2 * time.Second,
+			BytesAllocated:   4096,
+			Allocations:      32,
+			HeapInUse:        8192,
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteBenchmarkResultsCSV(&buf, results); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	expected := "benchmark,iterations,alien_moves,parse_seconds,simulate_seconds,ticks_per_second,moves_per_second,bytes_allocated,allocations,heap_in_use\n" +
+		"10x10/10,20,150,0.250000,2.000000,10.00,75.00,4096,32,8192\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+}
+
+// benchmarkMapSizes returns the distinct map sizes of the default benchmarks.
+func benchmarkMapSizes() []BenchmarkCase {
+	sizes := []BenchmarkCase{}
+	for _, c := range BenchmarkCases {
+		if len(sizes) == 0 || sizes[len(sizes)-1].Width != c.Width || sizes[len(sizes)-1].Height != c.Height {
+			sizes = append(sizes, BenchmarkCase{Width: c.Width, Height: c.Height})
+		}
+	}
+	return sizes
+}
+
+func generateBenchmarkMap(b *testing.B, c BenchmarkCase) []byte {
+	worldMap, err := c.GenerateMap()
+	if err != nil {
+		b.Fatal("Expected no error, but got", err)
+	}
+	return worldMap
+}
+
+func BenchmarkParseWorldMap(b *testing.B) {
+	for _, size := range benchmarkMapSizes() {
+		worldMap := generateBenchmarkMap(b, size)
+		b.Run(fmt.Sprintf("%dx%d", size.Width, size.Height), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseWorldMap(bytes.NewReader(worldMap)); err != nil {
+					b.Fatal("Expected no error, but got", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRecomputeNeighbours times recomputing the neighbours of every city
+// on the map.
+func BenchmarkRecomputeNeighbours(b *testing.B) {
+	for _, size := range benchmarkMapSizes() {
+		m, err := ParseWorldMap(bytes.NewReader(generateBenchmarkMap(b, size)))
+		if err != nil {
+			b.Fatal("Expected no error, but got", err)
+		}
+		b.Run(fmt.Sprintf("%dx%d", size.Width, size.Height), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, city := range m.cities {
+					city.recomputeNeighbours(m.topology)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRunSimulationIteration times individual iterations, starting the
+// simulation over every 100 iterations so that the aliens don't all end up
+// dead or trapped.
+func BenchmarkRunSimulationIteration(b *testing.B) {
+	for _, c := range BenchmarkCases {
+		worldMap := generateBenchmarkMap(b, c)
+		b.Run(fmt.Sprintf("%dx%d/%d-aliens", c.Width, c.Height, c.Aliens), func(b *testing.B) {
+			b.ReportAllocs()
+			var sim *Simulation
+			for i := 0; i < b.N; i++ {
+				if i%100 == 0 {
+					b.StopTimer()
+					sim = NewSimulation(
+						NewSimulationConfig(bytes.NewReader(worldMap), c.Aliens).
+							WithRandomGenerator(NewSeededGenerator(1)).
+							WithProgressHandler(&NoopSimulationProgressHandler{}),
+					)
+					if err := sim.Start(); err != nil {
+						b.Fatal("Expected no error, but got", err)
+					}
+					b.StartTimer()
+				}
+				sim.RunSimulationIteration()
+			}
+		})
+	}
+}
+
+// benchmarkSimulate times 100 iterations of a whole simulation of each of the
+// default benchmarks (including parsing the map and placing the aliens), with
+// the aliens moved using the given number of goroutines. Progress is reported to
+// a handler that ignores it, so that the status is still computed after each
+// iteration.
+func benchmarkSimulate(b *testing.B, parallelism int) {
+	for _, c := range BenchmarkCases {
+		worldMap := generateBenchmarkMap(b, c)
+		b.Run(fmt.Sprintf("%dx%d/%d-aliens", c.Width, c.Height, c.Aliens), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := NewSimulation(
+					NewSimulationConfig(bytes.NewReader(worldMap), c.Aliens).
+						WithRandomGenerator(NewSeededGenerator(1)).
+						WithProgressHandler(&NoopSimulationProgressHandler{}).
+						WithStopCriteria(MaxIterations{Iterations: 100}).
+						WithParallelism(parallelism),
+				).Simulate()
+				if err != nil {
+					b.Fatal("Expected no error, but got", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSimulate(b *testing.B) {
+	benchmarkSimulate(b, 0)
+}
+
+// BenchmarkSimulateParallel moves the aliens using a goroutine for each CPU.
+func BenchmarkSimulateParallel(b *testing.B) {
+	benchmarkSimulate(b, runtime.NumCPU())
+}
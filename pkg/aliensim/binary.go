@@ -0,0 +1,609 @@
+package aliensim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// The binary format in which world maps and simulation snapshots can be
+// stored, so that large maps don't have to be parsed (and laid out) from text
+// each time they're used. Each file consists of:
+//
+//   - the magic bytes binaryMagic,
+//   - the format version, as a 2-byte big-endian number,
+//   - what the file contains (binaryKindMap or binaryKindSnapshot), as a byte,
+//   - the contents, made up of varint-encoded numbers and length-prefixed
+//     strings, and
+//   - the CRC-32 (IEEE) checksum of everything before it, as a 4-byte
+//     big-endian number.
+//
+// A world map is encoded as its topology and whether it has been laid out (as a
+// byte, 1 if it has), followed by its cities (in order of
+// ID) and then its roads (in order of ID). Each city is encoded as its name,
+// state, defence left, the iteration during which it was last destroyed, its
+// coordinates, its attributes (in order of key) and its neighbours (a bit mask
+// of the directions in which it has neighbours, followed by how far each
+// neighbour's ID is from its own). Each road is encoded as how far the ID of
+// the city that declared it is from that of the previous road, how far the ID
+// of the city at the other end is from that, the direction in which it leaves
+// the declaring city combined with its flags, and its length. Encoding IDs
+// relative to one another keeps most of them down to a byte or two. A snapshot
+// is encoded as the number of iterations simulated, followed by the world map
+// and then the aliens.
+const (
+	binaryMagic         = "\x89ALIENS\n"
+	binaryFormatVersion = 1
+
+	binaryKindMap      byte = 1
+	binaryKindSnapshot byte = 2
+)
+
+// The flags of an encoded road, which share its direction's varint (shifted
+// left by binaryRoadFlagBits).
+const (
+	binaryRoadFlagBits = 3
+
+	binaryRoadOneWay        = 1 << 0
+	binaryRoadLengthDefined = 1 << 1
+	binaryRoadDestroyed     = 1 << 2
+)
+
+// The flags of an encoded alien.
+const (
+	binaryAlienAlive  = 1 << 0
+	binaryAlienOnRoad = 1 << 1
+)
+
+// Snapshot captures the state of a simulation after an iteration.
+type Snapshot struct {
+	Iteration int             // The number of iterations simulated when the snapshot was taken.
+	WorldMap  *WorldMap       // The world map as it stood, including any destroyed cities and roads.
+	Aliens    []SnapshotAlien // Each of the aliens, in order of ID.
+}
+
+// SnapshotAlien is where an alien was, and how it was doing, when a snapshot
+// was taken.
+type SnapshotAlien struct {
+	ID       int
+	Species  string // The name of the alien's species.
+	Alive    bool
+	City     string // The city the alien was in, or the one it last left if it was on a road.
+	OnRoadTo string // The city the alien was travelling to, if it was on a road.
+	Progress int    // How far along the road the alien had travelled, if it was on a road.
+	Distance int    // The total distance the alien had travelled.
+	Energy   int    // How many more moves the alien could make before it was exhausted.
+}
+
+// binaryEncoder writes the contents of a binary file, keeping track of their
+// checksum and of the first error encountered.
+type binaryEncoder struct {
+	out io.Writer // Where the checksum is written, once everything else has been.
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+// binaryDecoder reads the contents of a binary file, keeping track of their
+// checksum and of the first error encountered. The input is buffered here,
+// rather than by a bufio.Reader, so that bytes can be added to the checksum a
+// buffer's worth at a time once they've been read.
+type binaryDecoder struct {
+	r        io.Reader
+	buf      []byte
+	pos, end int // The next byte to read from buf, and where the bytes read into it end.
+	crc      hash.Hash32
+	err      error
+	// The longest string that will be read, which keeps a corrupt length from
+	// using up all of the memory.
+	maxStringLength int
+}
+
+// IsBinary checks whether the given data starts like a binary map or snapshot.
+func IsBinary(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryMagic))
+}
+
+// WriteBinaryMap writes the given world map to the given writer in the binary
+// format, which ParseWorldMap (or ReadBinaryMap) reads back in.
+func WriteBinaryMap(w io.Writer, m *WorldMap) error {
+	e := newBinaryEncoder(w, binaryKindMap)
+	e.worldMap(m)
+	return e.finish()
+}
+
+// ReadBinaryMap reads a world map written by WriteBinaryMap from the given
+// reader.
+func ReadBinaryMap(r io.Reader) (*WorldMap, error) {
+	return readBinaryMap(r, DefaultMaxLineLength)
+}
+
+// readBinaryMap reads a world map in the binary format from the given reader,
+// failing if any of the strings in it is longer than the given length.
+func readBinaryMap(r io.Reader, maxStringLength int) (*WorldMap, error) {
+	d, err := newBinaryDecoder(r, binaryKindMap)
+	if err != nil {
+		return nil, err
+	}
+	d.maxStringLength = maxStringLength
+	m := d.worldMap()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WriteSnapshot writes the current state of the given (started) simulation to
+// the given writer in the binary format, which ReadSnapshot reads back in.
+func (s *Simulation) WriteSnapshot(w io.Writer) error {
+	e := newBinaryEncoder(w, binaryKindSnapshot)
+	e.uvarint(uint64(s.iterationsSimulated))
+	e.worldMap(s.worldMap)
+	e.uvarint(uint64(len(s.aliens)))
+	for _, alien := range s.aliens {
+		flags := 0
+		if alien.alive {
+			flags |= binaryAlienAlive
+		}
+		if alien.state == AlienOnRoad {
+			flags |= binaryAlienOnRoad
+		}
+		e.uvarint(uint64(flags))
+		e.str(alien.species.Name)
+		e.uvarint(uint64(alien.city.id))
+		if alien.state == AlienOnRoad {
+			e.uvarint(uint64(alien.road.id))
+			e.uvarint(uint64(alien.progress))
+		}
+		e.uvarint(uint64(alien.distance))
+		e.varint(int64(alien.energy))
+	}
+	return e.finish()
+}
+
+// ReadSnapshot reads a snapshot written by WriteSnapshot from the given reader.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	d, err := newBinaryDecoder(r, binaryKindSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{Iteration: d.count(), Aliens: []SnapshotAlien{}}
+	snapshot.WorldMap = d.worldMap()
+	if d.err != nil {
+		return nil, d.err
+	}
+	m := snapshot.WorldMap
+	aliens := d.count()
+	for id := 0; id < aliens && d.err == nil; id++ {
+		flags := d.uvarint()
+		alien := SnapshotAlien{
+			ID:      id,
+			Alive:   flags&binaryAlienAlive != 0,
+			Species: d.str(),
+		}
+		city := d.city(m)
+		if city != nil {
+			alien.City = city.name
+		}
+		if flags&binaryAlienOnRoad != 0 {
+			if road := d.road(m); road != nil && (road.from == city || road.to == city) {
+				alien.OnRoadTo = road.Other(city).name
+			} else {
+				d.fail("an alien is on a road that doesn't lead from its city")
+			}
+			alien.Progress = d.count()
+		}
+		alien.Distance = d.count()
+		alien.Energy = int(d.varint())
+		snapshot.Aliens = append(snapshot.Aliens, alien)
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func newBinaryEncoder(w io.Writer, kind byte) *binaryEncoder {
+	e := &binaryEncoder{out: w, crc: crc32.NewIEEE()}
+	e.w = bufio.NewWriter(io.MultiWriter(w, e.crc))
+	e.w.WriteString(binaryMagic)
+	binary.BigEndian.PutUint16(e.buf[:2], binaryFormatVersion)
+	e.w.Write(e.buf[:2])
+	e.w.WriteByte(kind)
+	return e
+}
+
+func (e *binaryEncoder) uvarint(v uint64) {
+	if e.err == nil {
+		_, e.err = e.w.Write(e.buf[:binary.PutUvarint(e.buf[:], v)])
+	}
+}
+
+func (e *binaryEncoder) varint(v int64) {
+	if e.err == nil {
+		_, e.err = e.w.Write(e.buf[:binary.PutVarint(e.buf[:], v)])
+	}
+}
+
+func (e *binaryEncoder) str(s string) {
+	e.uvarint(uint64(len(s)))
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+func (e *binaryEncoder) worldMap(m *WorldMap) {
+	e.uvarint(uint64(m.topology))
+	if m.laidOut {
+		e.uvarint(1)
+	} else {
+		e.uvarint(0)
+	}
+	e.uvarint(uint64(len(m.cities)))
+	for _, city := range m.cities {
+		e.str(city.name)
+		e.uvarint(uint64(city.state))
+		e.uvarint(uint64(city.defence))
+		e.varint(int64(city.destroyedAt))
+		e.varint(int64(city.x))
+		e.varint(int64(city.y))
+		e.varint(int64(city.z))
+		keys := []string{}
+		for key := range city.attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		e.uvarint(uint64(len(keys)))
+		for _, key := range keys {
+			e.str(key)
+			e.str(city.attributes[key])
+		}
+		mask := uint64(0)
+		for dir, neighbour := range city.neighbours {
+			if neighbour != nil {
+				mask |= 1 << uint(dir)
+			}
+		}
+		e.uvarint(mask)
+		for _, neighbour := range city.neighbours {
+			if neighbour != nil {
+				e.varint(int64(neighbour.id - city.id))
+			}
+		}
+	}
+	e.uvarint(uint64(len(m.roads)))
+	previous := 0
+	for _, road := range m.roads {
+		dir := 0
+		for d, r := range road.from.roads {
+			if r == road {
+				dir = d
+			}
+		}
+		flags := 0
+		if road.oneWay {
+			flags |= binaryRoadOneWay
+		}
+		if road.lengthDefined {
+			flags |= binaryRoadLengthDefined
+		}
+		if road.destroyed {
+			flags |= binaryRoadDestroyed
+		}
+		e.varint(int64(road.from.id - previous))
+		e.varint(int64(road.to.id - road.from.id))
+		e.uvarint(uint64(dir<<binaryRoadFlagBits | flags))
+		e.uvarint(uint64(road.length))
+		previous = road.from.id
+	}
+}
+
+// finish writes out the checksum of everything written so far.
+func (e *binaryEncoder) finish() error {
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	if e.err == nil {
+		binary.BigEndian.PutUint32(e.buf[:4], e.crc.Sum32())
+		// the checksum mustn't be included in itself, so it bypasses the
+		// writer that keeps track of it
+		_, e.err = e.out.Write(e.buf[:4])
+	}
+	return e.err
+}
+
+func newBinaryDecoder(r io.Reader, kind byte) (*binaryDecoder, error) {
+	d := &binaryDecoder{
+		r:               r,
+		buf:             make([]byte, 64*1024),
+		crc:             crc32.NewIEEE(),
+		maxStringLength: DefaultMaxLineLength,
+	}
+	header := make([]byte, len(binaryMagic)+3)
+	if _, err := io.ReadFull(d, header); err != nil || !IsBinary(header) {
+		return nil, NewExtendedSimulationError(ErrInvalidBinaryFormat, "The input is not in the binary format.", nil)
+	}
+	version := binary.BigEndian.Uint16(header[len(binaryMagic):])
+	if version != binaryFormatVersion {
+		return nil, NewExtendedSimulationError(
+			ErrInvalidBinaryFormat,
+			fmt.Sprintf("Version %d of the binary format is not supported (only version %d is).", version, binaryFormatVersion),
+			nil,
+		)
+	}
+	if k := header[len(header)-1]; k != kind {
+		return nil, NewExtendedSimulationError(
+			ErrInvalidBinaryFormat,
+			fmt.Sprintf("Expected a %s, but got a %s.", binaryKindName(kind), binaryKindName(k)),
+			nil,
+		)
+	}
+	return d, nil
+}
+
+func binaryKindName(kind byte) string {
+	switch kind {
+	case binaryKindMap:
+		return "world map"
+	case binaryKindSnapshot:
+		return "snapshot"
+	}
+	return "file of unknown kind"
+}
+
+func (d *binaryDecoder) fail(problem string) {
+	if d.err == nil {
+		d.err = NewExtendedSimulationError(ErrInvalidBinaryFormat, fmt.Sprintf("The input is corrupt (%s).", problem), nil)
+	}
+}
+
+// fill adds the bytes read so far to the checksum, and reads more of the input
+// into the space they took up.
+func (d *binaryDecoder) fill() error {
+	d.crc.Write(d.buf[:d.pos])
+	d.end = copy(d.buf, d.buf[d.pos:d.end])
+	d.pos = 0
+	n, err := d.r.Read(d.buf[d.end:])
+	d.end += n
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
+
+// checksum returns the checksum of the bytes read so far.
+func (d *binaryDecoder) checksum() uint32 {
+	d.crc.Write(d.buf[:d.pos])
+	d.end = copy(d.buf, d.buf[d.pos:d.end])
+	d.pos = 0
+	return d.crc.Sum32()
+}
+
+// ReadByte implements io.ByteReader.
+func (d *binaryDecoder) ReadByte() (byte, error) {
+	if d.pos == d.end {
+		if err := d.fill(); err != nil {
+			return 0, err
+		}
+	}
+	d.pos++
+	return d.buf[d.pos-1], nil
+}
+
+// Read implements io.Reader.
+func (d *binaryDecoder) Read(p []byte) (int, error) {
+	if d.pos == d.end {
+		if err := d.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf[d.pos:d.end])
+	d.pos += n
+	return n, nil
+}
+
+func (d *binaryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d)
+	if err != nil {
+		d.fail("it ends too soon")
+	}
+	return v
+}
+
+func (d *binaryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d)
+	if err != nil {
+		d.fail("it ends too soon")
+	}
+	return v
+}
+
+// count reads a non-negative number that fits in an int.
+func (d *binaryDecoder) count() int {
+	v := d.uvarint()
+	if v > uint64(^uint(0)>>1) {
+		d.fail("a number is too large")
+		return 0
+	}
+	return int(v)
+}
+
+func (d *binaryDecoder) str() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	if n > d.maxStringLength {
+		d.fail("a string is too long")
+		return ""
+	}
+	if d.end-d.pos < n && n <= len(d.buf) {
+		// most strings fit in what's left of the buffer once it's topped up, so
+		// there's no need to read them into one of their own first
+		for d.end-d.pos < n {
+			if err := d.fill(); err != nil {
+				d.fail("it ends too soon")
+				return ""
+			}
+		}
+	}
+	if d.end-d.pos >= n {
+		d.pos += n
+		return string(d.buf[d.pos-n : d.pos])
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d, buf); err != nil {
+		d.fail("it ends too soon")
+		return ""
+	}
+	return string(buf)
+}
+
+// city reads the ID of a city on the given world map.
+func (d *binaryDecoder) city(m *WorldMap) *City {
+	id := d.count()
+	if d.err == nil && id >= len(m.cities) {
+		d.fail("a city doesn't exist")
+	}
+	if d.err != nil {
+		return nil
+	}
+	return m.cities[id]
+}
+
+// road reads the ID of a road on the given world map.
+func (d *binaryDecoder) road(m *WorldMap) *Road {
+	id := d.count()
+	if d.err == nil && id >= len(m.roads) {
+		d.fail("a road doesn't exist")
+	}
+	if d.err != nil {
+		return nil
+	}
+	return m.roads[id]
+}
+
+func (d *binaryDecoder) worldMap() *WorldMap {
+	m := NewEmptyWorldMap()
+	m.topology = Topology(d.uvarint())
+	if m.topology != TopologyFourWay && m.topology != TopologyEightWay {
+		d.fail("the topology is unknown")
+	}
+	m.laidOut = d.uvarint() == 1
+	cities := d.count()
+	neighbours := [][numDirections]int{}
+	for i := 0; i < cities && d.err == nil; i++ {
+		city := m.addCity(d.str())
+		if city.id != i {
+			d.fail(fmt.Sprintf("%s appears twice", city.name))
+		}
+		city.state = CityState(d.uvarint())
+		if city.state != CityIntact && city.state != CityUnderSiege && city.state != CityDestroyed {
+			d.fail(fmt.Sprintf("the state of %s is unknown", city.name))
+		}
+		city.defence = d.count()
+		city.destroyedAt = int(d.varint())
+		city.x, city.y, city.z = int(d.varint()), int(d.varint()), int(d.varint())
+		attributes := d.count()
+		for j := 0; j < attributes && d.err == nil; j++ {
+			if city.attributes == nil {
+				city.attributes = map[string]string{}
+			}
+			key := d.str()
+			city.attributes[key] = d.str()
+		}
+		// neighbours can only be linked up once all of the cities are known
+		mask := d.uvarint()
+		if mask >= 1<<numDirections {
+			d.fail("a direction is unknown")
+		}
+		ids := [numDirections]int{}
+		for dir := range ids {
+			ids[dir] = -1
+			if mask&(1<<uint(dir)) != 0 {
+				if ids[dir] = i + int(d.varint()); ids[dir] < 0 {
+					d.fail("a neighbour doesn't exist")
+				}
+			}
+		}
+		neighbours = append(neighbours, ids)
+	}
+	for i, ids := range neighbours {
+		for dir, id := range ids {
+			if id >= len(m.cities) {
+				d.fail("a neighbour doesn't exist")
+			} else if id >= 0 {
+				m.cities[i].neighbours[dir] = m.cities[id]
+			}
+		}
+	}
+
+	roads := d.count()
+	previous := 0
+	for i := 0; i < roads && d.err == nil; i++ {
+		fromID := previous + int(d.varint())
+		toID := fromID + int(d.varint())
+		dirFlags, length := d.uvarint(), d.count()
+		if d.err != nil {
+			break
+		}
+		if fromID < 0 || fromID >= len(m.cities) || toID < 0 || toID >= len(m.cities) {
+			d.fail("a road leads to a city that doesn't exist")
+			break
+		}
+		from, to := m.cities[fromID], m.cities[toID]
+		dir, flags := int(dirFlags>>binaryRoadFlagBits), dirFlags&(1<<binaryRoadFlagBits-1)
+		previous = fromID
+		if dir < 0 || dir >= numDirections || from.neighbours[dir] != to || from.roads[dir] != nil || length < 1 {
+			d.fail(fmt.Sprintf("the road from %s to %s is invalid", from.name, to.name))
+			break
+		}
+		road := NewRoad(from, to, flags&binaryRoadOneWay != 0)
+		road.id = len(m.roads)
+		road.length = length
+		road.lengthDefined = flags&binaryRoadLengthDefined != 0
+		road.destroyed = flags&binaryRoadDestroyed != 0
+		from.roads[dir] = road
+		if dopp := mapDirectionOpposites[dir]; to.neighbours[dopp] == from {
+			to.roads[dopp] = road
+		}
+		m.roads = append(m.roads, road)
+	}
+	return m
+}
+
+// finish checks the checksum of everything read so far, and that there's
+// nothing after it.
+func (d *binaryDecoder) finish() error {
+	if d.err != nil {
+		return d.err
+	}
+	expected := d.checksum()
+	sum := make([]byte, 4)
+	if _, err := io.ReadFull(d, sum); err != nil {
+		d.fail("it ends too soon")
+		return d.err
+	}
+	if binary.BigEndian.Uint32(sum) != expected {
+		return NewExtendedSimulationError(ErrInvalidBinaryFormat, "The checksum doesn't match (the input is corrupt).", nil)
+	}
+	if _, err := d.ReadByte(); err != io.EOF {
+		d.fail("there's more after the checksum")
+	}
+	return d.err
+}
@@ -0,0 +1,223 @@
+package aliensim
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// toBinaryMap parses the given text world map and writes it out in the binary
+// format.
+func toBinaryMap(t *testing.T, worldMap string) (*WorldMap, []byte) {
+	m, err := ParseWorldMap(strings.NewReader(worldMap))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	var buf bytes.Buffer
+	if err := WriteBinaryMap(&buf, m); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	return m, buf.Bytes()
+}
+
+// describeRoads describes each of the given world map's roads, as well as
+// which of its cities' slots refer to them.
+func describeRoads(m *WorldMap) []string {
+	described := []string{}
+	for _, road := range m.Roads() {
+		described = append(described, fmt.Sprintf("%d %s defined=%t destroyed=%t", road.id, road, road.lengthDefined, road.destroyed))
+	}
+	for _, city := range m.Cities() {
+		for dir, road := range city.roads {
+			if road != nil {
+				described = append(described, fmt.Sprintf("%s %d %d", city.name, dir, road.id))
+			}
+		}
+	}
+	return described
+}
+
+func TestBinaryMapRoundTrip(t *testing.T) {
+	maps := []string{
+		ExampleWorld,
+		eightWayTestMap,
+		multiLevelTestMap,
+		oneWayTestMap,
+		"Foo[def=3,spawn] north=Bar east=Baz:4\nBar[colour=red] east>=Qux:2\nQux south=Baz\n",
+	}
+	for _, worldMap := range maps {
+		expected, data := toBinaryMap(t, worldMap)
+		m, err := ReadBinaryMap(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if m.Render() != expected.Render() {
+			t.Errorf("Expected:\n%s\nbut got:\n%s", expected.Render(), m.Render())
+		}
+		if m.Topology() != expected.Topology() {
+			t.Error("Expected topology", expected.Topology(), "but got", m.Topology())
+		}
+		if !stringSlicesEqual(describeRoads(m), describeRoads(expected)) {
+			t.Errorf("Expected roads %v, but got %v", describeRoads(expected), describeRoads(m))
+		}
+		for i, city := range m.Cities() {
+			other := expected.Cities()[i]
+			if city.id != i || m.city(city.name) != city {
+				t.Error("Expected", city.name, "to be found by its name and ID")
+			}
+			if x, y, z := city.Coordinates(); x != other.x || y != other.y || z != other.z {
+				t.Error("Expected", city.name, "to keep its coordinates, but got", x, y, z)
+			}
+			if !reflect.DeepEqual(city.attributes, other.attributes) || city.defence != other.defence {
+				t.Error("Expected", city.name, "to keep its attributes, but got", city.attributes)
+			}
+		}
+	}
+}
+
+func TestSimulatingBinaryMap(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenerateGridMap(&buf, 20, 20); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	_, data := toBinaryMap(t, buf.String())
+	results := []*SimulationResult{}
+	for _, worldMap := range [][]byte{buf.Bytes(), data} {
+		res, err := NewSimulation(
+			NewSimulationConfig(bytes.NewReader(worldMap), 50).
+				WithRandomGenerator(NewSeededGenerator(7)).
+				WithProgressHandler(&NoopSimulationProgressHandler{}),
+		).Simulate()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		results = append(results, res)
+	}
+	if results[0].TotalMoves != results[1].TotalMoves ||
+		!stringSlicesEqual(results[0].CitiesRemaining, results[1].CitiesRemaining) ||
+		!reflect.DeepEqual(results[0].AlienDistances, results[1].AlienDistances) {
+		t.Error("Expected the same simulation from a binary map as from the text map it was converted from")
+	}
+}
+
+func TestParseDetectsBinaryMap(t *testing.T) {
+	expected, data := toBinaryMap(t, ExampleWorld)
+	handler := &recordingParseProgressHandler{}
+	m, err := NewMapParser().WithProgressHandler(handler, 0).Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if m.Render() != expected.Render() {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected.Render(), m.Render())
+	}
+	if last := handler.reports[len(handler.reports)-1]; !last.Done || last.BytesRead != int64(len(data)) || last.Cities != 5 {
+		t.Error("Expected the whole binary map to have been read, but got", last)
+	}
+
+	filename, cleanup := writeTestMapFile(t, data)
+	defer cleanup()
+	for _, memoryMap := range []bool{false, true} {
+		f, err := OpenMapFile(filename, memoryMap)
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		m, err := ParseWorldMap(f)
+		f.Close()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if m.Render() != expected.Render() {
+			t.Errorf("Expected the binary map file (memoryMap=%t) to be read just like the map it contains", memoryMap)
+		}
+	}
+}
+
+func TestInvalidBinaryMap(t *testing.T) {
+	_, data := toBinaryMap(t, ExampleWorld)
+	corrupt := func(i int, b byte) []byte {
+		c := append([]byte{}, data...)
+		c[i] = b
+		return c
+	}
+	tests := map[string][]byte{
+		"bad magic":       corrupt(1, 'X'),
+		"unknown version": corrupt(len(binaryMagic)+1, 9),
+		"snapshot":        corrupt(len(binaryMagic)+2, binaryKindSnapshot),
+		"corrupt":         corrupt(len(data)/2, data[len(data)/2]^0xff),
+		"bad checksum":    corrupt(len(data)-1, data[len(data)-1]^0xff),
+		"truncated":       data[:len(data)-10],
+		"trailing data":   append(append([]byte{}, data...), 0),
+		"empty":           []byte{},
+	}
+	for name, input := range tests {
+		_, err := ReadBinaryMap(bytes.NewReader(input))
+		if serr, ok := err.(*SimulationError); !ok || serr.Code() != ErrInvalidBinaryFormat {
+			t.Errorf("Expected ErrInvalidBinaryFormat for %s input, but got %v", name, err)
+		}
+	}
+}
+
+func TestParsingBinaryMapHonoursMaxLineLength(t *testing.T) {
+	_, data := toBinaryMap(t, "Foo east=Barbarossa\n")
+	_, err := NewMapParser().WithMaxLineLength(5).Parse(bytes.NewReader(data))
+	if serr, ok := err.(*SimulationError); !ok || serr.Code() != ErrInvalidBinaryFormat {
+		t.Error("Expected ErrInvalidBinaryFormat for a city name longer than the maximum line length, but got", err)
+	}
+	if _, err := NewMapParser().WithMaxLineLength(10).Parse(bytes.NewReader(data)); err != nil {
+		t.Error("Expected no error, but got", err)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	sim := NewSimulation(
+		NewSimulationConfig(strings.NewReader("Foo east=Bar:3 north=Baz\nBar north=Qux\n"), 4).
+			WithRandomGenerator(NewSeededGenerator(3)).
+			WithProgressHandler(&NoopSimulationProgressHandler{}),
+	)
+	if err := sim.Start(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if _, err := sim.Step(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	var buf bytes.Buffer
+	if err := sim.WriteSnapshot(&buf); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if _, err := ReadBinaryMap(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("Expected a snapshot not to be read as a world map")
+	}
+
+	snapshot, err := ReadSnapshot(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if snapshot.Iteration != sim.iterationsSimulated {
+		t.Error("Expected the snapshot to be taken after iteration", sim.iterationsSimulated, "but got", snapshot.Iteration)
+	}
+	if snapshot.WorldMap.Render() != sim.WorldMap().Render() {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", sim.WorldMap().Render(), snapshot.WorldMap.Render())
+	}
+	if len(snapshot.Aliens) != len(sim.Aliens()) {
+		t.Fatal("Expected", len(sim.Aliens()), "aliens, but got", len(snapshot.Aliens))
+	}
+	for i, alien := range sim.Aliens() {
+		expected := SnapshotAlien{
+			ID:       alien.id,
+			Species:  alien.species.Name,
+			Alive:    alien.alive,
+			City:     alien.city.name,
+			Distance: alien.distance,
+			Energy:   alien.energy,
+		}
+		if alien.state == AlienOnRoad {
+			expected.OnRoadTo = alien.road.Other(alien.city).name
+			expected.Progress = alien.progress
+		}
+		if snapshot.Aliens[i] != expected {
+			t.Errorf("Expected alien %+v, but got %+v", expected, snapshot.Aliens[i])
+		}
+	}
+}
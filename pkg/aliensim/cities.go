@@ -1,91 +1,175 @@
 package aliensim
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
 
-// City direction indices.
+// City direction indices. The diagonal directions are only available on maps
+// using the eight-way topology, while Up and Down link cities on different
+// levels of the map.
 const (
-	DirNorth int = 0
-	DirEast  int = 1
-	DirSouth int = 2
-	DirWest  int = 3
+	DirNorth     int = 0
+	DirEast      int = 1
+	DirSouth     int = 2
+	DirWest      int = 3
+	DirNorthEast int = 4
+	DirSouthEast int = 5
+	DirSouthWest int = 6
+	DirNorthWest int = 7
+	DirUp        int = 8
+	DirDown      int = 9
 )
 
+// direction describes one of the directions in which cities can be linked,
+// along with the offset that moving in that direction has on a city's
+// coordinates.
+type direction struct {
+	name       string // The human-readable name of the direction.
+	opposite   int    // The index of the opposite direction.
+	dx, dy, dz int    // The change in coordinates when moving in this direction.
+	diagonal   bool   // Does this direction require the eight-way topology?
+}
+
+// directions is indexed by the direction indices above. Each city has one
+// neighbour slot per direction.
+var directions = []direction{
+	DirNorth:     {name: "North", opposite: DirSouth, dy: 1},
+	DirEast:      {name: "East", opposite: DirWest, dx: 1},
+	DirSouth:     {name: "South", opposite: DirNorth, dy: -1},
+	DirWest:      {name: "West", opposite: DirEast, dx: -1},
+	DirNorthEast: {name: "NorthEast", opposite: DirSouthWest, dx: 1, dy: 1, diagonal: true},
+	DirSouthEast: {name: "SouthEast", opposite: DirNorthWest, dx: 1, dy: -1, diagonal: true},
+	DirSouthWest: {name: "SouthWest", opposite: DirNorthEast, dx: -1, dy: -1, diagonal: true},
+	DirNorthWest: {name: "NorthWest", opposite: DirSouthEast, dx: -1, dy: 1, diagonal: true},
+	DirUp:        {name: "Up", opposite: DirDown, dz: 1},
+	DirDown:      {name: "Down", opposite: DirUp, dz: -1},
+}
+
+// numDirections is the number of neighbour slots each city has.
+const numDirections = DirDown + 1
+
+// Lookups derived from the directions table.
 var (
-	mapDirections = map[string]int{
-		"north": DirNorth,
-		"east":  DirEast,
-		"south": DirSouth,
-		"west":  DirWest,
-	}
-	mapDirectionOpposites = map[int]int{
-		DirNorth: DirSouth,
-		DirEast:  DirWest,
-		DirSouth: DirNorth,
-		DirWest:  DirEast,
-	}
-	directionNames = map[int]string{
-		DirNorth: "North",
-		DirEast:  "East",
-		DirSouth: "South",
-		DirWest:  "West",
+	mapDirections         = map[string]int{}
+	mapDirectionOpposites = map[int]int{}
+	directionNames        = map[int]string{}
+)
+
+func init() {
+	for d, info := range directions {
+		mapDirections[strings.ToLower(info.name)] = d
+		mapDirectionOpposites[d] = info.opposite
+		directionNames[d] = info.name
 	}
+}
+
+// CityState describes whether a city is still standing.
+type CityState int
+
+// The possible states of a city.
+const (
+	// CityIntact means the city has not been attacked yet.
+	CityIntact CityState = 0
+	// CityUnderSiege means the city has repelled at least one alien attack,
+	// but is still standing.
+	CityUnderSiege CityState = 1
+	// CityDestroyed means the city has been destroyed by the aliens.
+	CityDestroyed CityState = 2
 )
 
+// CityAttrDefence is the attribute used in the input map to specify how many
+// alien attacks a city can repel before falling (e.g. "Foo[def=3]").
+const CityAttrDefence = "def"
+
+func (s CityState) String() string {
+	switch s {
+	case CityIntact:
+		return "intact"
+	case CityUnderSiege:
+		return "under siege"
+	case CityDestroyed:
+		return "destroyed"
+	}
+	return "unknown"
+}
+
 // City represents a single city on the world map. Effectively implements a
 // multidimensional linked list to allow for map traversal in a relatively
 // memory-efficient manner.
 type City struct {
-	name       string  // The name of this city, as read from the input map.
-	destroyed  bool    // Has the city been destroyed yet?
-	neighbours []*City // An indexed list of neighbours (0=North, 1=East, 2=South, 3=West).
-	x, y       int     // The calculated coordinates of this city on the map.
+	id          int                  // The index of this city in its world map's list of cities.
+	name        string               // The name of this city, as read from the input map.
+	state       CityState            // Is the city still standing?
+	defence     int                  // How many more alien attacks the city can repel.
+	destroyedAt int                  // The iteration during which the city was last destroyed.
+	attributes  map[string]string    // Any additional attributes given for the city in the input map (nil if there are none).
+	neighbours  [numDirections]*City // An indexed list of neighbours (see the direction indices above).
+	roads       [numDirections]*Road // The roads to each of the neighbours, indexed like the neighbours.
+	x, y, z     int                  // The calculated coordinates of this city on the map (z is the level).
 }
 
-// NewCity creates a fresh new city, not yet destroyed, with no neighbours.
+// NewCity creates a fresh new, intact city, with no neighbours or defences.
 func NewCity(name string) *City {
 	return &City{
-		name:       name,
-		destroyed:  false,
-		neighbours: []*City{nil, nil, nil, nil},
-		x:          0,
-		y:          0,
+		name:    name,
+		state:   CityIntact,
+		defence: 0,
+		x:       0,
+		y:       0,
+		z:       0,
 	}
 }
 
 func (c *City) String() string {
-	neighbours := "nil"
-	if c.neighbours != nil {
-		neighbours = ""
-		for i, n := range c.neighbours {
-			nname := "nil"
-			if n != nil {
-				nname = n.name
-			}
-			if i > 0 {
-				neighbours = fmt.Sprintf("%s, ", neighbours)
-			}
-			neighbours = fmt.Sprintf("%s%s: %s", neighbours, directionNames[i], nname)
+	neighbours := ""
+	for i, n := range c.neighbours {
+		nname := "nil"
+		if n != nil {
+			nname = n.name
+		} else if i > DirWest {
+			// only mention the extended directions when they're in use
+			continue
+		}
+		if i > 0 {
+			neighbours = fmt.Sprintf("%s, ", neighbours)
 		}
+		neighbours = fmt.Sprintf("%s%s: %s", neighbours, directionNames[i], nname)
 	}
 	return fmt.Sprintf(
-		"City{name: %s, destroyed: %t, neighbours: {%s}, x: %d, y: %d}",
+		"City{name: %s, state: %s, defence: %d, neighbours: {%s}, x: %d, y: %d, z: %d}",
 		c.name,
-		c.destroyed,
+		c.state,
+		c.defence,
 		neighbours,
 		c.x,
 		c.y,
+		c.z,
 	)
 }
 
 // LocateRelativeTo will ensure that the given other city is located to the
-// (dir) of this city. If the direction is unrecognised, returns an error.
+// (dir) of this city, with a two-way road between the two cities. If the
+// direction is unrecognised, returns an error.
 func (c *City) LocateRelativeTo(otherCity *City, dir string) error {
+	return c.locateRelativeTo(otherCity, dir, false)
+}
+
+// LocateOneWayRelativeTo works like LocateRelativeTo, but the road between the
+// two cities can only be travelled from this city to the other one.
+func (c *City) LocateOneWayRelativeTo(otherCity *City, dir string) error {
+	return c.locateRelativeTo(otherCity, dir, true)
+}
+
+func (c *City) locateRelativeTo(otherCity *City, dir string, oneWay bool) error {
 	d, ok := mapDirections[dir]
 	if !ok {
 		return NewExtendedSimulationError(
 			ErrUnknownDirection,
 			fmt.Sprintf(
-				"Unknown direction %s (must be one of north, east, south or west).",
+				"Unknown direction %s (must be one of north, east, south, west, northeast, southeast, southwest, northwest, up or down).",
 				dir,
 			),
 			nil,
@@ -95,8 +179,8 @@ func (c *City) LocateRelativeTo(otherCity *City, dir string) error {
 
 	existingNeighbour := c.neighbours[d]
 	if existingNeighbour != nil {
-		// if there's already another city in that direction by a different name
-		if existingNeighbour.name != otherCity.name {
+		// if there's already another city in that direction
+		if existingNeighbour != otherCity {
 			return NewExtendedSimulationError(
 				ErrCityAlreadyThere,
 				fmt.Sprintf(
@@ -115,7 +199,7 @@ func (c *City) LocateRelativeTo(otherCity *City, dir string) error {
 
 	existingNeighbour = otherCity.neighbours[dopp]
 	if existingNeighbour != nil {
-		if existingNeighbour.name != c.name {
+		if existingNeighbour != c {
 			return NewExtendedSimulationError(
 				ErrCityAlreadyThere,
 				fmt.Sprintf(
@@ -132,22 +216,115 @@ func (c *City) LocateRelativeTo(otherCity *City, dir string) error {
 		otherCity.neighbours[dopp] = c
 	}
 
+	c.buildRoad(d, oneWay)
 	return nil
 }
 
+// Name returns the name of this city.
+func (c *City) Name() string {
+	return c.name
+}
+
+// State returns the current state of this city.
+func (c *City) State() CityState {
+	return c.state
+}
+
+// Destroyed returns whether this city has been destroyed.
+func (c *City) Destroyed() bool {
+	return c.state == CityDestroyed
+}
+
+// Defence returns how many more alien attacks this city can repel before it
+// falls.
+func (c *City) Defence() int {
+	return c.defence
+}
+
+// Attribute returns the value of the given attribute of this city, as
+// specified in the input map, and whether the attribute was specified at all.
+func (c *City) Attribute(key string) (string, bool) {
+	value, ok := c.attributes[key]
+	return value, ok
+}
+
+// setAttribute sets the given attribute of this city, interpreting any of the
+// attributes known to the simulator.
+func (c *City) setAttribute(key, value string) error {
+	switch key {
+	case CityAttrDefence:
+		defence, err := strconv.Atoi(value)
+		if err != nil || defence < 0 {
+			return NewExtendedSimulationError(
+				ErrInvalidCityAttribute,
+				fmt.Sprintf(
+					"The defence of %s must be a whole number of at least 0, but got \"%s\".",
+					c.name,
+					value,
+				),
+				nil,
+			)
+		}
+		c.defence = defence
+	}
+	if c.attributes == nil {
+		c.attributes = map[string]string{}
+	}
+	c.attributes[key] = value
+	return nil
+}
+
+// renderAttributes renders this city's attributes in the input map format
+// (e.g. "[def=3]"), with the attribute keys in alphabetical order. Returns an
+// empty string if the city has no attributes.
+func (c *City) renderAttributes() string {
+	if len(c.attributes) == 0 {
+		return ""
+	}
+	keys := []string{}
+	for key := range c.attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := []string{}
+	for _, key := range keys {
+		value := c.attributes[key]
+		if key == CityAttrDefence {
+			value = strconv.Itoa(c.defence)
+		}
+		if value == "true" {
+			attrs = append(attrs, key)
+		} else {
+			attrs = append(attrs, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	return fmt.Sprintf("[%s]", strings.Join(attrs, ","))
+}
+
+// Coordinates returns the computed location of this city on the map, where z
+// is the level of the map on which the city is found.
+func (c *City) Coordinates() (x, y, z int) {
+	return c.x, c.y, c.z
+}
+
 // Depending on how the cities' relative locations are specified in the input
 // file, there are times when, for example, a city to the North of our current
 // city isn't aware of a city to its East, but the city to the East of our
 // current city is aware of that city to its North. This refreshes the
-// neighbouring cities' locations.
-func (c *City) recomputeNeighbours() {
+// neighbouring cities' locations. On eight-way maps, the diagonal links are
+// also inferred from (and made consistent with) the orthogonal ones.
+func (c *City) recomputeNeighbours(topology Topology) {
 	north := c.neighbours[DirNorth]
 	east := c.neighbours[DirEast]
 	south := c.neighbours[DirSouth]
 	west := c.neighbours[DirWest]
 
-	// the neighbours at the corners
-	var nw, ne, se, sw *City = nil, nil, nil, nil
+	// the neighbours at the corners (seeded from any explicitly declared
+	// diagonal links, which only exist on eight-way maps)
+	ne := c.neighbours[DirNorthEast]
+	se := c.neighbours[DirSouthEast]
+	sw := c.neighbours[DirSouthWest]
+	nw := c.neighbours[DirNorthWest]
 
 	if north != nil {
 		if north.neighbours[DirWest] != nil {
@@ -198,4 +375,30 @@ func (c *City) recomputeNeighbours() {
 		west.neighbours[DirNorth] = nw
 		west.neighbours[DirSouth] = sw
 	}
+
+	if topology == TopologyEightWay {
+		c.setNeighbour(DirNorthEast, ne)
+		c.setNeighbour(DirSouthEast, se)
+		c.setNeighbour(DirSouthWest, sw)
+		c.setNeighbour(DirNorthWest, nw)
+		if north != nil {
+			north.setNeighbour(DirSouthEast, east)
+			north.setNeighbour(DirSouthWest, west)
+		}
+		if south != nil {
+			south.setNeighbour(DirNorthEast, east)
+			south.setNeighbour(DirNorthWest, west)
+		}
+	}
+}
+
+// setNeighbour links the given other city to the (dir) of this city, and this
+// city to the opposite direction of the other city. Does nothing if the other
+// city is nil.
+func (c *City) setNeighbour(dir int, otherCity *City) {
+	if otherCity == nil {
+		return
+	}
+	c.neighbours[dir] = otherCity
+	otherCity.neighbours[mapDirectionOpposites[dir]] = c
 }
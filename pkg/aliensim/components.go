@@ -0,0 +1,203 @@
+package aliensim
+
+// componentTracker keeps track of which group of intact cities (linked by
+// roads that haven't been destroyed, regardless of direction) each city belongs
+// to as cities and roads are destroyed and rebuilt, so that the size of the
+// largest group doesn't have to be recomputed from scratch for the whole map
+// after every iteration.
+type componentTracker struct {
+	component []int // The component to which each city belongs (indexed by city ID, -1 if destroyed).
+	sizes     []int // The number of cities in each component (indexed by component ID).
+	largest   int   // The size of the largest component, if known.
+	changed   bool  // Have the sizes changed since the largest component was found?
+}
+
+// newComponentTracker works out the components of the given world map as it
+// currently stands.
+func newComponentTracker(m *WorldMap) *componentTracker {
+	t := &componentTracker{component: make([]int, len(m.cities)), sizes: []int{}}
+	for i := range t.component {
+		t.component[i] = -1
+	}
+	for _, city := range m.cities {
+		if !city.Destroyed() && t.component[city.id] < 0 {
+			t.sizes = append(t.sizes, t.label(city, -1, len(t.sizes)))
+		}
+	}
+	t.changed = true
+	return t
+}
+
+// intactLinks calls the given function for each intact city linked to the
+// given city by a road that hasn't been destroyed.
+func intactLinks(city *City, f func(n *City)) {
+	for _, road := range city.roads {
+		if road == nil || road.destroyed {
+			continue
+		}
+		if n := road.Other(city); !n.Destroyed() {
+			f(n)
+		}
+	}
+}
+
+// label assigns the given component to the given city and all of the cities
+// linked to it that currently belong to the component from, returning how many
+// cities were relabelled.
+func (t *componentTracker) label(start *City, from, to int) int {
+	t.component[start.id] = to
+	pending := []*City{start}
+	labelled := 0
+	for len(pending) > 0 {
+		city := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		labelled++
+		intactLinks(city, func(n *City) {
+			if t.component[n.id] == from {
+				t.component[n.id] = to
+				pending = append(pending, n)
+			}
+		})
+	}
+	return labelled
+}
+
+// cityDestroyed removes the given (just destroyed) city from its component,
+// splitting the component up if the city was holding it together.
+func (t *componentTracker) cityDestroyed(city *City) {
+	c := t.component[city.id]
+	if c < 0 {
+		return
+	}
+	t.component[city.id] = -1
+	t.sizes[c]--
+	t.changed = true
+	neighbours := []*City{}
+	intactLinks(city, func(n *City) { neighbours = append(neighbours, n) })
+	t.split(c, neighbours)
+}
+
+// roadDestroyed splits the component linked by the given (just destroyed) road,
+// if the road was holding it together.
+func (t *componentTracker) roadDestroyed(road *Road) {
+	if road.from.Destroyed() || road.to.Destroyed() {
+		return
+	}
+	t.split(t.component[road.from.id], []*City{road.from, road.to})
+}
+
+// cityRebuilt adds the given (just rebuilt) city to the map, merging the
+// components it links together into the largest of them.
+func (t *componentTracker) cityRebuilt(city *City) {
+	if t.component[city.id] >= 0 {
+		return
+	}
+	t.changed = true
+	target := -1
+	intactLinks(city, func(n *City) {
+		if c := t.component[n.id]; target < 0 || t.sizes[c] > t.sizes[target] {
+			target = c
+		}
+	})
+	if target < 0 {
+		t.component[city.id] = len(t.sizes)
+		t.sizes = append(t.sizes, 1)
+		return
+	}
+	t.component[city.id] = target
+	t.sizes[target]++
+	intactLinks(city, func(n *City) {
+		if c := t.component[n.id]; c != target {
+			t.sizes[target] += t.label(n, c, target)
+			t.sizes[c] = 0
+		}
+	})
+}
+
+// split checks whether the given cities, which all used to belong to the given
+// component, are still linked to one another, giving any groups of them that
+// have been cut off from the rest components of their own. The cities are
+// searched outwards from in turns, so that the work done is proportional to
+// the size of the groups that have been cut off rather than to the size of the
+// whole component.
+func (t *componentTracker) split(c int, starts []*City) {
+	// each search starts off as its own group, and groups are merged as their
+	// searches run into each other
+	owner := map[*City]int{}
+	parent := []int{}
+	pending := [][]*City{}
+	visited := [][]*City{}
+	for _, city := range starts {
+		if _, seen := owner[city]; seen {
+			continue
+		}
+		owner[city] = len(parent)
+		parent = append(parent, len(parent))
+		pending = append(pending, []*City{city})
+		visited = append(visited, []*City{city})
+	}
+	find := func(g int) int {
+		for parent[g] != g {
+			parent[g] = parent[parent[g]]
+			g = parent[g]
+		}
+		return g
+	}
+	active := len(parent)
+	for active > 1 {
+		for g := range parent {
+			if active <= 1 {
+				break
+			}
+			if find(g) != g || pending[g] == nil {
+				continue
+			}
+			if len(pending[g]) == 0 {
+				// this group has been cut off from the others
+				id := len(t.sizes)
+				t.sizes = append(t.sizes, len(visited[g]))
+				t.sizes[c] -= len(visited[g])
+				t.changed = true
+				for _, city := range visited[g] {
+					t.component[city.id] = id
+				}
+				pending[g], visited[g] = nil, nil
+				active--
+				continue
+			}
+			city := pending[g][len(pending[g])-1]
+			pending[g] = pending[g][:len(pending[g])-1]
+			intactLinks(city, func(n *City) {
+				other, seen := owner[n]
+				if !seen {
+					owner[n] = g
+					pending[g] = append(pending[g], n)
+					visited[g] = append(visited[g], n)
+					return
+				}
+				if other = find(other); other != g && pending[other] != nil {
+					// the two searches have met, so they're still linked
+					parent[other] = g
+					pending[g] = append(pending[g], pending[other]...)
+					visited[g] = append(visited[g], visited[other]...)
+					pending[other], visited[other] = nil, nil
+					active--
+				}
+			})
+		}
+	}
+}
+
+// largestComponent returns the number of cities in the largest component.
+func (t *componentTracker) largestComponent() int {
+	if t.changed {
+		t.largest = 0
+		for _, size := range t.sizes {
+			if size > t.largest {
+				t.largest = size
+			}
+		}
+		t.changed = false
+	}
+	return t.largest
+}
@@ -0,0 +1,72 @@
+package aliensim
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Makes sure that the components tracked as cities and roads are destroyed and
+// rebuilt match those worked out from scratch.
+func TestComponentTracker(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenerateGridMap(&buf, 12, 12); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	m, err := ParseWorldMap(&buf)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	tracker := newComponentTracker(m)
+	rnd := NewSeededGenerator(42)
+	for i := 0; i < 300; i++ {
+		switch r := rnd.Uint32() % 10; {
+		case r < 5:
+			city := m.cities[rnd.Uint32()%uint32(len(m.cities))]
+			city.state = CityDestroyed
+			tracker.cityDestroyed(city)
+		case r < 8:
+			road := m.roads[rnd.Uint32()%uint32(len(m.roads))]
+			if !road.destroyed {
+				road.destroyed = true
+				tracker.roadDestroyed(road)
+			}
+		default:
+			city := m.cities[rnd.Uint32()%uint32(len(m.cities))]
+			if city.Destroyed() {
+				city.state = CityIntact
+				tracker.cityRebuilt(city)
+			}
+		}
+		if expected := m.LargestIntactComponent(); tracker.largestComponent() != expected {
+			t.Fatal("Expected the largest component to have", expected, "cities after", i+1, "changes, but got", tracker.largestComponent())
+		}
+		if expected := newComponentTracker(m); !sameComponents(tracker.component, expected.component) {
+			t.Fatal("Expected the same components as when worked out from scratch after", i+1, "changes")
+		}
+	}
+}
+
+// sameComponents checks whether the given labellings of cities group the
+// cities in the same way, even if the components are labelled differently.
+func sameComponents(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	aToB, bToA := map[int]int{}, map[int]int{}
+	for i := range a {
+		if (a[i] < 0) != (b[i] < 0) {
+			return false
+		}
+		if a[i] < 0 {
+			continue
+		}
+		if c, ok := aToB[a[i]]; ok && c != b[i] {
+			return false
+		}
+		if c, ok := bToA[b[i]]; ok && c != a[i] {
+			return false
+		}
+		aToB[a[i]], bToA[b[i]] = b[i], a[i]
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package aliensim
+
+// Energy returns how many more moves this alien can make before it runs out of
+// energy.
+func (a *Alien) Energy() int {
+	return a.energy
+}
+
+// Exhausted checks whether this alien has run out of energy, and can therefore
+// no longer move.
+func (a *Alien) Exhausted() bool {
+	return a.energy <= 0
+}
+
+// trapped checks whether this alien is in a city from which it can't travel to
+// any cities that are still standing.
+func (a *Alien) trapped() bool {
+	if a.state != AlienInCity {
+		return false
+	}
+	for _, road := range a.city.roads {
+		if road != nil && road.AllowsTravelFrom(a.city) && !road.Other(a.city).Destroyed() {
+			return false
+		}
+	}
+	return true
+}
+
+// rechargeAliens has the living aliens in intact cities regain some of their
+// energy. Returns true if any of the aliens regained energy.
+func (s *Simulation) rechargeAliens() bool {
+	if s.config.rechargeRate <= 0 {
+		return false
+	}
+	recharged := false
+	for _, alien := range s.aliens {
+		if !alien.alive || alien.state != AlienInCity || alien.city.state != CityIntact ||
+			alien.energy >= s.config.energyBudget {
+			continue
+		}
+		alien.energy += s.config.rechargeRate
+		if alien.energy > s.config.energyBudget {
+			alien.energy = s.config.energyBudget
+		}
+		recharged = true
+	}
+	return recharged
+}
@@ -0,0 +1,67 @@
+package aliensim
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests that aliens which run out of energy are told apart from those that are
+// trapped.
+func TestSimulationWithEnergy(t *testing.T) {
+	tests := []struct {
+		worldMap            string
+		iterationsSimulated int
+		aliensExhausted     int
+		aliensTrapped       int
+	}{
+		{"A east=B\n", 3, 2, 0},
+		{"A\n", 0, 0, 2},
+	}
+	species, err := ParseSpecies(strings.NewReader("Drifter fights-own-kind=false\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	for _, test := range tests {
+		res, err := NewSimulation(
+			newTestSimulationConfig(strings.NewReader(test.worldMap), 2).
+				WithSpawnCities([]string{"A"}).
+				WithSpecies(species...).
+				WithEnergy(3, 0),
+		).Simulate()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if res.IterationsSimulated != test.iterationsSimulated ||
+			res.AliensExhausted != test.aliensExhausted ||
+			res.AliensTrapped != test.aliensTrapped ||
+			res.AliensDead != 0 {
+			t.Error(
+				"For map", test.worldMap,
+				"expected", test.iterationsSimulated, "iterations,", test.aliensExhausted, "exhausted and",
+				test.aliensTrapped, "trapped aliens, but got", res.IterationsSimulated, res.AliensExhausted,
+				res.AliensTrapped, "and", res.AliensDead, "dead",
+			)
+		}
+	}
+}
+
+func TestRechargeAliens(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader("Foo east=Bar\n"))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	s := NewSimulation(newTestSimulationConfig(nil, 2).WithEnergy(5, 2))
+	s.worldMap = m
+	s.aliens = []*Alien{NewAlien(0, m.city("Foo")), NewAlien(1, m.city("Bar"))}
+	s.aliens[0].energy = 4
+	m.city("Bar").state = CityDestroyed
+	if !s.rechargeAliens() || s.aliens[0].Energy() != 5 || s.aliens[1].Energy() != 0 {
+		t.Error(
+			"Expected only the alien in an intact city to recharge (up to its budget), but got",
+			s.aliens[0].Energy(), "and", s.aliens[1].Energy(),
+		)
+	}
+	if s.rechargeAliens() {
+		t.Error("Expected no aliens to recharge once they're back at full energy")
+	}
+}
@@ -12,6 +12,16 @@ const (
 	ErrFailedToParseLine      SimulationErrorCode = 2
 	ErrUnknownDirection       SimulationErrorCode = 3
 	ErrCityAlreadyThere       SimulationErrorCode = 4
+	ErrInvalidDirective       SimulationErrorCode = 5
+	ErrInconsistentLayout     SimulationErrorCode = 6
+	ErrInvalidRoadLength      SimulationErrorCode = 7
+	ErrInvalidCityAttribute   SimulationErrorCode = 8
+	ErrUnknownCity            SimulationErrorCode = 9
+	ErrInvalidConfig          SimulationErrorCode = 10
+	ErrInvalidSpecies         SimulationErrorCode = 11
+	ErrLineTooLong            SimulationErrorCode = 12
+	ErrInvalidBinaryFormat    SimulationErrorCode = 13
+	ErrInvalidCityName        SimulationErrorCode = 14
 )
 
 // SimulationError is returned when some aspect of our simulation fails
@@ -40,6 +50,11 @@ func (e *SimulationError) buildErrorMessage(prefix string) string {
 	return msg
 }
 
+// Code returns the kind of error this is.
+func (e *SimulationError) Code() SimulationErrorCode {
+	return e.kind
+}
+
 // Error translates the simulation error enum into its human-readable form
 func (e *SimulationError) Error() string {
 	switch e.kind {
@@ -53,6 +68,26 @@ func (e *SimulationError) Error() string {
 		return e.buildErrorMessage("Unknown direction specified.")
 	case ErrCityAlreadyThere:
 		return e.buildErrorMessage("Cannot locate a city on top of another city.")
+	case ErrInvalidDirective:
+		return e.buildErrorMessage("Invalid map directive.")
+	case ErrInconsistentLayout:
+		return e.buildErrorMessage("The cities on the map cannot be laid out consistently.")
+	case ErrInvalidRoadLength:
+		return e.buildErrorMessage("Invalid road length.")
+	case ErrInvalidCityAttribute:
+		return e.buildErrorMessage("Invalid city attribute.")
+	case ErrUnknownCity:
+		return e.buildErrorMessage("Unknown city.")
+	case ErrInvalidConfig:
+		return e.buildErrorMessage("Invalid simulation configuration.")
+	case ErrInvalidSpecies:
+		return e.buildErrorMessage("Invalid species definition.")
+	case ErrLineTooLong:
+		return e.buildErrorMessage("A line of the world map is too long.")
+	case ErrInvalidBinaryFormat:
+		return e.buildErrorMessage("Invalid binary map or snapshot.")
+	case ErrInvalidCityName:
+		return e.buildErrorMessage("Invalid city name.")
 	}
 	return "Unrecognised error code"
 }
@@ -0,0 +1,36 @@
+package aliensim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// GenerateGridMap writes a world map of width x height cities, laid out in a
+// grid with each city linked to its neighbours to the East and South, in the
+// usual map format. The city in column x (from the West) and row y (from the
+// North) is named "Cx_y". This is mostly useful for trying out (and
+// benchmarking) the simulator on large maps.
+func GenerateGridMap(w io.Writer, width, height int) error {
+	if width < 1 || height < 1 {
+		return NewExtendedSimulationError(
+			ErrInvalidConfig,
+			fmt.Sprintf("Grid maps must be at least 1x1 cities in size, but got %dx%d.", width, height),
+			nil,
+		)
+	}
+	out := bufio.NewWriter(w)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fmt.Fprintf(out, "C%d_%d", x, y)
+			if x+1 < width {
+				fmt.Fprintf(out, " east=C%d_%d", x+1, y)
+			}
+			if y+1 < height {
+				fmt.Fprintf(out, " south=C%d_%d", x, y+1)
+			}
+			out.WriteString("\n")
+		}
+	}
+	return out.Flush()
+}
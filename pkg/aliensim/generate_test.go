@@ -0,0 +1,32 @@
+package aliensim
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGenerateGridMap(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenerateGridMap(&buf, 3, 2); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	expected := "C0_0 east=C1_0 south=C0_1\n" +
+		"C1_0 east=C2_0 south=C1_1\n" +
+		"C2_0 south=C2_1\n" +
+		"C0_1 east=C1_1\n" +
+		"C1_1 east=C2_1\n" +
+		"C2_1\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+	m, err := ParseWorldMap(&buf)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if len(m.Cities()) != 6 || len(m.Roads()) != 7 || m.LargestIntactComponent() != 6 {
+		t.Error("Expected a grid of 6 cities linked by 7 roads, but got", m.Render())
+	}
+	if err := GenerateGridMap(&buf, 0, 2); err == nil {
+		t.Error("Expected an error for an empty grid")
+	}
+}
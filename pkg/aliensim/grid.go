@@ -0,0 +1,127 @@
+package aliensim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Levels returns the distinct levels (z coordinates) on which cities are found
+// in this world map, from the highest to the lowest.
+func (m *WorldMap) Levels() []int {
+	seen := map[int]bool{}
+	levels := []int{}
+	for _, city := range m.cities {
+		z := city.z
+		if !seen[z] {
+			seen[z] = true
+			levels = append(levels, z)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(levels)))
+	return levels
+}
+
+// RenderGrid draws the cities on the given level of the map as a grid, with
+// North at the top. Only cities that have not yet been destroyed are drawn,
+// along with the roads between them. One-way roads are drawn as arrows in the
+// direction of travel. Cities with links to other levels are not treated
+// specially here - each level is meant to be rendered on its own. Maps that
+// couldn't be laid out (see LaidOut) render as an empty string.
+func (m *WorldMap) RenderGrid(level int) string {
+	if !m.laidOut {
+		return ""
+	}
+	grid := map[[2]int]*City{}
+	width := 0
+	minX, maxX, minY, maxY := 0, 0, 0, 0
+	first := true
+	for _, city := range m.cities {
+		if city.z != level {
+			continue
+		}
+		// the bounds include destroyed cities so the layout of a level stays
+		// the same throughout a simulation
+		if first || city.x < minX {
+			minX = city.x
+		}
+		if first || city.x > maxX {
+			maxX = city.x
+		}
+		if first || city.y < minY {
+			minY = city.y
+		}
+		if first || city.y > maxY {
+			maxY = city.y
+		}
+		first = false
+		if len(city.name) > width {
+			width = len(city.name)
+		}
+		if !city.Destroyed() {
+			grid[[2]int{city.x, city.y}] = city
+		}
+	}
+	if first {
+		return ""
+	}
+
+	// can an alien travel from the city at (x, y) in the given direction?
+	travel := func(x, y, dir int) bool {
+		city := grid[[2]int{x, y}]
+		if city == nil || city.roads[dir] == nil {
+			return false
+		}
+		road := city.roads[dir]
+		return road.AllowsTravelFrom(city) && !road.Other(city).Destroyed()
+	}
+	// picks the symbol for a road depending on the directions in which it can
+	// be travelled
+	symbol := func(forward, backward bool, both, fwd, bwd string) string {
+		switch {
+		case forward && backward:
+			return both
+		case forward:
+			return fwd
+		case backward:
+			return bwd
+		}
+		return ""
+	}
+
+	var b strings.Builder
+	for y := maxY; y >= minY; y-- {
+		var row, links strings.Builder
+		for x := minX; x <= maxX; x++ {
+			name := ""
+			if city := grid[[2]int{x, y}]; city != nil {
+				name = city.name
+			}
+			fmt.Fprintf(&row, "%-*s", width, name)
+			vertical := symbol(travel(x, y-1, DirNorth), travel(x, y, DirSouth), "|", "^", "v")
+			fmt.Fprintf(&links, "%-*s", width, centre(vertical, width))
+			if x == maxX {
+				break
+			}
+			horizontal := symbol(travel(x, y, DirEast), travel(x+1, y, DirWest), "-", ">", "<")
+			fmt.Fprintf(&row, " %1s ", horizontal)
+			// diagonal one-way roads are drawn like two-way ones
+			se := travel(x, y, DirSouthEast) || travel(x+1, y-1, DirNorthWest)
+			sw := travel(x+1, y, DirSouthWest) || travel(x, y-1, DirNorthEast)
+			fmt.Fprintf(&links, " %1s ", symbol(se, sw, "X", "\\", "/"))
+		}
+		fmt.Fprintln(&b, strings.TrimRight(row.String(), " "))
+		if y > minY {
+			fmt.Fprintln(&b, strings.TrimRight(links.String(), " "))
+		}
+	}
+	return b.String()
+}
+
+// centre returns s centred within the given width.
+func centre(s string, width int) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return strings.Repeat(" ", (width-len(s))/2) + s
+}
@@ -0,0 +1,31 @@
+package aliensim
+
+import "time"
+
+// Instrumentation is notified of what the simulator is doing and how long it
+// takes, so that metrics can be recorded without the simulator having to know
+// how they're collected or reported. Implementations shared between
+// simulations running at the same time must be safe for concurrent use.
+type Instrumentation interface {
+	// SimulationStarted is called as soon as a simulation is started, before
+	// its world map is parsed.
+	SimulationStarted()
+	// WorldMapParsed is called once a simulation's world map has been parsed,
+	// with how long parsing took and the error encountered, if any.
+	WorldMapParsed(duration time.Duration, err error)
+	// SimulationFailed is called if a simulation cannot be run to completion.
+	SimulationFailed(err error)
+	// SimulationFinished is called once a simulation has finished, with its
+	// result and how long it took to simulate (not counting parsing the world
+	// map and placing the aliens).
+	SimulationFinished(result *SimulationResult, duration time.Duration)
+}
+
+// NoopInstrumentation ignores everything it's notified of.
+type NoopInstrumentation struct{}
+
+func (i *NoopInstrumentation) SimulationStarted()                               {}
+func (i *NoopInstrumentation) WorldMapParsed(duration time.Duration, err error) {}
+func (i *NoopInstrumentation) SimulationFailed(err error)                       {}
+func (i *NoopInstrumentation) SimulationFinished(result *SimulationResult, duration time.Duration) {
+}
@@ -0,0 +1,64 @@
+package aliensim
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingInstrumentation keeps track of what it's notified of.
+type recordingInstrumentation struct {
+	calls    []string
+	parseErr error
+	result   *SimulationResult
+}
+
+func (i *recordingInstrumentation) SimulationStarted() {
+	i.calls = append(i.calls, "started")
+}
+
+func (i *recordingInstrumentation) WorldMapParsed(duration time.Duration, err error) {
+	i.calls = append(i.calls, "parsed")
+	i.parseErr = err
+}
+
+func (i *recordingInstrumentation) SimulationFailed(err error) {
+	i.calls = append(i.calls, "failed")
+}
+
+func (i *recordingInstrumentation) SimulationFinished(result *SimulationResult, duration time.Duration) {
+	i.calls = append(i.calls, "finished")
+	i.result = result
+}
+
+func TestInstrumentation(t *testing.T) {
+	instrumentation := &recordingInstrumentation{}
+	res, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("A east=B\n"), 2).WithInstrumentation(instrumentation),
+	).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if !stringSlicesEqual([]string{"started", "parsed", "finished"}, instrumentation.calls) {
+		t.Error("Expected the simulation to be started, parsed and finished, but got", instrumentation.calls)
+	}
+	if instrumentation.parseErr != nil || instrumentation.result != res {
+		t.Error("Expected to be notified of the simulation's result, but got", instrumentation.result)
+	}
+}
+
+func TestInstrumentationOfParseErrors(t *testing.T) {
+	instrumentation := &recordingInstrumentation{}
+	_, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("A sideways=B\n"), 2).WithInstrumentation(instrumentation),
+	).Simulate()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	if !stringSlicesEqual([]string{"started", "parsed", "failed"}, instrumentation.calls) {
+		t.Error("Expected the simulation to fail once its map had been parsed, but got", instrumentation.calls)
+	}
+	if serr, ok := instrumentation.parseErr.(*SimulationError); !ok || serr.Code() != ErrFailedToParseLine {
+		t.Error("Expected to be notified of the parse error, but got", instrumentation.parseErr)
+	}
+}
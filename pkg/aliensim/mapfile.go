@@ -0,0 +1,73 @@
+package aliensim
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// MapFile is a world map file opened for parsing, which is either read as it's
+// parsed or memory-mapped.
+type MapFile struct {
+	file   *os.File
+	reader io.Reader
+	size   int64
+	mapped []byte // The contents of the file, if it's been memory-mapped.
+}
+
+// OpenMapFile opens the given world map file for parsing. If memoryMap is set
+// (and memory-mapping is supported on this platform), the file is
+// memory-mapped, which leaves it to the operating system to page its contents
+// in and out as it's parsed instead of copying them into the parser's buffer.
+// Otherwise the file is simply read as it's parsed.
+func OpenMapFile(filename string, memoryMap bool) (*MapFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	mf := &MapFile{file: f, reader: f, size: info.Size()}
+	if memoryMap && memoryMapSupported && info.Mode().IsRegular() && info.Size() > 0 {
+		mf.mapped, err = memoryMapFile(f, info.Size())
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		mf.reader = bytes.NewReader(mf.mapped)
+	}
+	return mf, nil
+}
+
+// MemoryMapped checks whether the file has been memory-mapped.
+func (f *MapFile) MemoryMapped() bool {
+	return f.mapped != nil
+}
+
+// Size returns the size of the file, in bytes.
+func (f *MapFile) Size() int64 {
+	return f.size
+}
+
+// Read implements io.Reader.
+func (f *MapFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+// Close unmaps the file (if it was memory-mapped) and closes it. Nothing parsed
+// from the file refers to its memory once parsing is over, so the file can be
+// closed as soon as it has been parsed.
+func (f *MapFile) Close() error {
+	var err error
+	if f.mapped != nil {
+		err = unmapFile(f.mapped)
+		f.mapped, f.reader = nil, bytes.NewReader(nil)
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
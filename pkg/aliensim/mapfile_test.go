@@ -0,0 +1,83 @@
+package aliensim
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestMapFile(t *testing.T, contents []byte) (string, func()) {
+	dir, err := ioutil.TempDir("", "aliensim")
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	filename := filepath.Join(dir, "map.txt")
+	if err := ioutil.WriteFile(filename, contents, 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal("Expected no error, but got", err)
+	}
+	return filename, func() { os.RemoveAll(dir) }
+}
+
+func TestOpenMapFile(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenerateGridMap(&buf, 20, 20); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	expected, err := ParseWorldMap(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	filename, cleanup := writeTestMapFile(t, buf.Bytes())
+	defer cleanup()
+
+	for _, memoryMap := range []bool{false, true} {
+		f, err := OpenMapFile(filename, memoryMap)
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if f.MemoryMapped() != (memoryMap && memoryMapSupported) {
+			t.Error("Expected the file to be memory-mapped only when asked to (and supported), but got", f.MemoryMapped())
+		}
+		if f.Size() != int64(buf.Len()) {
+			t.Error("Expected the file's size to be", buf.Len(), "but got", f.Size())
+		}
+		handler := &recordingParseProgressHandler{}
+		m, err := NewMapParser().WithProgressHandler(handler, 0).Parse(f)
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Error("Expected no error closing the file, but got", err)
+		}
+		// the map mustn't refer to the file's memory once it's been unmapped
+		if m.Render() != expected.Render() {
+			t.Errorf("Expected the file to be parsed (memoryMap=%t) just like the map it contains", memoryMap)
+		}
+		if last := handler.reports[len(handler.reports)-1]; last.BytesRead != f.Size() || last.TotalBytes != f.Size() {
+			t.Error("Expected the whole file to have been read, but got", last)
+		}
+	}
+}
+
+func TestMemoryMappedLineTooLong(t *testing.T) {
+	filename, cleanup := writeTestMapFile(t, []byte("A east=B\r\nB east=C east=D\n"))
+	defer cleanup()
+	f, err := OpenMapFile(filename, true)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	defer f.Close()
+	_, err = NewMapParser().WithMaxLineLength(8).Parse(f)
+	if serr, ok := err.(*SimulationError); !ok || serr.Code() != ErrLineTooLong {
+		t.Error("Expected ErrLineTooLong, but got", err)
+	}
+}
+
+func TestOpenMissingMapFile(t *testing.T) {
+	if _, err := OpenMapFile(filepath.Join(os.TempDir(), "no-such-aliensim-map.txt"), true); err == nil {
+		t.Error("Expected an error opening a missing file")
+	}
+}
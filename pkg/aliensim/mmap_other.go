@@ -0,0 +1,21 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package aliensim
+
+import (
+	"errors"
+	"os"
+)
+
+// memoryMapSupported indicates whether files can be memory-mapped on this
+// platform.
+const memoryMapSupported = false
+
+func memoryMapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("memory-mapping files is not supported on this platform")
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
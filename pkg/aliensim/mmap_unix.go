@@ -0,0 +1,23 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package aliensim
+
+import (
+	"os"
+	"syscall"
+)
+
+// memoryMapSupported indicates whether files can be memory-mapped on this
+// platform.
+const memoryMapSupported = true
+
+// memoryMapFile maps the given file, of the given size, into memory for reading.
+func memoryMapFile(f *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile releases memory mapped by memoryMapFile.
+func unmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,100 @@
+package aliensim
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// parallelBlockSize is the number of aliens (by ID) in each of the blocks that
+// are handed out to the goroutines moving aliens in parallel. Each block draws
+// its random numbers from its own stream, so that which numbers each alien gets
+// doesn't depend on how many goroutines there are (if any) or which of them
+// moves it.
+const parallelBlockSize = 1024
+
+// moveAliens moves each living alien as many times as its species and its
+// energy allow, returning the total number of moves made. Aliens only ever look
+// at (and change) their own state and that of the map, which doesn't change
+// while they're moving, so they can be moved in any order, apart from the
+// random numbers they draw. Aliens are moved one block at a time, whether or
+// not they're moved in parallel, so that the result is the same either way.
+func (s *Simulation) moveAliens() int {
+	blocks := (len(s.aliens) + parallelBlockSize - 1) / parallelBlockSize
+	// the first block draws straight from the simulation's generator, so that
+	// simulations with no more than a block's worth of aliens move them just
+	// like they always have. The streams of any other blocks are derived from
+	// a seed drawn from the simulation's generator, so that the simulation is
+	// still reproduced by seeding it.
+	var seed uint64
+	if blocks > 1 {
+		seed = uint64(s.config.rnd.Uint32())<<32 | uint64(s.config.rnd.Uint32())
+	}
+	blockMoves := make([]int, blocks)
+	moveBlock := func(block int) {
+		var rnd RandomGenerator = s.config.rnd
+		if block > 0 {
+			rnd = newStreamGenerator(seed, block)
+		}
+		end := (block + 1) * parallelBlockSize
+		if end > len(s.aliens) {
+			end = len(s.aliens)
+		}
+		for _, alien := range s.aliens[block*parallelBlockSize : end] {
+			blockMoves[block] += s.moveAlien(alien, rnd)
+		}
+	}
+
+	workers := s.config.parallelism
+	if workers > blocks {
+		workers = blocks
+	}
+	if workers <= 1 {
+		for block := 0; block < blocks; block++ {
+			moveBlock(block)
+		}
+	} else {
+		var wg sync.WaitGroup
+		next := int32(-1)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					block := int(atomic.AddInt32(&next, 1))
+					if block >= blocks {
+						return
+					}
+					moveBlock(block)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	moves := 0
+	for _, m := range blockMoves {
+		moves += m
+	}
+	return moves
+}
+
+// moveAlien moves the given alien (if it's alive) as many times as its species
+// and its energy allow, drawing any random numbers it needs from the given
+// generator. Returns the number of moves made.
+func (s *Simulation) moveAlien(alien *Alien, rnd RandomGenerator) int {
+	if !alien.alive {
+		return 0
+	}
+	moves := 0
+	for moves < alien.species.Speed && alien.energy > 0 && alien.Move(rnd) {
+		moves++
+		alien.energy--
+		if alien.state == AlienInCity {
+			s.recordArrival(alien)
+		}
+	}
+	if moves == 0 && alien.energy > 0 {
+		alien.blockedTurns++
+	}
+	return moves
+}
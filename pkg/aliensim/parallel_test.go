@@ -0,0 +1,118 @@
+package aliensim
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newParallelTestSimulation sets up (but doesn't start) a simulation of several
+// blocks' worth of aliens of different species, with everything that draws
+// random numbers while aliens are moving turned on.
+func newParallelTestSimulation(t *testing.T, worldMap []byte, parallelism int) *Simulation {
+	species, err := ParseSpecies(strings.NewReader(
+		"Runner speed=2 movement=straight\n" +
+			"Wanderer movement=avoid-revisits\n" +
+			"Explorer movement=explore fights-own-kind=false\n",
+	))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	return NewSimulation(
+		NewSimulationConfig(bytes.NewReader(worldMap), 3*parallelBlockSize+100).
+			WithRandomGenerator(NewSeededGenerator(42)).
+			WithProgressHandler(&NoopSimulationProgressHandler{}).
+			WithSpecies(species...).
+			WithPerception(2, 0.1).
+			WithRoadEncounters(true).
+			WithRoadCollapseChance(0.01).
+			WithTrajectories(true).
+			WithStopCriteria(MaxIterations{Iterations: 30}).
+			WithParallelism(parallelism),
+	)
+}
+
+// describeAliens describes where each alien is and how it's doing.
+func describeAliens(sim *Simulation) []string {
+	described := []string{}
+	for _, alien := range sim.Aliens() {
+		described = append(described, fmt.Sprintf("%s energy=%d distance=%d", alien, alien.energy, alien.distance))
+	}
+	return described
+}
+
+func TestParallelResultsDoNotDependOnGoroutines(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenerateGridMap(&buf, 60, 60); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	// moving the aliens one at a time, without any other goroutines, is what
+	// the others are compared to
+	sequential := newParallelTestSimulation(t, buf.Bytes(), 0)
+	if err := sequential.Start(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	others := map[int]*Simulation{}
+	for _, parallelism := range []int{1, 2, 3, 8} {
+		others[parallelism] = newParallelTestSimulation(t, buf.Bytes(), parallelism)
+		if err := others[parallelism].Start(); err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+	}
+
+	for iter := 0; ; iter++ {
+		done, err := sequential.Step()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		expected := describeAliens(sequential)
+		for parallelism, sim := range others {
+			otherDone, err := sim.Step()
+			if err != nil {
+				t.Fatal("Expected no error, but got", err)
+			}
+			if otherDone != done || !stringSlicesEqual(expected, describeAliens(sim)) {
+				t.Fatalf("Expected the aliens moved by %d goroutines to end up where they would one at a time after iteration %d", parallelism, iter)
+			}
+		}
+		if done {
+			break
+		}
+	}
+
+	expected := sequential.Finish()
+	if expected.TotalMoves == 0 || expected.RoadsDestroyed == 0 || len(expected.CitiesRemaining) == len(expected.FinalMap.Cities()) {
+		t.Fatal("Expected the aliens to move, fight and destroy roads, but got", expected.TotalMoves, "moves")
+	}
+	for parallelism, sim := range others {
+		res := sim.Finish()
+		if res.TotalMoves != expected.TotalMoves ||
+			res.RoadsDestroyed != expected.RoadsDestroyed ||
+			!stringSlicesEqual(res.CitiesRemaining, expected.CitiesRemaining) ||
+			!reflect.DeepEqual(res.AlienDistances, expected.AlienDistances) ||
+			!reflect.DeepEqual(res.SpeciesStats, expected.SpeciesStats) ||
+			!reflect.DeepEqual(res.Trajectories, expected.Trajectories) {
+			t.Errorf("Expected the same result with %d goroutines as one at a time", parallelism)
+		}
+	}
+}
+
+func TestParallelSimulationIsReproducible(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenerateGridMap(&buf, 60, 60); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	first, err := newParallelTestSimulation(t, buf.Bytes(), 4).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	second, err := newParallelTestSimulation(t, buf.Bytes(), 4).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if first.TotalMoves != second.TotalMoves || !reflect.DeepEqual(first.Trajectories, second.Trajectories) {
+		t.Error("Expected the same seed to reproduce the same parallel simulation")
+	}
+}
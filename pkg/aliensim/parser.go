@@ -0,0 +1,305 @@
+package aliensim
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultMaxLineLength is the longest line (in bytes, not counting the line
+// ending) a world map may contain by default.
+const DefaultMaxLineLength = 1024 * 1024
+
+// DefaultParseProgressInterval is how many bytes of a world map are read, by
+// default, between each report of how far parsing it has got.
+const DefaultParseProgressInterval = 64 * 1024 * 1024
+
+// linkInferenceInterval is how many lines of a world map are parsed between
+// each time the links between the cities mentioned in them are inferred.
+const linkInferenceInterval = 64 * 1024
+
+// MapParser parses world maps one line at a time, so that the input never has
+// to be held in memory in its entirety, no matter how large it is.
+type MapParser struct {
+	maxLineLength    int
+	progressHandler  ParseProgressHandler
+	progressInterval int64 // How many bytes to read between progress reports.
+}
+
+// ParseProgress describes how far parsing a world map has got.
+type ParseProgress struct {
+	BytesRead  int64  // How many bytes of the input have been read so far.
+	TotalBytes int64  // The size of the input, if known (0 otherwise).
+	Lines      uint64 // How many lines have been read so far.
+	Cities     int    // How many cities have been found so far.
+	Done       bool   // Has the whole input been read?
+}
+
+// ParseProgressHandler is notified of how far parsing a world map has got,
+// every so often while it's being read and once it has been read in full.
+type ParseProgressHandler interface {
+	ParsingProgress(progress ParseProgress)
+}
+
+// StdoutParseProgressHandler prints how far parsing a world map has got to
+// Stdout.
+type StdoutParseProgressHandler struct{}
+
+// countingReader counts the bytes read from the reader it wraps.
+type countingReader struct {
+	r    io.Reader
+	read int64
+}
+
+// lineReader reads a world map one line at a time.
+type lineReader interface {
+	// next returns the next line (without its line ending), io.EOF once there
+	// are no more lines, or any other error encountered while reading.
+	next() (string, error)
+	// bytesRead returns how many bytes of the input have been read so far.
+	bytesRead() int64
+}
+
+// scannedLines reads lines from a reader, holding no more than a line's worth
+// of the input in memory at a time.
+type scannedLines struct {
+	input         *countingReader
+	scanner       *bufio.Scanner
+	maxLineLength int
+	lines         uint64 // How many lines have been read so far.
+}
+
+// mappedLines reads lines from the memory of a memory-mapped file.
+type mappedLines struct {
+	data          []byte
+	maxLineLength int
+	pos           int    // Where the next line starts.
+	lines         uint64 // How many lines have been read so far.
+}
+
+// NewMapParser creates a parser that accepts lines of up to
+// DefaultMaxLineLength bytes and doesn't report its progress.
+func NewMapParser() *MapParser {
+	return &MapParser{
+		maxLineLength:    DefaultMaxLineLength,
+		progressInterval: DefaultParseProgressInterval,
+	}
+}
+
+// WithMaxLineLength sets the longest line (in bytes, not counting the line
+// ending) the world map may contain, which must be positive. Longer lines are
+// rejected with an ErrLineTooLong error.
+func (p *MapParser) WithMaxLineLength(length int) *MapParser {
+	p.maxLineLength = length
+	return p
+}
+
+// WithProgressHandler has the given handler notified of how far parsing has
+// got each time another interval's worth of bytes has been read (or every
+// DefaultParseProgressInterval bytes if the interval isn't positive), as well
+// as once the whole map has been read.
+func (p *MapParser) WithProgressHandler(handler ParseProgressHandler, interval int64) *MapParser {
+	p.progressHandler = handler
+	p.progressInterval = interval
+	if interval <= 0 {
+		p.progressInterval = DefaultParseProgressInterval
+	}
+	return p
+}
+
+// Parse reads the given world map one line at a time and parses each line,
+// inferring the links between the cities mentioned in the lines read so far
+// every so often, and then works out how the cities are laid out relative to
+// one another once every line has been read. Lines are read
+// directly from the memory of a memory-mapped MapFile. World maps in the binary
+// format (see WriteBinaryMap) are recognised and read as they are.
+func (p *MapParser) Parse(worldReader io.Reader) (*WorldMap, error) {
+	if p.maxLineLength <= 0 {
+		return nil, NewExtendedSimulationError(
+			ErrInvalidConfig,
+			fmt.Sprintf("The maximum line length must be positive, but got %d.", p.maxLineLength),
+			nil,
+		)
+	}
+	var lines lineReader
+	if f, ok := worldReader.(*MapFile); ok && f.mapped != nil {
+		if IsBinary(f.mapped) {
+			return p.parseBinary(bytes.NewReader(f.mapped), int64(len(f.mapped)))
+		}
+		lines = &mappedLines{data: f.mapped, maxLineLength: p.maxLineLength}
+	} else {
+		input := bufio.NewReader(worldReader)
+		if magic, _ := input.Peek(len(binaryMagic)); IsBinary(magic) {
+			return p.parseBinary(input, inputSize(worldReader))
+		}
+		lines = newScannedLines(input, p.maxLineLength)
+	}
+
+	worldMap := NewEmptyWorldMap()
+	progress := ParseProgress{TotalBytes: inputSize(worldReader)}
+	reported := int64(0)
+	for {
+		line, err := lines.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		progress.Lines++
+		// skip empty lines
+		if len(line) > 0 {
+			if err := worldMap.ParseLine(line); err != nil {
+				return nil, err
+			}
+		}
+		if progress.Lines%linkInferenceInterval == 0 {
+			worldMap.inferLinks()
+		}
+		if p.progressHandler != nil && lines.bytesRead()-reported >= p.progressInterval {
+			reported = lines.bytesRead()
+			progress.BytesRead, progress.Cities = reported, len(worldMap.cities)
+			p.progressHandler.ParsingProgress(progress)
+		}
+	}
+	if p.progressHandler != nil {
+		progress.BytesRead, progress.Cities, progress.Done = lines.bytesRead(), len(worldMap.cities), true
+		p.progressHandler.ParsingProgress(progress)
+	}
+
+	// now make sure the neighbours of any cities in the last few lines know
+	// about each other
+	worldMap.inferLinks()
+	worldMap.numberRoads()
+	worldMap.layOut()
+	return worldMap, nil
+}
+
+// parseBinary reads a world map in the binary format, which needs no further
+// work once it's been read. City names and attributes are held to the same
+// maximum length as the lines of a text map.
+func (p *MapParser) parseBinary(r io.Reader, size int64) (*WorldMap, error) {
+	input := &countingReader{r: r}
+	worldMap, err := readBinaryMap(input, p.maxLineLength)
+	if err != nil {
+		return nil, err
+	}
+	if p.progressHandler != nil {
+		p.progressHandler.ParsingProgress(ParseProgress{
+			BytesRead:  input.read,
+			TotalBytes: size,
+			Cities:     len(worldMap.cities),
+			Done:       true,
+		})
+	}
+	return worldMap, nil
+}
+
+func newScannedLines(r io.Reader, maxLineLength int) *scannedLines {
+	input := &countingReader{r: r}
+	scanner := bufio.NewScanner(input)
+	// leave room for the line ending ("\r\n" at most) after the longest line
+	// allowed
+	limit := maxLineLength + 2
+	bufSize := 64 * 1024
+	if limit < bufSize {
+		bufSize = limit
+	}
+	scanner.Buffer(make([]byte, 0, bufSize), limit)
+	return &scannedLines{input: input, scanner: scanner, maxLineLength: maxLineLength}
+}
+
+func (l *scannedLines) next() (string, error) {
+	if !l.scanner.Scan() {
+		err := l.scanner.Err()
+		if err == bufio.ErrTooLong {
+			return "", lineTooLong(l.lines+1, l.maxLineLength)
+		}
+		if err != nil {
+			return "", NewExtendedSimulationError(ErrFailedToScanWorldInput, "", err)
+		}
+		return "", io.EOF
+	}
+	l.lines++
+	// lines up to a couple of bytes too long still fit in the scanner's buffer
+	if len(l.scanner.Bytes()) > l.maxLineLength {
+		return "", lineTooLong(l.lines, l.maxLineLength)
+	}
+	return l.scanner.Text(), nil
+}
+
+func (l *scannedLines) bytesRead() int64 {
+	return l.input.read
+}
+
+func (l *mappedLines) next() (string, error) {
+	if l.pos >= len(l.data) {
+		return "", io.EOF
+	}
+	l.lines++
+	line := l.data[l.pos:]
+	if end := bytes.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+		l.pos += end + 1
+	} else {
+		l.pos = len(l.data)
+	}
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if len(line) > l.maxLineLength {
+		return "", lineTooLong(l.lines, l.maxLineLength)
+	}
+	// copied out of the file's memory, which goes away once the file is closed
+	return string(line), nil
+}
+
+func (l *mappedLines) bytesRead() int64 {
+	return int64(l.pos)
+}
+
+func lineTooLong(line uint64, maxLineLength int) error {
+	return NewExtendedSimulationError(
+		ErrLineTooLong,
+		fmt.Sprintf("Line %d is longer than the maximum line length of %d bytes.", line, maxLineLength),
+		nil,
+	)
+}
+
+// inputSize returns the size of the given input, if it can be found out without
+// reading it, or 0 otherwise.
+func inputSize(r io.Reader) int64 {
+	switch input := r.(type) {
+	case interface{ Size() int64 }:
+		return input.Size()
+	case *os.File:
+		if info, err := input.Stat(); err == nil && info.Mode().IsRegular() {
+			return info.Size()
+		}
+	}
+	return 0
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+// ParsingProgress implements ParseProgressHandler.
+func (h *StdoutParseProgressHandler) ParsingProgress(progress ParseProgress) {
+	read := fmt.Sprintf("%.1f MiB", float64(progress.BytesRead)/(1024*1024))
+	if progress.TotalBytes > 0 {
+		read = fmt.Sprintf(
+			"%s of %.1f MiB (%.0f%%)",
+			read,
+			float64(progress.TotalBytes)/(1024*1024),
+			100*float64(progress.BytesRead)/float64(progress.TotalBytes),
+		)
+	}
+	status := "Parsing world map"
+	if progress.Done {
+		status = "Parsed world map"
+	}
+	fmt.Println(fmt.Sprintf("%s: %s, %d lines, %d cities", status, read, progress.Lines, progress.Cities))
+}
@@ -0,0 +1,161 @@
+package aliensim
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// recordingParseProgressHandler keeps track of the progress it's notified of.
+type recordingParseProgressHandler struct {
+	reports []ParseProgress
+}
+
+func (h *recordingParseProgressHandler) ParsingProgress(progress ParseProgress) {
+	h.reports = append(h.reports, progress)
+}
+
+func TestMaxLineLength(t *testing.T) {
+	// a line just as long as the maximum, and one a byte longer
+	line := "A east=B" + strings.Repeat(" east=B", 4)
+	tooLong := line + "x"
+	tests := []struct {
+		worldMap string
+		tooLong  bool
+	}{
+		{line + "\n", false},
+		{line, false},
+		{"B\r\n" + line + "\r\n", false},
+		{"B\n" + tooLong + "\n", true},
+		{"B\n" + tooLong, true},
+	}
+	for _, test := range tests {
+		_, err := NewMapParser().WithMaxLineLength(len(line)).Parse(strings.NewReader(test.worldMap))
+		if !test.tooLong {
+			if err != nil {
+				t.Errorf("Expected no error for %q, but got %v", test.worldMap, err)
+			}
+			continue
+		}
+		serr, ok := err.(*SimulationError)
+		if !ok || serr.Code() != ErrLineTooLong {
+			t.Errorf("Expected ErrLineTooLong for %q, but got %v", test.worldMap, err)
+		} else if !strings.Contains(serr.Error(), "Line 2 ") {
+			t.Error("Expected the error to point at line 2, but got", serr)
+		}
+	}
+
+	// the maximum line length must be positive
+	for _, length := range []int{0, -3} {
+		_, err := NewMapParser().WithMaxLineLength(length).Parse(strings.NewReader(line))
+		if serr, ok := err.(*SimulationError); !ok || serr.Code() != ErrInvalidConfig {
+			t.Errorf("Expected ErrInvalidConfig for a maximum line length of %d, but got %v", length, err)
+		}
+	}
+
+	// longer than the 64KB bufio.Scanner allows by default
+	long := "A" + strings.Repeat(" east=B", 20000)
+	if _, err := ParseWorldMap(strings.NewReader(long)); err != nil {
+		t.Error("Expected no error for a line longer than 64KB, but got", err)
+	}
+}
+
+func TestParseProgress(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenerateGridMap(&buf, 10, 10); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	size := int64(buf.Len())
+	handler := &recordingParseProgressHandler{}
+	m, err := NewMapParser().WithProgressHandler(handler, 500).Parse(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if len(handler.reports) < 2 {
+		t.Fatal("Expected several progress reports, but got", handler.reports)
+	}
+	for i, report := range handler.reports {
+		if report.TotalBytes != size {
+			t.Error("Expected the total size to be known, but got", report)
+		}
+		if i > 0 && (report.BytesRead < handler.reports[i-1].BytesRead || report.Lines <= handler.reports[i-1].Lines) {
+			t.Error("Expected progress to be made between reports, but got", handler.reports)
+		}
+		if report.Done != (i == len(handler.reports)-1) {
+			t.Error("Expected only the last report to be done, but got", handler.reports)
+		}
+	}
+	last := handler.reports[len(handler.reports)-1]
+	if last.BytesRead != size || last.Lines != 100 || last.Cities != len(m.Cities()) {
+		t.Error("Expected the whole map to have been read, but got", last)
+	}
+}
+
+func TestIncrementalLinkInference(t *testing.T) {
+	// the second batch of lines changes what's inferred about the links of
+	// cities that aren't mentioned in it
+	batches := [][]string{
+		{"@topology=eight", "A north=N west=W", "N east=E"},
+		{"W north=X", "E south=F"},
+	}
+	m := NewEmptyWorldMap()
+	for _, batch := range batches {
+		for _, line := range batch {
+			if err := m.ParseLine(line); err != nil {
+				t.Fatal("Expected no error, but got", err)
+			}
+		}
+		m.inferLinks()
+	}
+	if m.layOut(); !m.LaidOut() {
+		t.Fatal("Expected the map to be laid out")
+	}
+
+	all := strings.Join(append(batches[0], batches[1]...), "\n")
+	expected, err := ParseWorldMap(strings.NewReader(all))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if m.Render() != expected.Render() || len(m.Roads()) != len(expected.Roads()) {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected.Render(), m.Render())
+	}
+}
+
+func TestParseInfersLinksAsLinesArrive(t *testing.T) {
+	// the links along the bottom row are only inferred from those along the
+	// top row, which come in an earlier batch of lines
+	cities := linkInferenceInterval/2 + 1000
+	var buf bytes.Buffer
+	for i := 0; i < cities; i++ {
+		fmt.Fprintf(&buf, "A%d east=A%d\n", i, i+1)
+	}
+	for i := 0; i <= cities; i++ {
+		fmt.Fprintf(&buf, "B%d north=A%d\n", i, i)
+	}
+	m, err := ParseWorldMap(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+
+	// compared to inferring every link once all of the lines have been read
+	expected := NewEmptyWorldMap()
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if err := expected.ParseLine(line); err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+	}
+	expected.inferLinks()
+	if len(m.Roads()) != len(expected.Roads()) {
+		t.Fatalf("Expected %d roads, but got %d", len(expected.Roads()), len(m.Roads()))
+	}
+	for i, road := range m.Roads() {
+		other := expected.Roads()[i]
+		if road.from.name != other.from.name || road.to.name != other.to.name {
+			t.Fatalf("Expected road %d to be %s-%s, but got %s-%s", i, other.from.name, other.to.name, road.from.name, road.to.name)
+		}
+	}
+	if west := m.city(fmt.Sprintf("B%d", cities)).neighbours[DirWest]; west == nil || west.name != fmt.Sprintf("B%d", cities-1) {
+		t.Error("Expected the last city on the bottom row to be linked to the one west of it, but got", west)
+	}
+}
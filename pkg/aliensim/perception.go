@@ -0,0 +1,160 @@
+package aliensim
+
+import "fmt"
+
+// Perception limits how much aliens know about the world around them. Without
+// it, aliens always know which of their neighbouring cities have been
+// destroyed.
+type Perception struct {
+	Radius         int     // How many roads away aliens can see (cities further away are assumed to be standing, so 0 = blind).
+	MisjudgeChance float64 // The chance of an alien misjudging whether a city it can see has been destroyed.
+}
+
+// AvoidRevisitsMovement has aliens head for the neighbouring city they've
+// visited the fewest times, picking one at random if there are several.
+type AvoidRevisitsMovement struct{}
+
+// ExploreMovement has aliens take the road that leads towards the most cities
+// within sight that they haven't visited yet, picking one at random if there are
+// several. If there's nothing new in sight, aliens take any road at random.
+type ExploreMovement struct{}
+
+// VisitedCities returns the number of different cities this alien has been in.
+func (a *Alien) VisitedCities() int {
+	if a.visited == nil {
+		return 1
+	}
+	return len(a.visited)
+}
+
+// visit adds the given city to this alien's memory. The memory is only
+// allocated the first time the alien visits another city, as it would only
+// hold the city the alien started off in (and is still in) until then.
+func (a *Alien) visit(city *City) {
+	if a.visited == nil {
+		a.visited = map[*City]int{a.city: 1}
+	}
+	a.visited[city]++
+}
+
+// visits returns how many times this alien has been in the given city.
+func (a *Alien) visits(city *City) int {
+	if a.visited == nil && city == a.city {
+		return 1
+	}
+	return a.visited[city]
+}
+
+// sightRadius returns how many roads away this alien can see.
+func (a *Alien) sightRadius() int {
+	if a.perception == nil {
+		return 1
+	}
+	return a.perception.Radius
+}
+
+// believesStanding checks whether this alien believes the given city, which is
+// the given number of roads away from it, is still standing.
+func (a *Alien) believesStanding(rnd RandomGenerator, city *City, distance int) bool {
+	if a.perception == nil {
+		return !city.Destroyed()
+	}
+	if distance > a.perception.Radius {
+		return true
+	}
+	standing := !city.Destroyed()
+	if randomChance(rnd, a.perception.MisjudgeChance) {
+		standing = !standing
+	}
+	return standing
+}
+
+// ChooseRoad implements MovementStrategy.
+func (m AvoidRevisitsMovement) ChooseRoad(rnd RandomGenerator, alien *Alien, roads []*Road) *Road {
+	fewest := []*Road{}
+	for _, road := range roads {
+		visits := alien.visits(road.Other(alien.city))
+		if len(fewest) > 0 {
+			least := alien.visits(fewest[0].Other(alien.city))
+			if visits > least {
+				continue
+			}
+			if visits < least {
+				fewest = fewest[:0]
+			}
+		}
+		fewest = append(fewest, road)
+	}
+	return RandomMovement{}.ChooseRoad(rnd, alien, fewest)
+}
+
+// ChooseRoad implements MovementStrategy.
+func (m ExploreMovement) ChooseRoad(rnd RandomGenerator, alien *Alien, roads []*Road) *Road {
+	best, bestScore := []*Road{}, 1
+	for _, road := range roads {
+		score := alien.unexploredBeyond(rnd, road)
+		if score > bestScore {
+			best, bestScore = best[:0], score
+		}
+		if score == bestScore {
+			best = append(best, road)
+		}
+	}
+	if len(best) == 0 {
+		return RandomMovement{}.ChooseRoad(rnd, alien, roads)
+	}
+	return RandomMovement{}.ChooseRoad(rnd, alien, best)
+}
+
+// unexploredBeyond counts the cities within sight, reached by way of the given
+// road, that this alien hasn't visited and believes are still standing.
+func (a *Alien) unexploredBeyond(rnd RandomGenerator, road *Road) int {
+	start := road.Other(a.city)
+	distances := map[*City]int{a.city: 0, start: 1}
+	queue := []*City{start}
+	unexplored := 0
+	for len(queue) > 0 {
+		city := queue[0]
+		queue = queue[1:]
+		if a.visits(city) == 0 && a.believesStanding(rnd, city, distances[city]) {
+			unexplored++
+		}
+		if distances[city] >= a.sightRadius() {
+			continue
+		}
+		for _, neighbour := range city.neighbours {
+			if _, seen := distances[neighbour]; neighbour != nil && !seen {
+				distances[neighbour] = distances[city] + 1
+				queue = append(queue, neighbour)
+			}
+		}
+	}
+	return unexplored
+}
+
+// validatePerception checks that the configured perception makes sense.
+func (s *Simulation) validatePerception() error {
+	if s.config.perception != nil && s.config.perception.Radius < 0 {
+		return NewExtendedSimulationError(
+			ErrInvalidConfig,
+			fmt.Sprintf("The sight radius of aliens can't be negative, but got %d.", s.config.perception.Radius),
+			nil,
+		)
+	}
+	return nil
+}
+
+// aliensMayBeMisled checks whether any living aliens in cities could actually
+// move, even though they may believe they can't because they've misjudged their
+// surroundings.
+func (s *Simulation) aliensMayBeMisled() bool {
+	if s.config.perception == nil {
+		return false
+	}
+	for _, alien := range s.aliens {
+		if alien.alive && alien.state == AlienInCity && len(alien.standingRoads()) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package aliensim
+
+import (
+	"strings"
+	"testing"
+)
+
+// newTestAlien creates an alien in the given city that moves according to the
+// given strategy.
+func newTestAlien(city *City, movement MovementStrategy, perception *Perception) *Alien {
+	alien := NewAlien(0, city)
+	alien.species = &Species{Name: "Test", Speed: 1, Strength: 1, Movement: movement, Weight: 1}
+	alien.perception = perception
+	return alien
+}
+
+func TestAvoidRevisitsMovement(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader("A east=B\nB east=C\n"))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	alien := newTestAlien(m.city("B"), AvoidRevisitsMovement{}, nil)
+	alien.visit(m.city("A"))
+	if !alien.Move(NewSequenceGenerator()) || alien.city.name != "C" {
+		t.Error("Expected alien to avoid A and move to C, but got", alien)
+	}
+	if !alien.Move(NewSequenceGenerator()) || alien.city.name != "B" || alien.VisitedCities() != 3 {
+		t.Error("Expected alien to move back to B, having visited 3 cities, but got", alien, alien.VisitedCities())
+	}
+}
+
+func TestVisitedCitiesRememberedOnceAliensMove(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader("A east=B\n"))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	alien := NewAlien(0, m.city("A"))
+	if alien.visited != nil || alien.VisitedCities() != 1 || alien.visits(m.city("A")) != 1 {
+		t.Error("Expected an alien that hasn't moved to only remember A, without allocating its memory")
+	}
+	if !alien.Move(NewSequenceGenerator()) || alien.visits(m.city("A")) != 1 || alien.visits(m.city("B")) != 1 {
+		t.Error("Expected alien to remember both A and B, but got", alien.visited)
+	}
+}
+
+func TestExploreMovement(t *testing.T) {
+	m, _ := parseGridTestMap(t)
+	alien := newTestAlien(m.city("A"), ExploreMovement{}, &Perception{Radius: 2})
+	for _, cityName := range []string{"B", "C", "E"} {
+		alien.visit(m.city(cityName))
+	}
+	// via D, the alien can see D and G, which it hasn't visited yet
+	if !alien.Move(NewSequenceGenerator()) || alien.city.name != "D" {
+		t.Error("Expected alien to explore towards D, but got", alien)
+	}
+}
+
+func TestPerception(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader("Foo east=Bar\n"))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	alien := newTestAlien(m.city("Foo"), RandomMovement{}, &Perception{Radius: 1, MisjudgeChance: 1})
+	if alien.Move(NewSequenceGenerator()) {
+		t.Error("Expected alien to misjudge Bar as destroyed and stay in Foo, but got", alien)
+	}
+
+	m.city("Bar").state = CityDestroyed
+	alien = newTestAlien(m.city("Foo"), RandomMovement{}, nil)
+	if alien.Move(NewSequenceGenerator()) {
+		t.Error("Expected alien to know Bar has been destroyed, but got", alien)
+	}
+	alien = newTestAlien(m.city("Foo"), RandomMovement{}, &Perception{Radius: 0})
+	if !alien.Move(NewSequenceGenerator()) || alien.city.name != "Bar" {
+		t.Error("Expected alien that can't see Bar to move there anyway, but got", alien)
+	}
+}
+
+func TestBlindAliensDontDestroyRuinsAgain(t *testing.T) {
+	placement, err := NewExplicitPlacement(strings.NewReader("A\nC\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	sim := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("A east=B\nB east=C\n"), 2).
+			WithPlacement(placement).
+			WithPerception(0, 0),
+	)
+	if err := sim.Start(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	sim.worldMap.city("B").state = CityDestroyed
+
+	// both aliens walk into the ruins of B, where there's nothing left for them
+	// to fight over
+	for i := 0; i < 2; i++ {
+		if _, err := sim.Step(); err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+	}
+	if sim.lastDestruction != -1 || sim.citiesDestroyed != 0 {
+		t.Error("Expected B not to be destroyed again, but it was destroyed during iteration", sim.lastDestruction)
+	}
+	if stats := sim.speciesStatsFor(sim.aliens[0].species); stats.CitiesDestroyed != 0 {
+		t.Error("Expected no cities to have been destroyed by the aliens, but got", stats.CitiesDestroyed)
+	}
+	for _, alien := range sim.aliens {
+		if !alien.alive {
+			t.Error("Expected both aliens to survive, but got", alien)
+		}
+	}
+}
+
+func TestSimulationReportsVisitedCities(t *testing.T) {
+	species, err := ParseSpecies(strings.NewReader("Explorer movement=explore fights-own-kind=false\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	res, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader(gridTestMap), 2).
+			WithSpawnCities([]string{"A"}).
+			WithSpecies(species...).
+			WithPerception(2, 0),
+	).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if len(res.AlienVisitedCities) != 2 {
+		t.Fatal("Expected visited cities for 2 aliens, but got", res.AlienVisitedCities)
+	}
+	for id, visited := range res.AlienVisitedCities {
+		// exploring aliens should find all of the cities within 10 moves
+		if visited != 9 {
+			t.Error("Expected alien", id, "to have visited all 9 cities, but got", visited)
+		}
+	}
+}
+
+func TestSimulationRejectsNegativeSightRadius(t *testing.T) {
+	for _, radius := range []int{-1, -5} {
+		_, err := NewSimulation(
+			newTestSimulationConfig(strings.NewReader("Foo east=Bar\n"), 2).
+				WithPerception(radius, 0),
+		).Simulate()
+		if serr, ok := err.(*SimulationError); !ok || serr.Code() != ErrInvalidConfig {
+			t.Error("Expected ErrInvalidConfig for a sight radius of", radius, "but got", err)
+		}
+	}
+}
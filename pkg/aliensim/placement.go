@@ -0,0 +1,242 @@
+package aliensim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// PlacementStrategy decides in which cities newly arriving aliens are placed.
+type PlacementStrategy interface {
+	// Place picks a city for each of the given number of new aliens from the
+	// given spawn cities, none of which have been destroyed. Cities in which
+	// living aliens currently are are flagged in occupied. Returns an error if
+	// the aliens cannot all be placed.
+	Place(rnd RandomGenerator, cities []*City, occupied map[*City]bool, count int) ([]*City, error)
+}
+
+// UniformPlacement places each alien in any of the spawn cities at random,
+// regardless of where the other aliens are. Several aliens can therefore start
+// off in the same city, in which case they will fight as soon as the
+// simulation starts.
+//
+// This strategy has a slight modulo bias towards the first few cities, but is
+// kept as is so that existing simulations remain reproducible.
+type UniformPlacement struct{}
+
+// UniquePlacement places aliens in spawn cities at random, but never places an
+// alien in a city that already has an alien in it.
+type UniquePlacement struct{}
+
+// WeightedPlacement places each alien in a spawn city at random, where the
+// chance of picking a particular city is proportional to the value of the given
+// attribute of the city (e.g. "Foo[pop=30]"). Cities without the attribute are
+// never picked.
+type WeightedPlacement struct {
+	Attribute string
+}
+
+// ExplicitPlacement places aliens in the cities listed in a placement file, in
+// order: the first alien is placed in the city on the first line, the second
+// alien in the city on the second line, and so on. Each simulation works
+// through the list from the start, so the same placement can be given to any
+// number of simulations.
+type ExplicitPlacement struct {
+	cityNames []string
+	next      int // The index of the city in which to place the next alien.
+}
+
+// ClusteredPlacement picks a random spawn city as the centre of each group of
+// aliens being placed, and places the aliens at random in spawn cities at most
+// Radius roads away from it.
+type ClusteredPlacement struct {
+	Radius int
+}
+
+// DefaultWeightAttribute is the city attribute used by weighted placement when
+// no other attribute is specified.
+const DefaultWeightAttribute = "weight"
+
+// DefaultClusterRadius is the radius used by clustered placement when no
+// other radius is specified.
+const DefaultClusterRadius = spawnClusterRadius
+
+// ParsePlacementStrategy converts the given placement strategy name ("uniform",
+// "unique", "weighted" or "clustered") into its corresponding strategy.
+// Weighted placement uses the "weight" attribute unless another attribute is
+// given after a colon (e.g. "weighted:pop"). Explicit placement needs a
+// placement file and is created with NewExplicitPlacement instead.
+func ParsePlacementStrategy(name string) (PlacementStrategy, error) {
+	parts := strings.SplitN(name, ":", 2)
+	switch strings.ToLower(parts[0]) {
+	case "uniform":
+		return UniformPlacement{}, nil
+	case "unique":
+		return UniquePlacement{}, nil
+	case "weighted":
+		if len(parts) > 1 && len(parts[1]) > 0 {
+			return WeightedPlacement{Attribute: parts[1]}, nil
+		}
+		return WeightedPlacement{Attribute: DefaultWeightAttribute}, nil
+	case "clustered":
+		return ClusteredPlacement{Radius: DefaultClusterRadius}, nil
+	}
+	return nil, NewExtendedSimulationError(
+		ErrInvalidConfig,
+		fmt.Sprintf("Unknown placement strategy \"%s\" (must be one of uniform, unique, weighted or clustered).", name),
+		nil,
+	)
+}
+
+// NewExplicitPlacement reads a placement file, which lists the name of the city
+// in which to place each alien on its own line. Blank lines are ignored.
+func NewExplicitPlacement(r io.Reader) (*ExplicitPlacement, error) {
+	cityNames := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if cityName := strings.TrimSpace(scanner.Text()); len(cityName) > 0 {
+			cityNames = append(cityNames, cityName)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, NewExtendedSimulationError(ErrInvalidConfig, "Failed to read placement file.", err)
+	}
+	return &ExplicitPlacement{cityNames: cityNames}, nil
+}
+
+// placementFor returns the placement strategy a simulation should use, given
+// the one it was configured with: an ExplicitPlacement is copied, so that the
+// simulation keeps track of its own position in the list of cities.
+func placementFor(placement PlacementStrategy) PlacementStrategy {
+	switch p := placement.(type) {
+	case nil:
+		return UniformPlacement{}
+	case *ExplicitPlacement:
+		return &ExplicitPlacement{cityNames: p.cityNames}
+	}
+	return placement
+}
+
+// Place implements PlacementStrategy.
+func (p UniformPlacement) Place(rnd RandomGenerator, cities []*City, occupied map[*City]bool, count int) ([]*City, error) {
+	if len(cities) == 0 {
+		return nil, errNoSpawnCities(count)
+	}
+	placed := []*City{}
+	for n := 0; n < count; n++ {
+		placed = append(placed, cities[rnd.Uint32()%uint32(len(cities))])
+	}
+	return placed, nil
+}
+
+// Place implements PlacementStrategy.
+func (p UniquePlacement) Place(rnd RandomGenerator, cities []*City, occupied map[*City]bool, count int) ([]*City, error) {
+	free := []*City{}
+	for _, city := range cities {
+		if !occupied[city] {
+			free = append(free, city)
+		}
+	}
+	if count > len(free) {
+		return nil, NewExtendedSimulationError(
+			ErrInvalidConfig,
+			fmt.Sprintf("Cannot place %d aliens at most one per city, as only %d spawn cities are free.", count, len(free)),
+			nil,
+		)
+	}
+	// partial Fisher-Yates shuffle of the free cities
+	for n := 0; n < count; n++ {
+		i := n + randomIndex(rnd, len(free)-n)
+		free[n], free[i] = free[i], free[n]
+	}
+	return free[:count], nil
+}
+
+// Place implements PlacementStrategy.
+func (p WeightedPlacement) Place(rnd RandomGenerator, cities []*City, occupied map[*City]bool, count int) ([]*City, error) {
+	weights := make([]int, len(cities))
+	total := 0
+	for i, city := range cities {
+		value, exists := city.attributes[p.Attribute]
+		if !exists {
+			continue
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 0 {
+			return nil, NewExtendedSimulationError(
+				ErrInvalidCityAttribute,
+				fmt.Sprintf("The %s of %s must be a non-negative integer, but got \"%s\".", p.Attribute, city.name, value),
+				err,
+			)
+		}
+		weights[i] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, NewExtendedSimulationError(
+			ErrInvalidConfig,
+			fmt.Sprintf("Cannot place %d aliens, as none of the spawn cities have a positive %s.", count, p.Attribute),
+			nil,
+		)
+	}
+	placed := []*City{}
+	for n := 0; n < count; n++ {
+		target := randomIndex(rnd, total)
+		for i, weight := range weights {
+			if target < weight {
+				placed = append(placed, cities[i])
+				break
+			}
+			target -= weight
+		}
+	}
+	return placed, nil
+}
+
+// Place implements PlacementStrategy.
+func (p *ExplicitPlacement) Place(rnd RandomGenerator, cities []*City, occupied map[*City]bool, count int) ([]*City, error) {
+	if p.next+count > len(p.cityNames) {
+		return nil, NewExtendedSimulationError(
+			ErrInvalidConfig,
+			fmt.Sprintf("The placement file lists %d cities, but %d aliens need placing.", len(p.cityNames), p.next+count),
+			nil,
+		)
+	}
+	available := map[string]*City{}
+	for _, city := range cities {
+		available[city.name] = city
+	}
+	placed := []*City{}
+	for _, cityName := range p.cityNames[p.next : p.next+count] {
+		city, exists := available[cityName]
+		if !exists {
+			return nil, NewExtendedSimulationError(
+				ErrUnknownCity,
+				fmt.Sprintf("Cannot place an alien in %s, as it is not a spawn city still standing.", cityName),
+				nil,
+			)
+		}
+		placed = append(placed, city)
+	}
+	p.next += count
+	return placed, nil
+}
+
+// Place implements PlacementStrategy.
+func (p ClusteredPlacement) Place(rnd RandomGenerator, cities []*City, occupied map[*City]bool, count int) ([]*City, error) {
+	if len(cities) == 0 {
+		return nil, errNoSpawnCities(count)
+	}
+	centre := cities[rnd.Uint32()%uint32(len(cities))]
+	return UniformPlacement{}.Place(rnd, centre.nearby(cities, p.Radius), occupied, count)
+}
+
+func errNoSpawnCities(count int) error {
+	return NewExtendedSimulationError(
+		ErrInvalidConfig,
+		fmt.Sprintf("Cannot place %d aliens, as there are no spawn cities left.", count),
+		nil,
+	)
+}
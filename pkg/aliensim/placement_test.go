@@ -0,0 +1,152 @@
+package aliensim
+
+import (
+	"strings"
+	"testing"
+)
+
+// cityNames returns the names of the given cities, in order.
+func cityNames(cities []*City) []string {
+	names := []string{}
+	for _, city := range cities {
+		names = append(names, city.name)
+	}
+	return names
+}
+
+func parseGridTestMap(t *testing.T) (*WorldMap, []*City) {
+	m, err := ParseWorldMap(strings.NewReader(gridTestMap))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	return m, m.Cities()
+}
+
+func TestParsePlacementStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected PlacementStrategy
+	}{
+		{"uniform", UniformPlacement{}},
+		{"Unique", UniquePlacement{}},
+		{"weighted", WeightedPlacement{Attribute: "weight"}},
+		{"weighted:pop", WeightedPlacement{Attribute: "pop"}},
+		{"clustered", ClusteredPlacement{Radius: 2}},
+	}
+	for _, test := range tests {
+		placement, err := ParsePlacementStrategy(test.name)
+		if err != nil {
+			t.Error("Expected no error for", test.name, "but got", err)
+		} else if placement != test.expected {
+			t.Error("Expected", test.expected, "for", test.name, "but got", placement)
+		}
+	}
+	if _, err := ParsePlacementStrategy("random"); err == nil {
+		t.Error("Expected an error for an unknown placement strategy")
+	}
+}
+
+func TestRandomIndexAvoidsModuloBias(t *testing.T) {
+	// 2^32 mod 3 = 1, so the very top value must be skipped
+	rnd := &SequenceGenerator{next: 0xFFFFFFFF}
+	if i := randomIndex(rnd, 3); i != 0 || rnd.next != 1 {
+		t.Error("Expected the top value to be rejected, but got", i, "after", rnd.next, "draws")
+	}
+	rnd = &SequenceGenerator{next: 0xFFFFFFFE}
+	if i := randomIndex(rnd, 4); i != 2 || rnd.next != 0xFFFFFFFF {
+		t.Error("Expected no values to be rejected for a power of two, but got", i)
+	}
+}
+
+func TestUniquePlacement(t *testing.T) {
+	m, cities := parseGridTestMap(t)
+	occupied := map[*City]bool{m.city("A"): true, m.city("B"): true}
+	placed, err := UniquePlacement{}.Place(NewSequenceGenerator(), cities, occupied, 7)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	seen := map[*City]bool{}
+	for _, city := range placed {
+		if seen[city] || occupied[city] {
+			t.Error("Expected each alien to be placed in its own free city, but got", cityNames(placed))
+		}
+		seen[city] = true
+	}
+	if _, err := (UniquePlacement{}).Place(NewSequenceGenerator(), cities, occupied, 8); err == nil {
+		t.Error("Expected an error when placing more aliens than there are free cities")
+	}
+}
+
+func TestWeightedPlacement(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader("Foo[pop=1] north=Bar\nBar[pop=3] east=Baz\n"))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	cities := []*City{m.city("Foo"), m.city("Bar"), m.city("Baz")}
+	placed, err := WeightedPlacement{Attribute: "pop"}.Place(NewSequenceGenerator(), cities, nil, 8)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	expected := []string{"Foo", "Bar", "Bar", "Bar", "Foo", "Bar", "Bar", "Bar"}
+	if !stringSlicesEqual(cityNames(placed), expected) {
+		t.Error("Expected", expected, "but got", cityNames(placed))
+	}
+	if _, err := (WeightedPlacement{Attribute: "weight"}).Place(NewSequenceGenerator(), cities, nil, 2); err == nil {
+		t.Error("Expected an error when none of the cities have a weight")
+	}
+	m.city("Baz").setAttribute("pop", "lots")
+	if _, err := (WeightedPlacement{Attribute: "pop"}).Place(NewSequenceGenerator(), cities, nil, 2); err == nil {
+		t.Error("Expected an error for an invalid weight")
+	}
+}
+
+func TestExplicitPlacement(t *testing.T) {
+	_, cities := parseGridTestMap(t)
+	placement, err := NewExplicitPlacement(strings.NewReader("E\n\nA\n  E \nI\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	placed, err := placement.Place(NewSequenceGenerator(), cities, nil, 3)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if expected := []string{"E", "A", "E"}; !stringSlicesEqual(cityNames(placed), expected) {
+		t.Error("Expected", expected, "but got", cityNames(placed))
+	}
+	if _, err := placement.Place(NewSequenceGenerator(), cities, nil, 2); err == nil {
+		t.Error("Expected an error when the placement file runs out of cities")
+	}
+	if _, err := placement.Place(NewSequenceGenerator(), cities[:3], nil, 1); err == nil {
+		t.Error("Expected an error when placing an alien in a city that isn't a spawn city")
+	}
+}
+
+func TestExplicitPlacementCanBeReused(t *testing.T) {
+	placement, err := NewExplicitPlacement(strings.NewReader("Foo\nBaz\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	config := newTestSimulationConfig(strings.NewReader(ExampleWorld), 2).WithPlacement(placement)
+	for i := 0; i < 2; i++ {
+		config.worldReader = strings.NewReader(ExampleWorld)
+		sim := NewSimulation(config)
+		if err := sim.Start(); err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if names := []string{sim.aliens[0].city.name, sim.aliens[1].city.name}; !stringSlicesEqual(names, []string{"Foo", "Baz"}) {
+			t.Error("Expected the aliens to be placed in Foo and Baz, but got", names)
+		}
+	}
+}
+
+func TestClusteredPlacement(t *testing.T) {
+	_, cities := parseGridTestMap(t)
+	// the sequence generator picks A (the first city) as the centre
+	placed, err := ClusteredPlacement{Radius: 1}.Place(NewSequenceGenerator(), cities, nil, 4)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if expected := []string{"B", "D", "A", "B"}; !stringSlicesEqual(cityNames(placed), expected) {
+		t.Error("Expected", expected, "but got", cityNames(placed))
+	}
+}
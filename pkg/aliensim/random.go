@@ -1,6 +1,7 @@
 package aliensim
 
 import (
+	"math"
 	"math/rand"
 	"time"
 )
@@ -31,6 +32,14 @@ func NewPseudorandomGenerator() *PseudorandomGenerator {
 	}
 }
 
+// NewSeededGenerator creates a new pseudorandom number generator seeded with
+// the given value, so that simulations using it can be reproduced.
+func NewSeededGenerator(seed int64) *PseudorandomGenerator {
+	return &PseudorandomGenerator{
+		r: rand.New(rand.NewSource(seed)),
+	}
+}
+
 // NewSequenceGenerator instantiates a sequence generator starting at 0.
 func NewSequenceGenerator() *SequenceGenerator {
 	return &SequenceGenerator{next: 0}
@@ -47,3 +56,56 @@ func (s *SequenceGenerator) Uint32() uint32 {
 	s.next++
 	return s.next - 1
 }
+
+// randomChance returns true with the given probability (between 0 and 1),
+// drawing a single number from the given generator. Never draws a number if the
+// probability is zero or less.
+func randomChance(rnd RandomGenerator, probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	return float64(rnd.Uint32()) < probability*float64(math.MaxUint32)
+}
+
+// randomIndex returns a number from 0 up to (but excluding) n, drawing as many
+// numbers from the given generator as it takes to avoid any modulo bias.
+func randomIndex(rnd RandomGenerator, n int) int {
+	bound := uint32(n)
+	// 2^32 mod n: the number of values at the top of the range that would
+	// favour the lowest indices
+	excess := -bound % bound
+	for {
+		r := rnd.Uint32()
+		if excess == 0 || r < -excess {
+			return int(r % bound)
+		}
+	}
+}
+
+// streamGenerator is a small, fast pseudorandom number generator (SplitMix64),
+// which is cheap enough to create that each block of aliens after the first can
+// be given its own stream of random numbers whenever they're moved.
+type streamGenerator struct {
+	state uint64
+}
+
+// newStreamGenerator creates the generator for the given stream of random
+// numbers derived from the given seed. Different streams derived from the same
+// seed don't overlap.
+func newStreamGenerator(seed uint64, stream int) *streamGenerator {
+	return &streamGenerator{state: mix64(seed ^ mix64(uint64(stream)))}
+}
+
+// Uint32 generates the next number in the stream.
+func (g *streamGenerator) Uint32() uint32 {
+	g.state += 0x9e3779b97f4a7c15
+	return uint32(mix64(g.state) >> 32)
+}
+
+// mix64 scrambles the bits of the given value (this is the SplitMix64 output
+// function).
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
@@ -13,3 +13,27 @@ func TestSequenceGenerator(t *testing.T) {
 		}
 	}
 }
+
+func TestSeededGenerator(t *testing.T) {
+	a, b := NewSeededGenerator(42), NewSeededGenerator(42)
+	for i := 0; i < 100; i++ {
+		if x, y := a.Uint32(), b.Uint32(); x != y {
+			t.Fatal("Expected generators with the same seed to agree, but got", x, "and", y)
+		}
+	}
+}
+
+func TestStreamGenerator(t *testing.T) {
+	a, b, c := newStreamGenerator(42, 0), newStreamGenerator(42, 0), newStreamGenerator(42, 1)
+	differ := false
+	for i := 0; i < 100; i++ {
+		x, y, z := a.Uint32(), b.Uint32(), c.Uint32()
+		if x != y {
+			t.Fatal("Expected the same stream of the same seed to agree, but got", x, "and", y)
+		}
+		differ = differ || x != z
+	}
+	if !differ {
+		t.Error("Expected different streams of the same seed to differ")
+	}
+}
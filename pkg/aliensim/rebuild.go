@@ -0,0 +1,69 @@
+package aliensim
+
+import "strconv"
+
+// CityRebuild records when a particular city was destroyed and rebuilt.
+type CityRebuild struct {
+	CityName    string
+	DestroyedAt int // The iteration during which the city was destroyed.
+	RebuiltAt   int // The iteration at the end of which the city was rebuilt.
+}
+
+// rebuildCities rebuilds any destroyed cities whose time has come, restoring
+// their original defences. Returns true if any cities were rebuilt.
+func (s *Simulation) rebuildCities() bool {
+	if s.config.rebuildAfter <= 0 {
+		return false
+	}
+	rebuilt := false
+	remaining := s.ruins[:0]
+	for _, city := range s.ruins {
+		if s.iteration-city.destroyedAt < s.config.rebuildAfter ||
+			(s.config.rebuildNearby && !city.hasStandingNeighbour()) {
+			remaining = append(remaining, city)
+			continue
+		}
+		city.state = CityIntact
+		city.defence = 0
+		if def, ok := city.attributes[CityAttrDefence]; ok {
+			city.defence, _ = strconv.Atoi(def)
+		}
+		s.citiesDestroyed--
+		if s.components != nil {
+			s.components.cityRebuilt(city)
+		}
+		s.rebuilds = append(s.rebuilds, CityRebuild{
+			CityName:    city.name,
+			DestroyedAt: city.destroyedAt,
+			RebuiltAt:   s.iteration,
+		})
+		rebuilt = true
+		if handler, ok := s.config.progressHandler.(RebuildEventHandler); ok {
+			handler.CityRebuilt(city.name)
+		}
+	}
+	s.ruins = remaining
+	return rebuilt
+}
+
+// rebuildsPending checks whether any destroyed cities are still waiting for
+// their time to be rebuilt to come.
+func (s *Simulation) rebuildsPending() bool {
+	for _, city := range s.ruins {
+		if s.iteration-city.destroyedAt < s.config.rebuildAfter {
+			return true
+		}
+	}
+	return false
+}
+
+// hasStandingNeighbour checks whether any of this city's neighbours have not
+// been destroyed.
+func (c *City) hasStandingNeighbour() bool {
+	for _, neighbour := range c.neighbours {
+		if neighbour != nil && !neighbour.Destroyed() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package aliensim
+
+// Parent returns the ID of the alien that gave birth to this one, or -1 if this
+// alien arrived on the map instead of being born on it.
+func (a *Alien) Parent() int {
+	return a.parent
+}
+
+// reproduce gives each living alien in an intact city that has spent long
+// enough in intact cities a chance to give birth to an offspring, as long as
+// the population cap hasn't been reached. Offspring belong to the same species
+// as their parents, and are born where they won't fight their parent (see
+// birthplace). Returns the number of aliens born.
+func (s *Simulation) reproduce() int {
+	if s.config.reproduceChance <= 0 {
+		return 0
+	}
+	population := s.livingAliens()
+	occupied := map[*City]bool{}
+	for _, alien := range s.aliens {
+		if alien.alive && alien.state == AlienInCity {
+			occupied[alien.city] = true
+		}
+	}
+	born := 0
+	// the range is only evaluated once, so aliens born during this iteration
+	// don't get a chance to reproduce yet
+	for _, parent := range s.aliens {
+		if !parent.alive || parent.state != AlienInCity || parent.city.state != CityIntact {
+			continue
+		}
+		// this iteration only counts towards the parent's time in intact
+		// cities once it has had its chance to reproduce
+		mature := parent.timeInCities >= s.config.reproduceAfter
+		parent.timeInCities++
+		if !mature || (s.config.populationCap > 0 && population >= s.config.populationCap) {
+			continue
+		}
+		if !randomChance(s.config.rnd, s.config.reproduceChance) {
+			continue
+		}
+		city := s.birthplace(parent, occupied)
+		if city == nil {
+			continue
+		}
+		occupied[city] = true
+		offspring := NewAlien(len(s.aliens), city)
+		offspring.spawnedAt = s.iteration
+		offspring.species = parent.species
+		offspring.parent = parent.id
+		offspring.perception = parent.perception
+		offspring.energy = s.config.energyBudget
+		s.aliens = append(s.aliens, offspring)
+		s.recordArrival(offspring)
+		s.speciesStatsFor(offspring.species).Born++
+		population++
+		born++
+		if handler, ok := s.config.progressHandler.(ReproductionEventHandler); ok {
+			handler.AlienBorn(offspring.id, parent.id, city.name)
+		}
+	}
+	return born
+}
+
+// birthplace picks the city in which the given parent's offspring is born. If
+// the parent's species doesn't fight its own kind, that's the parent's own
+// city. Otherwise it's a random intact city without any aliens in it (as
+// flagged in occupied) that the parent could travel to, so that the offspring
+// doesn't start out in a fight. Returns nil if there is no such city.
+func (s *Simulation) birthplace(parent *Alien, occupied map[*City]bool) *City {
+	if !parent.species.FightsOwnKind {
+		return parent.city
+	}
+	cities := []*City{}
+	for _, road := range parent.standingRoads() {
+		if city := road.Other(parent.city); city.state == CityIntact && !occupied[city] {
+			cities = append(cities, city)
+		}
+	}
+	if len(cities) == 0 {
+		return nil
+	}
+	return cities[randomIndex(s.config.rnd, len(cities))]
+}
+
+// livingAliens counts how many aliens are still alive.
+func (s *Simulation) livingAliens() int {
+	living := 0
+	for _, alien := range s.aliens {
+		if alien.alive {
+			living++
+		}
+	}
+	return living
+}
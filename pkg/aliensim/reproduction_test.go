@@ -0,0 +1,108 @@
+package aliensim
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tests that aliens in intact cities give birth to offspring once they're old
+// enough, up to the population cap.
+func TestSimulationWithReproduction(t *testing.T) {
+	tests := []struct {
+		reproduceAfter int
+		population     []int
+		parents        map[int]int
+	}{
+		{0, []int{4, 5, 5}, map[int]int{2: 0, 3: 1, 4: 0}},
+		{2, []int{2}, map[int]int{}},
+	}
+	species, err := ParseSpecies(strings.NewReader("Breeder fights-own-kind=false\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	for _, test := range tests {
+		res, err := NewSimulation(
+			newTestSimulationConfig(strings.NewReader("Foo\n"), 2).
+				WithSpecies(species...).
+				WithReproduction(test.reproduceAfter, 1, 5),
+		).Simulate()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if len(res.Population) != len(test.population) {
+			t.Fatal("Expected population", test.population, "but got", res.Population)
+		}
+		for i, population := range test.population {
+			if res.Population[i] != population {
+				t.Error("Expected population", test.population, "but got", res.Population)
+			}
+		}
+		if len(res.AlienParents) != len(test.parents) {
+			t.Error("Expected parents", test.parents, "but got", res.AlienParents)
+		}
+		for id, parent := range test.parents {
+			if res.AlienParents[id] != parent {
+				t.Error("Expected alien", id, "to be the offspring of alien", parent, "but got", res.AlienParents[id])
+			}
+		}
+		if born := res.SpeciesStats["Breeder"].Born; born != len(test.parents) {
+			t.Error("Expected", len(test.parents), "aliens to be born, but got", born)
+		}
+	}
+}
+
+// Tests that the offspring of aliens that fight their own kind are born in a
+// neighbouring city without any aliens in it, rather than fighting their
+// parents straight away.
+func TestOffspringAreBornAwayFromOtherAliens(t *testing.T) {
+	s := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("Foo east=Bar\nBar east=Baz\nBaz east=Qux\n"), 2).
+			WithReproduction(0, 1, 50),
+	)
+	if err := s.Start(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if _, err := s.Step(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	// alien 0 moved to Bar and alien 1 to Foo, which leaves Baz as the only
+	// free city next to either of them
+	expected := []string{"Alien 0 in Bar (alive=true)", "Alien 1 in Foo (alive=true)", "Alien 2 in Baz (alive=true)"}
+	described := []string{}
+	for _, alien := range s.Aliens() {
+		described = append(described, alien.String())
+	}
+	if !stringSlicesEqual(described, expected) {
+		t.Error("Expected aliens", expected, "but got", described)
+	}
+	if s.citiesDestroyed != 0 {
+		t.Error("Expected no cities to be destroyed, but got", s.citiesDestroyed)
+	}
+	if parent := s.Aliens()[2].Parent(); parent != 0 {
+		t.Error("Expected alien 2 to be the offspring of alien 0, but got", parent)
+	}
+}
+
+// Tests that only the iterations aliens spend in intact cities count towards
+// when they can reproduce.
+func TestReproductionOnlyCountsTimeInCities(t *testing.T) {
+	placement, err := NewExplicitPlacement(strings.NewReader("Foo\nBaz\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	// the aliens are on the road for 4 iterations at a time, so they only end
+	// iterations 4 and 9 in a city
+	res, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("Foo east=Bar:5\nBaz east=Qux:5\n"), 2).
+			WithPlacement(placement).
+			WithReproduction(1, 1, 4),
+	).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	for _, id := range []int{2, 3} {
+		if spawnedAt, born := res.AlienSpawnTimes[id]; !born || spawnedAt != 9 {
+			t.Error("Expected alien", id, "to be born during iteration 9, but got", res.AlienSpawnTimes)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package aliensim
+
+import "fmt"
+
+// DefaultRoadLength is the number of iterations it takes to travel along a road
+// whose length has not been specified.
+const DefaultRoadLength = 1
+
+// Road links two neighbouring cities. Roads can be travelled in both
+// directions, unless they are one-way roads, in which case they can only be
+// travelled from the city that declared them. Travelling along a road takes as
+// many iterations as the length of the road.
+type Road struct {
+	id            int   // The index of this road in its world map's list of roads.
+	from          *City // The city that declared this road.
+	to            *City // The city at the other end of the road.
+	oneWay        bool  // Can this road only be travelled from the declaring city?
+	length        int   // How many iterations it takes to travel along this road.
+	lengthDefined bool  // Was the length explicitly given in the input map?
+	destroyed     bool  // Has the road been destroyed yet?
+}
+
+// NewRoad creates a road from one city to another. If oneWay is true, the road
+// can only be travelled from the first city to the second.
+func NewRoad(from, to *City, oneWay bool) *Road {
+	return &Road{
+		from:   from,
+		to:     to,
+		oneWay: oneWay,
+		length: DefaultRoadLength,
+	}
+}
+
+func (r *Road) String() string {
+	link := "<->"
+	if r.oneWay {
+		link = "->"
+	}
+	return fmt.Sprintf("Road{%s %s %s, length: %d}", r.from.name, link, r.to.name, r.length)
+}
+
+// Other returns the city at the opposite end of the road to the given city.
+func (r *Road) Other(city *City) *City {
+	if city == r.from {
+		return r.to
+	}
+	return r.from
+}
+
+// AllowsTravelFrom checks whether an alien in the given city may use this road.
+// Destroyed roads cannot be travelled at all.
+func (r *Road) AllowsTravelFrom(city *City) bool {
+	return !r.destroyed && (!r.oneWay || city == r.from)
+}
+
+// From returns the city that declared this road.
+func (r *Road) From() *City {
+	return r.from
+}
+
+// To returns the city at the other end of this road.
+func (r *Road) To() *City {
+	return r.to
+}
+
+// Destroyed returns whether this road has been destroyed.
+func (r *Road) Destroyed() bool {
+	return r.destroyed
+}
+
+// OneWay returns whether this road can only be travelled in one direction.
+func (r *Road) OneWay() bool {
+	return r.oneWay
+}
+
+// Length returns the number of iterations it takes to travel along this road.
+func (r *Road) Length() int {
+	return r.length
+}
+
+// setLength sets the length of this road, making sure it doesn't contradict a
+// length given elsewhere in the input map.
+func (r *Road) setLength(length int) error {
+	if r.lengthDefined && r.length != length {
+		return NewExtendedSimulationError(
+			ErrInvalidRoadLength,
+			fmt.Sprintf(
+				"The road between %s and %s cannot have both length %d and %d.",
+				r.from.name,
+				r.to.name,
+				r.length,
+				length,
+			),
+			nil,
+		)
+	}
+	r.length = length
+	r.lengthDefined = true
+	return nil
+}
+
+// buildRoad ensures that there is a road from this city to its neighbour in the
+// given direction. Redeclaring an existing road as a two-way road, or as a
+// one-way road from the opposite end, turns it into a two-way road.
+func (c *City) buildRoad(dir int, oneWay bool) {
+	neighbour := c.neighbours[dir]
+	dopp := mapDirectionOpposites[dir]
+	road := c.roads[dir]
+	if road == nil && neighbour.neighbours[dopp] == c {
+		road = neighbour.roads[dopp]
+	}
+	if road == nil {
+		road = NewRoad(c, neighbour, oneWay)
+	} else if !oneWay || road.from != c {
+		road.oneWay = false
+	}
+	c.roads[dir] = road
+	if neighbour.neighbours[dopp] == c {
+		neighbour.roads[dopp] = road
+	}
+}
+
+// inferRoads builds two-way roads along any links between neighbouring cities
+// that were inferred rather than declared in the input map, and then keeps
+// track of all of the roads on the map. Only the roads of cities whose links
+// have changed are looked at.
+func (m *WorldMap) inferRoads() {
+	for _, city := range m.dirtyCities {
+		for dir, neighbour := range city.neighbours {
+			if neighbour != nil && city.roads[dir] == nil {
+				city.buildRoad(dir, false)
+			}
+			if road := city.roads[dir]; road != nil && !m.hasRoad(road) {
+				road.id = len(m.roads)
+				m.roads = append(m.roads, road)
+			}
+		}
+	}
+}
+
+// numberRoads lists the roads on this world map in the order of the cities
+// they leave from (and their directions from them), no matter the order in
+// which the links between the cities were inferred.
+func (m *WorldMap) numberRoads() {
+	m.roads = m.roads[:0]
+	for _, city := range m.cities {
+		for _, road := range city.roads {
+			if road != nil && !m.hasRoad(road) {
+				road.id = len(m.roads)
+				m.roads = append(m.roads, road)
+			}
+		}
+	}
+}
+
+// hasRoad checks whether the given road is already in this world map's list of
+// roads.
+func (m *WorldMap) hasRoad(road *Road) bool {
+	return road.id < len(m.roads) && m.roads[road.id] == road
+}
@@ -1,16 +1,16 @@
 package aliensim
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"strings"
-
-	"github.com/deckarep/golang-set"
+	"time"
 )
 
 // SimulationIterationsHardLimit is a hard limit on the number of iterations we
 // can run through in any given simulation. This is not the same as the limit on
-// the maximum number of alien moves.
+// the number of moves each alien has the energy for.
 const SimulationIterationsHardLimit = 20000
 
 // ExampleWorld is the map from the problem statement.
@@ -22,10 +22,30 @@ Bar south=Foo west=Bee
 // simulator.
 type SimulationConfig struct {
 	worldReader     io.Reader
+	mapParser       *MapParser // How to parse the world map (nil = with the default parser).
 	aliens          int
 	rnd             RandomGenerator
-	maxAlienMoves   int
+	parallelism     int // How many goroutines move the aliens during each iteration (0 = they're moved one at a time, with the same result).
+	energyBudget    int // How many moves each alien can make on a full charge.
+	rechargeRate    int // How much energy aliens regain for each iteration spent in an intact city.
 	progressHandler SimulationProgressHandler
+	roadEncounters  bool              // Do aliens meeting head-on while on a road fight each other?
+	roadCollapse    float64           // The chance of each road collapsing during any given iteration.
+	defenderKill    float64           // The chance of a defended city killing a lone alien in any given iteration.
+	rebuildAfter    int               // How many iterations it takes to rebuild a destroyed city (0 = never).
+	rebuildNearby   bool              // Are cities only rebuilt if one of their neighbours is still standing?
+	spawnCities     []string          // The cities in which aliens can spawn (overrides any spawn points in the map).
+	spawnPattern    SpawnPattern      // How aliens are distributed across the spawn cities.
+	placement       PlacementStrategy // How aliens are placed in the spawn cities.
+	reinforcements  []AlienWave       // Any waves of aliens arriving after the initial ones.
+	species         []*Species        // The species of alien taking part in the invasion.
+	reproduceAfter  int               // How many iterations an alien must spend in intact cities before it can reproduce.
+	reproduceChance float64           // The chance of an alien in an intact city giving birth in any given iteration.
+	populationCap   int               // The maximum number of living aliens reproduction can lead to (0 = no limit).
+	perception      *Perception       // How much aliens know about their surroundings (nil = all of their neighbours).
+	stopCriteria    []StopCriterion   // When to stop the simulation, other than when none of the aliens can move.
+	trajectories    bool              // Do we record the path taken by each alien?
+	instrumentation Instrumentation   // What to notify of how the simulation is going, if anything.
 }
 
 // SimulationResult will eventually contain our simulation results.
@@ -35,172 +55,882 @@ type SimulationResult struct {
 	CitiesRemaining     []string
 	FinalMap            *WorldMap
 	FinalAliens         []*Alien
+	AlienDistances      map[int]int // The total distance travelled by each alien (key=alien ID), living or dead.
+	RoadsDestroyed      int
+	CitiesHeldOut       map[string]int          // Cities that repelled attacks and are still standing, with the defence they have left.
+	Rebuilds            []CityRebuild           // The cities that were rebuilt, in the order in which they were rebuilt.
+	AlienSpawnTimes     map[int]int             // The iteration at which each alien (key=alien ID) arrived.
+	SpeciesStats        map[string]SpeciesStats // How the aliens of each species (key=species name) fared.
+	AlienParents        map[int]int             // The parent of each alien (key=alien ID) born on the map.
+	Population          []int                   // The number of living aliens at the end of each iteration.
+	AlienVisitedCities  map[int]int             // The number of different cities each alien (key=alien ID) has been in.
+	AliensExhausted     int                     // How many living aliens have run out of energy.
+	AliensTrapped       int                     // How many living aliens have energy left, but nowhere to go.
+	AliensDead          int                     // How many aliens have died, whatever the cause.
+	TotalMoves          int                     // The number of moves made by all of the aliens between them.
+	StopReason          string                  // Why the simulation stopped.
+	StopKind            string                  // The kind of reason for which the simulation stopped (one of the StopKind constants).
+	Cancelled           bool                    // Was the simulation cancelled before it was over (in which case the result is partial)?
+	Trajectories        []Trajectory            // Where each alien went and how it fared, in order of alien ID, if recorded.
 }
 
 // SimulationProgressHandler is a simple interface to handle the various
-// different events as they are emitted by the simulation process.
+// different events as they are emitted by the simulation process. Handlers can
+// also implement any of the optional interfaces below (such as
+// RoadEventHandler) to be notified of further events.
 type SimulationProgressHandler interface {
 	CityDestroyed(cityName string, alienIDs []int)
 	AllAliensTrapped()
 	AllAliensDead()
 }
 
+// RoadEventHandler is implemented by progress handlers that want to be
+// notified of roads being destroyed.
+type RoadEventHandler interface {
+	RoadDestroyed(fromCity, toCity string, alienIDs []int)
+}
+
+// DefenceEventHandler is implemented by progress handlers that want to be
+// notified of cities' defenders fighting off aliens.
+type DefenceEventHandler interface {
+	AttackRepelled(cityName string, alienIDs []int, defenceLeft int)
+	AlienKilledByDefenders(cityName string, alienID int)
+}
+
+// RebuildEventHandler is implemented by progress handlers that want to be
+// notified of destroyed cities being rebuilt.
+type RebuildEventHandler interface {
+	CityRebuilt(cityName string)
+}
+
+// ReinforcementEventHandler is implemented by progress handlers that want to
+// be notified of waves of reinforcements arriving.
+type ReinforcementEventHandler interface {
+	ReinforcementsArrived(alienIDs []int)
+}
+
+// SpeciesEventHandler is implemented by progress handlers that want to be
+// notified of how the aliens of each species fare.
+type SpeciesEventHandler interface {
+	FightWon(cityName string, alienID int, speciesName string)
+	SpeciesEliminated(speciesName string)
+}
+
+// ReproductionEventHandler is implemented by progress handlers that want to
+// be notified of aliens being born.
+type ReproductionEventHandler interface {
+	AlienBorn(alienID, parentID int, cityName string)
+}
+
+// EnergyEventHandler is implemented by progress handlers that want to be
+// notified of all of the living aliens running out of energy.
+type EnergyEventHandler interface {
+	AllAliensExhausted()
+}
+
+// IterationHandler is implemented by progress handlers that want to be
+// notified of the status of the simulation at the end of each iteration.
+type IterationHandler interface {
+	IterationCompleted(status SimulationStatus)
+}
+
 type NoopSimulationProgressHandler struct{}
 type StdoutSimulationProgressHandler struct{}
 
+// MultiSimulationProgressHandler passes each event on to all of the handlers it
+// contains, in order.
+type MultiSimulationProgressHandler []SimulationProgressHandler
+
+// roadTraveller keeps track of where an alien travelling along a road was at the
+// start and at the end of an iteration.
+type roadTraveller struct {
+	alienID  int
+	forward  bool // Is the alien heading away from the road's declaring city?
+	position int  // How far the alien was from the road's declaring city at the start of the iteration.
+	end      int  // How far along the road the alien got by the end of the iteration.
+}
+
 // Simulation is our primary structure through which we execute our simulation.
 type Simulation struct {
 	config          *SimulationConfig
 	worldMap        *WorldMap
 	aliens          []*Alien
-	citiesDestroyed mapset.Set
+	citiesDestroyed int // The number of cities currently destroyed.
+	roadsDestroyed  int
+	iteration       int                      // The iteration currently being simulated.
+	ruins           []*City                  // Destroyed cities waiting to be rebuilt, in the order they were destroyed.
+	rebuilds        []CityRebuild            // The cities rebuilt so far.
+	spawnPoints     []*City                  // The cities in which aliens can spawn.
+	speciesStats    map[string]*SpeciesStats // Running stats for each species (key=species name).
+	eliminated      map[string]bool          // The species whose last living alien has been killed.
+	population      []int                    // The number of living aliens at the end of each iteration so far.
+	started         time.Time                // When the simulation started running.
+	moves           int                      // The number of moves made during the iteration just simulated.
+	totalMoves      int                      // The number of moves made so far.
+	lastDestruction int                      // The iteration during which a city was last destroyed (-1 if none have been).
+	collisions      int                      // The number of fights between aliens during the iteration just simulated.
+	components      *componentTracker        // Which intact component each city belongs to, once it's first needed.
+	placement       PlacementStrategy        // How aliens are placed in the spawn cities, with any state kept for this simulation alone, once some have been.
+
+	// scratch space for each iteration, indexed by city and road IDs, which is
+	// kept around to avoid reallocating it every iteration
+	occupants  [][]int           // The IDs of the aliens in each city.
+	travellers [][]roadTraveller // The aliens travelling along each road.
+
+	iterationsSimulated int               // The number of iterations simulated in full so far.
+	finished            bool              // Is the simulation over?
+	aliensStuck         bool              // Did the simulation end because none of the aliens could move?
+	cancelled           bool              // Was the simulation cancelled before it was over?
+	failure             error             // The error that stopped the simulation before it was over, if any.
+	stopReason          string            // Why the simulation ended, once it has.
+	stopKind            string            // The kind of reason for which the simulation ended, once it has.
+	result              *SimulationResult // The result of the simulation, once it has been finished.
 }
 
 // NewSimulationConfig creates a new simulation configuration using the default
-// pseudorandom number generator and a maximum of 10,000 moves as a stop
-// criterion for the simulation.
+// pseudorandom number generator, and with enough energy for each alien to make
+// 10,000 moves.
 func NewSimulationConfig(worldReader io.Reader, aliens int) *SimulationConfig {
 	return &SimulationConfig{
 		worldReader:     worldReader,
 		aliens:          aliens,
 		rnd:             NewPseudorandomGenerator(),
-		maxAlienMoves:   10000,
+		energyBudget:    10000,
 		progressHandler: &StdoutSimulationProgressHandler{},
 	}
 }
 
+// WithRoadEncounters determines whether aliens that meet head-on while
+// travelling along a road fight (and kill) each other, destroying the road in
+// the process. By default, aliens simply pass each other on the road.
+func (c *SimulationConfig) WithRoadEncounters(enabled bool) *SimulationConfig {
+	c.roadEncounters = enabled
+	return c
+}
+
+// WithRoadCollapseChance sets the probability (between 0 and 1) of each road
+// collapsing during any given iteration, killing any aliens on it at the time.
+// By default, roads never collapse.
+func (c *SimulationConfig) WithRoadCollapseChance(chance float64) *SimulationConfig {
+	c.roadCollapse = chance
+	return c
+}
+
+// WithDefenderKillChance sets the probability (between 0 and 1) of a city with
+// defences left killing a lone alien in the city during any given iteration.
+// By default, defenders never kill lone aliens.
+func (c *SimulationConfig) WithDefenderKillChance(chance float64) *SimulationConfig {
+	c.defenderKill = chance
+	return c
+}
+
+// WithRebuilding has destroyed cities rebuilt the given number of iterations
+// after they were destroyed. If requireNeighbour is true, a city will only be
+// rebuilt once at least one of its neighbours is standing. By default, cities
+// are never rebuilt.
+func (c *SimulationConfig) WithRebuilding(after int, requireNeighbour bool) *SimulationConfig {
+	c.rebuildAfter = after
+	c.rebuildNearby = requireNeighbour
+	return c
+}
+
+// WithSpawnCities restricts the cities in which aliens can spawn to the given
+// ones, overriding any cities marked as spawn points in the map.
+func (c *SimulationConfig) WithSpawnCities(cityNames []string) *SimulationConfig {
+	c.spawnCities = cityNames
+	return c
+}
+
+// WithSpawnPattern determines how aliens are distributed across the cities in
+// which they can spawn. By default, aliens are placed uniformly at random.
+func (c *SimulationConfig) WithSpawnPattern(pattern SpawnPattern) *SimulationConfig {
+	c.spawnPattern = pattern
+	return c
+}
+
+// WithPlacement determines how aliens are placed in the cities in which they can
+// spawn. By default, aliens are placed using UniformPlacement.
+func (c *SimulationConfig) WithPlacement(placement PlacementStrategy) *SimulationConfig {
+	c.placement = placement
+	return c
+}
+
+// WithReinforcements schedules waves of aliens to arrive during the
+// simulation, in addition to the aliens placed at the start.
+func (c *SimulationConfig) WithReinforcements(waves ...AlienWave) *SimulationConfig {
+	c.reinforcements = append(c.reinforcements, waves...)
+	return c
+}
+
+// WithEnergy gives each alien enough energy to make the given number of moves,
+// where each iteration spent travelling along a road counts as a single move.
+// Aliens that run out of energy can no longer move. Aliens regain the given
+// amount of energy (up to the budget) for every iteration they spend in an
+// intact city. By default, aliens have enough energy for 10,000 moves and never
+// recharge.
+func (c *SimulationConfig) WithEnergy(budget, rechargeRate int) *SimulationConfig {
+	c.energyBudget = budget
+	c.rechargeRate = rechargeRate
+	return c
+}
+
+// WithStopCriteria has the simulation stop as soon as any of the given criteria
+// are met (checked in the given order), in addition to when none of the aliens
+// can move. By default, simulations only stop when none of the aliens can move,
+// or after SimulationIterationsHardLimit iterations.
+func (c *SimulationConfig) WithStopCriteria(criteria ...StopCriterion) *SimulationConfig {
+	c.stopCriteria = append(c.stopCriteria, criteria...)
+	return c
+}
+
+// WithReproduction gives each living alien in an intact city the given chance
+// (between 0 and 1) of giving birth to an offspring during any iteration, once
+// it has ended the given number of iterations in intact cities. Reproduction stops
+// whenever there are populationCap living aliens (if populationCap > 0). By
+// default, aliens never reproduce.
+func (c *SimulationConfig) WithReproduction(after int, chance float64, populationCap int) *SimulationConfig {
+	c.reproduceAfter = after
+	c.reproduceChance = chance
+	c.populationCap = populationCap
+	return c
+}
+
+// WithPerception limits how much aliens know about their surroundings. Aliens
+// can only see cities up to the given number of roads away, and may misjudge
+// (with the given chance) whether a city they can see has been destroyed. A
+// radius of 0 leaves aliens blind, so that they believe all of their
+// neighbouring cities are standing, while a negative radius is rejected when
+// the simulation starts. By default, aliens always know which of their
+// neighbouring cities have been destroyed.
+func (c *SimulationConfig) WithPerception(radius int, misjudgeChance float64) *SimulationConfig {
+	c.perception = &Perception{Radius: radius, MisjudgeChance: misjudgeChance}
+	return c
+}
+
+// WithRandomGenerator has the simulation draw its random numbers from the given
+// generator (e.g. one created by NewSeededGenerator, to make the simulation
+// reproducible). By default, a generator seeded with the current time is used.
+func (c *SimulationConfig) WithRandomGenerator(rnd RandomGenerator) *SimulationConfig {
+	c.rnd = rnd
+	return c
+}
+
+// WithMapParser has the world map parsed by the given parser, e.g. to accept
+// longer lines or report how far parsing a large map has got. By default, the
+// map is parsed as by ParseWorldMap.
+func (c *SimulationConfig) WithMapParser(parser *MapParser) *SimulationConfig {
+	c.mapParser = parser
+	return c
+}
+
+// WithParallelism has the given number of goroutines move the aliens during
+// each iteration, which speeds up simulations with many aliens. Aliens are
+// always moved in blocks, each of which draws its random numbers from its own
+// stream, so that the result is the same for any number of goroutines as when
+// aliens are moved one at a time. The species' movement strategies must be
+// safe for concurrent use. By default, aliens are moved one at a time.
+func (c *SimulationConfig) WithParallelism(goroutines int) *SimulationConfig {
+	c.parallelism = goroutines
+	return c
+}
+
+// WithProgressHandler has the given handler notified of events as they happen
+// during the simulation. By default, events are printed to Stdout.
+func (c *SimulationConfig) WithProgressHandler(handler SimulationProgressHandler) *SimulationConfig {
+	c.progressHandler = handler
+	return c
+}
+
+// WithInstrumentation has the simulator notify the given instrumentation of
+// what it's doing and how long it takes. By default, nothing is notified.
+func (c *SimulationConfig) WithInstrumentation(instrumentation Instrumentation) *SimulationConfig {
+	c.instrumentation = instrumentation
+	return c
+}
+
+// WithTrajectories has the simulation record the path taken by each alien, so
+// that it can be reported along with how each alien fared. By default, only
+// where each alien ended up is reported.
+func (c *SimulationConfig) WithTrajectories(enabled bool) *SimulationConfig {
+	c.trajectories = enabled
+	return c
+}
+
+// WithSpecies has the aliens taking part in the invasion belong to the given
+// species, which take turns in proportion to their weights as aliens arrive. By
+// default, all aliens belong to a single species with the traits of the aliens
+// from the original problem statement.
+func (c *SimulationConfig) WithSpecies(species ...*Species) *SimulationConfig {
+	c.species = species
+	return c
+}
+
 // NewSimulation constructs a new simulation from the given configuration.
 func NewSimulation(config *SimulationConfig) *Simulation {
 	return &Simulation{
 		config:          config,
 		worldMap:        nil,
 		aliens:          []*Alien{},
-		citiesDestroyed: mapset.NewSet(),
+		ruins:           []*City{},
+		rebuilds:        []CityRebuild{},
+		speciesStats:    map[string]*SpeciesStats{},
+		eliminated:      map[string]bool{},
+		population:      []int{},
+		lastDestruction: -1,
 	}
 }
 
 // Simulate is our primary simulation routine which takes simulation
 // configuration, parses it, simulates the invasion, and returns a simulation
-// result or an error.
+// result, along with any error (see SimulateContext).
 func (s *Simulation) Simulate() (*SimulationResult, error) {
+	return s.SimulateContext(context.Background())
+}
+
+// SimulateContext runs the simulation like Simulate does, but checks the given
+// context between iterations. If the context is cancelled (or times out)
+// before the simulation is over, the simulation stops there and its partial
+// result is returned, marked as cancelled. If an iteration can't be simulated,
+// the partial result of the iterations before it is returned along with the
+// error.
+func (s *Simulation) SimulateContext(ctx context.Context) (*SimulationResult, error) {
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			s.Cancel()
+			return s.Finish(), nil
+		default:
+		}
+		done, err := s.Step()
+		if err != nil {
+			return s.Finish(), err
+		}
+		if done {
+			return s.Finish(), nil
+		}
+	}
+}
+
+// Cancel stops a simulation being run one iteration at a time before it's over,
+// just like cancelling the context given to SimulateContext does. Finish then
+// gives the partial result, marked as cancelled.
+func (s *Simulation) Cancel() {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.cancelled = true
+	s.stopReason, s.stopKind = StopReasonCancelled, StopKindCancelled
+}
+
+// Start parses the world map and places the aliens on it, so that the
+// simulation can be run one iteration at a time using Step.
+func (s *Simulation) Start() error {
+	if s.config.instrumentation != nil {
+		s.config.instrumentation.SimulationStarted()
+	}
+	if err := s.start(); err != nil {
+		s.failed(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Simulation) start() error {
 	if s.config.aliens < 2 {
-		return nil, NewSimulationError(ErrTooFewAliens)
+		return NewSimulationError(ErrTooFewAliens)
+	}
+	if err := s.validateSpecies(); err != nil {
+		return err
+	}
+	if err := s.validatePerception(); err != nil {
+		return err
+	}
+	parseStarted := time.Now()
+	mapParser := s.config.mapParser
+	if mapParser == nil {
+		mapParser = NewMapParser()
+	}
+	worldMap, err := mapParser.Parse(s.config.worldReader)
+	if s.config.instrumentation != nil {
+		s.config.instrumentation.WorldMapParsed(time.Since(parseStarted), err)
 	}
-	worldMap, err := ParseWorldMap(s.config.worldReader)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// keep track of it
 	s.worldMap = worldMap
+	s.spawnPoints, err = s.spawnCities()
+	if err != nil {
+		return err
+	}
 	// randomly place our aliens on the map
-	s.scatterAliens()
+	if _, err := s.spawnAliens(s.config.aliens); err != nil {
+		return err
+	}
+	s.started = time.Now()
+	return nil
+}
 
-	alienMoves := 0
-	iter := 0
-	aliensPossiblyTrapped := false
-	for ; alienMoves < s.config.maxAlienMoves && iter < SimulationIterationsHardLimit; iter++ {
-		m, destroyed := s.RunSimulationIteration()
-		for cityName := range destroyed {
-			s.citiesDestroyed.Add(cityName)
-		}
-		// if all aliens are trapped (or possibly dead - we'll check further on)
-		if m == 0 {
-			aliensPossiblyTrapped = true
-			break
-		}
-		alienMoves += m
+// failed notifies the instrumentation (if any) that the simulation couldn't be
+// run to completion.
+func (s *Simulation) failed(err error) {
+	if s.config.instrumentation != nil {
+		s.config.instrumentation.SimulationFailed(err)
+	}
+}
+
+// Step simulates a single iteration of a simulation that has been started.
+// Returns true once the simulation is over, after which Finish gives its
+// result.
+func (s *Simulation) Step() (bool, error) {
+	if s.finished {
+		return true, nil
+	}
+	iter := s.iterationsSimulated
+	if iter >= SimulationIterationsHardLimit {
+		s.finished = true
+		s.stopReason, s.stopKind = StopReasonHardLimit, StopKindHardLimit
+		return true, nil
+	}
+	s.iteration = iter
+	if err := s.spawnReinforcements(); err != nil {
+		s.finished = true
+		s.stopReason, s.stopKind = StopReasonFailed, StopKindFailed
+		s.failure = err
+		s.failed(err)
+		return false, err
+	}
+	m, destroyed := s.RunSimulationIteration()
+	if len(destroyed) > 0 {
+		s.lastDestruction = iter
+	}
+	s.moves = m
+	s.totalMoves += m
+	rebuilt := s.rebuildCities()
+	born := s.reproduce()
+	recharged := s.rechargeAliens()
+	s.checkSpeciesEliminated()
+	s.population = append(s.population, s.livingAliens())
+	// report every iteration, even one in which none of the aliens could
+	// move, since cities may still have been destroyed during it
+	if handler, ok := s.config.progressHandler.(IterationHandler); ok {
+		handler.IterationCompleted(s.Status())
+	}
+	// if all aliens are trapped or exhausted (or possibly dead - we'll
+	// check further on), unless reinforcements are on their way, new
+	// aliens have just been born, aliens have regained energy, aliens only
+	// stayed put because they misjudged their surroundings, or rebuilding
+	// cities could still free them
+	if m == 0 && born == 0 && !recharged && !s.reinforcementsPending() && !s.aliensMayBeMisled() &&
+		(!s.anyAliensAlive() || !(rebuilt || s.rebuildsPending())) {
+		s.aliensStuck = true
+		s.finished = true
+		s.stopReason, s.stopKind = StopReasonAliensStuck, StopKindAliensStuck
+		return true, nil
+	}
+	// this iteration counts as having been simulated in full
+	s.iterationsSimulated++
+	if criterion := s.checkStopCriteria(); criterion != nil {
+		s.finished = true
+		s.stopReason, s.stopKind = criterion.Reason(), stopKind(criterion)
+		return true, nil
+	}
+	if s.iterationsSimulated >= SimulationIterationsHardLimit {
+		s.finished = true
+		s.stopReason, s.stopKind = StopReasonHardLimit, StopKindHardLimit
+		return true, nil
+	}
+	return false, nil
+}
+
+// Finish ends a simulation that has been started, even if it isn't over yet,
+// and returns its result. Calling Finish again returns the same result.
+func (s *Simulation) Finish() *SimulationResult {
+	if s.result != nil {
+		return s.result
+	}
+	if !s.finished {
+		s.finished = true
+		s.stopReason, s.stopKind = StopReasonStopped, StopKindStopped
 	}
 
 	// count how many aliens are still alive and build up a list of the living
 	// ones
-	aliensStillAlive := 0
+	aliensStillAlive, aliensExhausted, aliensTrapped := 0, 0, 0
 	livingAliens := []*Alien{}
+	alienDistances := map[int]int{}
+	alienSpawnTimes := map[int]int{}
+	alienParents := map[int]int{}
+	alienVisitedCities := map[int]int{}
+	var trajectories []Trajectory
 	for _, alien := range s.aliens {
+		if s.config.trajectories {
+			trajectories = append(trajectories, alien.Trajectory())
+		}
+		alienDistances[alien.id] = alien.distance
+		alienSpawnTimes[alien.id] = alien.spawnedAt
+		alienVisitedCities[alien.id] = alien.VisitedCities()
+		if alien.parent >= 0 {
+			alienParents[alien.id] = alien.parent
+		}
+		stats := s.speciesStatsFor(alien.species)
+		stats.Distance += alien.distance
 		if alien.alive {
 			aliensStillAlive++
 			livingAliens = append(livingAliens, alien)
+			stats.StillAlive++
+			if alien.Exhausted() {
+				aliensExhausted++
+			} else if alien.trapped() {
+				aliensTrapped++
+			}
 		}
 	}
+	speciesStats := map[string]SpeciesStats{}
+	for name, stats := range s.speciesStats {
+		speciesStats[name] = *stats
+	}
+	if s.aliensStuck && aliensStillAlive == 0 {
+		s.stopReason, s.stopKind = StopReasonAllAliensDead, StopKindAllAliensDead
+	}
 	if aliensStillAlive == 0 {
 		if s.config.progressHandler != nil {
 			s.config.progressHandler.AllAliensDead()
 		}
-	} else if aliensPossiblyTrapped {
+	} else if s.aliensStuck && aliensExhausted == aliensStillAlive {
+		if handler, ok := s.config.progressHandler.(EnergyEventHandler); ok {
+			handler.AllAliensExhausted()
+		}
+	} else if s.aliensStuck && aliensTrapped == aliensStillAlive {
 		if s.config.progressHandler != nil {
 			s.config.progressHandler.AllAliensTrapped()
 		}
 	}
 
-	// compute which cities are still left standing
+	// compute which cities are still left standing, and which of those have
+	// held out against the aliens
 	citiesRemaining := []string{}
-	for _, cityName := range s.worldMap.cityNames {
-		if !s.citiesDestroyed.Contains(cityName) {
-			citiesRemaining = append(citiesRemaining, cityName)
+	citiesHeldOut := map[string]int{}
+	for _, city := range s.worldMap.cities {
+		if !city.Destroyed() {
+			citiesRemaining = append(citiesRemaining, city.name)
+		}
+		if city.state == CityUnderSiege {
+			citiesHeldOut[city.name] = city.defence
 		}
 	}
 
-	return &SimulationResult{
-		IterationsSimulated: iter,
+	s.result = &SimulationResult{
+		IterationsSimulated: s.iterationsSimulated,
 		AliensStillAlive:    aliensStillAlive,
 		CitiesRemaining:     citiesRemaining,
-		FinalMap:            worldMap,
+		FinalMap:            s.worldMap,
 		FinalAliens:         livingAliens,
-	}, nil
+		AlienDistances:      alienDistances,
+		RoadsDestroyed:      s.roadsDestroyed,
+		CitiesHeldOut:       citiesHeldOut,
+		Rebuilds:            s.rebuilds,
+		AlienSpawnTimes:     alienSpawnTimes,
+		SpeciesStats:        speciesStats,
+		AlienParents:        alienParents,
+		Population:          s.population,
+		AlienVisitedCities:  alienVisitedCities,
+		AliensExhausted:     aliensExhausted,
+		AliensTrapped:       aliensTrapped,
+		AliensDead:          len(s.aliens) - aliensStillAlive,
+		TotalMoves:          s.totalMoves,
+		StopReason:          s.stopReason,
+		StopKind:            s.stopKind,
+		Cancelled:           s.cancelled,
+		Trajectories:        trajectories,
+	}
+	// a simulation that failed has already been reported as such
+	if s.config.instrumentation != nil && s.failure == nil {
+		s.config.instrumentation.SimulationFinished(s.result, time.Since(s.started))
+	}
+	return s.result
+}
+
+// WorldMap returns the world map on which the simulation takes place, once the
+// simulation has been started.
+func (s *Simulation) WorldMap() *WorldMap {
+	return s.worldMap
+}
+
+// Aliens returns all of the aliens that have taken part in the simulation so
+// far, living or dead, in order of their IDs.
+func (s *Simulation) Aliens() []*Alien {
+	return s.aliens
 }
 
-func (s *Simulation) scatterAliens() {
-	cityCount := len(s.worldMap.cityNames)
-	for n := 0; n < s.config.aliens; n++ {
-		cityID := s.config.rnd.Uint32() % uint32(cityCount)
-		s.aliens = append(
-			s.aliens,
-			NewAlien(n, s.worldMap.cities[s.worldMap.cityNames[cityID]]),
-		)
+// anyAliensAlive checks whether there are still any living aliens.
+func (s *Simulation) anyAliensAlive() bool {
+	for _, alien := range s.aliens {
+		if alien.alive {
+			return true
+		}
 	}
+	return false
 }
 
 // RunSimulationIteration runs a single iteration of our simulation, returning
-// the maximum number of moves that any single alien made during this iteration.
+// the total number of moves made by all of the aliens during this iteration.
 // The second return parameter is a mapping of city names to a list of aliens
 // responsible for each city's destruction.
 func (s *Simulation) RunSimulationIteration() (int, map[string][]int) {
 	destroyed := map[string][]int{}
-	aliensInCities := map[string][]int{}
-	alienMoves := 0
+	if s.occupants == nil {
+		s.occupants = make([][]int, len(s.worldMap.cities))
+		s.travellers = make([][]roadTraveller, len(s.worldMap.roads))
+	}
+	// the cities and roads with aliens in them, in the order in which the
+	// first alien in each was found
+	cities := []*City{}
+	roads := []*Road{}
+	s.collisions = 0
 	for alienID, alien := range s.aliens {
 		if alien.alive {
-			cityName := alien.city.name
-			aliensInCities[cityName] = append(aliensInCities[cityName], alienID)
+			if alien.state == AlienOnRoad {
+				id := alien.road.id
+				if len(s.travellers[id]) == 0 {
+					roads = append(roads, alien.road)
+				}
+				s.travellers[id] = append(
+					s.travellers[id],
+					roadTraveller{
+						alienID:  alienID,
+						forward:  alien.headingFromStart(),
+						position: alien.positionOnRoad(),
+					},
+				)
+			} else {
+				id := alien.city.id
+				if len(s.occupants[id]) == 0 {
+					cities = append(cities, alien.city)
+				}
+				s.occupants[id] = append(s.occupants[id], alienID)
+			}
+		}
+	}
+	// now move the aliens, as many times as their species and their energy
+	// allow
+	alienMoves := s.moveAliens()
+
+	// keeps track of which aliens are left on each road after moving, in case
+	// we need to destroy any roads
+	var stranded map[*Road][]int
+	destroyRoad := func(road *Road, alienIDs []int) {
+		if stranded == nil {
+			stranded = s.aliensOnRoads()
+		}
+		for _, id := range stranded[road] {
+			if !containsInt(alienIDs, id) {
+				alienIDs = append(alienIDs, id)
+			}
+		}
+		s.destroyRoad(road, alienIDs)
+	}
 
-			// now move this alien
-			couldMove := alien.MoveInRandomDirection(s.config.rnd)
-			if couldMove {
-				alienMoves = 1
+	if s.config.roadEncounters {
+		// aliens that met head-on destroy each other and the road, even if
+		// they've since made it to the end of the road
+		for _, road := range roads {
+			alienIDs := []int{}
+			for i, t := range s.travellers[road.id] {
+				alienIDs = append(alienIDs, t.alienID)
+				s.travellers[road.id][i].end = s.aliens[t.alienID].endOnRoad(road, t.forward)
+			}
+			if s.haveMetHeadOn(s.travellers[road.id]) && s.willFight(alienIDs) {
+				s.collisions++
+				destroyRoad(road, alienIDs)
 			}
 		}
 	}
 
-	for cityName, alienIDs := range aliensInCities {
+	for _, city := range cities {
+		if city.Destroyed() {
+			// aliens that can't see (or misjudge) what's left of a city, or
+			// that were already on their way there, can still end up in its
+			// ruins, where there's nothing left to destroy
+			continue
+		}
+		alienIDs := s.occupants[city.id]
+		if len(alienIDs) > 1 {
+			// these are handed on to the progress handler, which may hold on
+			// to them
+			alienIDs = append([]int(nil), alienIDs...)
+		}
+		if len(alienIDs) > 1 && !s.willFight(alienIDs) {
+			// aliens of a species that doesn't fight its own kind share the
+			// city peacefully
+			continue
+		}
+		if city.defence > 0 {
+			s.defendCity(city, alienIDs)
+			continue
+		}
 		// Have two or more aliens ended up in a particular city? If so, they'll
-		// destroy each other and the city.
+		// destroy each other and the city, unless one of them is stronger than
+		// all of the others.
 		if len(alienIDs) > 1 {
-			destroyed[cityName] = alienIDs
+			s.collisions++
+			destroyed[city.name] = alienIDs
+			survivor := s.strongest(alienIDs)
+			involved := map[*Species]bool{}
 			for _, id := range alienIDs {
-				s.aliens[id].alive = false
+				if sp := s.aliens[id].species; !involved[sp] {
+					involved[sp] = true
+					s.speciesStatsFor(sp).CitiesDestroyed++
+				}
+				if id != survivor {
+					s.kill(id, CauseCityDestroyed)
+				}
+			}
+			s.citiesDestroyed++
+			city.destroyedAt = s.iteration
+			if s.config.rebuildAfter > 0 {
+				s.ruins = append(s.ruins, city)
+			}
+			city.state = CityDestroyed
+			if s.components != nil {
+				s.components.cityDestroyed(city)
 			}
-			s.worldMap.cities[cityName].destroyed = true
 			if s.config.progressHandler != nil {
-				s.config.progressHandler.CityDestroyed(cityName, alienIDs)
+				s.config.progressHandler.CityDestroyed(city.name, alienIDs)
+			}
+			if survivor >= 0 {
+				s.speciesStatsFor(s.aliens[survivor].species).FightsWon++
+				if handler, ok := s.config.progressHandler.(SpeciesEventHandler); ok {
+					handler.FightWon(city.name, survivor, s.aliens[survivor].species.Name)
+				}
 			}
 		}
 	}
 
+	if s.config.roadCollapse > 0 {
+		for _, road := range s.worldMap.roads {
+			if !road.destroyed && randomChance(s.config.rnd, s.config.roadCollapse) {
+				destroyRoad(road, []int{})
+			}
+		}
+	}
+
+	s.clearScratch(cities, roads)
 	return alienMoves, destroyed
 }
 
-func (h *NoopSimulationProgressHandler) CityDestroyed(cityName string, alienIDs []int) {}
-func (h *NoopSimulationProgressHandler) AllAliensTrapped()                             {}
-func (h *NoopSimulationProgressHandler) AllAliensDead()                                {}
+// clearScratch empties the scratch space used for the given cities and roads
+// during an iteration, keeping the space allocated for the next iteration.
+func (s *Simulation) clearScratch(cities []*City, roads []*Road) {
+	for _, city := range cities {
+		s.occupants[city.id] = s.occupants[city.id][:0]
+	}
+	for _, road := range roads {
+		s.travellers[road.id] = s.travellers[road.id][:0]
+	}
+}
+
+// defendCity has the defenders of the given city repel an attack by the given
+// aliens, if there is more than one of them, weakening the city's defences by
+// the strength of the strongest attacker in the process. A lone alien may be
+// killed by the defenders instead.
+func (s *Simulation) defendCity(city *City, alienIDs []int) {
+	if len(alienIDs) > 1 {
+		strength := 0
+		for _, id := range alienIDs {
+			if s.aliens[id].species.Strength > strength {
+				strength = s.aliens[id].species.Strength
+			}
+		}
+		city.defence -= strength
+		if city.defence < 0 {
+			city.defence = 0
+		}
+		city.state = CityUnderSiege
+		if handler, ok := s.config.progressHandler.(DefenceEventHandler); ok {
+			handler.AttackRepelled(city.name, alienIDs, city.defence)
+		}
+		return
+	}
+	if randomChance(s.config.rnd, s.config.defenderKill) {
+		s.kill(alienIDs[0], CauseDefenders)
+		if handler, ok := s.config.progressHandler.(DefenceEventHandler); ok {
+			handler.AlienKilledByDefenders(city.name, alienIDs[0])
+		}
+	}
+}
+
+// aliensOnRoads builds up a mapping of roads to the IDs of the living aliens
+// currently travelling along them.
+func (s *Simulation) aliensOnRoads() map[*Road][]int {
+	onRoads := map[*Road][]int{}
+	for alienID, alien := range s.aliens {
+		if alien.alive && alien.state == AlienOnRoad {
+			onRoads[alien.road] = append(onRoads[alien.road], alienID)
+		}
+	}
+	return onRoads
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// destroyRoad destroys the given road, along with any of the aliens (given by
+// their IDs) still on it.
+func (s *Simulation) destroyRoad(road *Road, alienIDs []int) {
+	road.destroyed = true
+	s.roadsDestroyed++
+	if s.components != nil {
+		s.components.roadDestroyed(road)
+	}
+	for _, id := range alienIDs {
+		s.kill(id, CauseRoadDestroyed)
+	}
+	if handler, ok := s.config.progressHandler.(RoadEventHandler); ok {
+		handler.RoadDestroyed(road.from.name, road.to.name, alienIDs)
+	}
+}
+
+// haveMetHeadOn checks whether any of the given aliens travelling along the
+// same road have run into each other head-on during this iteration, i.e.
+// whether an alien heading one way has reached or passed one heading the other
+// way that it hadn't yet passed at the start of the iteration.
+func (s *Simulation) haveMetHeadOn(travellers []roadTraveller) bool {
+	for _, t := range travellers {
+		for _, other := range travellers {
+			if t.forward && !other.forward && t.position <= other.position && t.end >= other.end {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *NoopSimulationProgressHandler) CityDestroyed(cityName string, alienIDs []int)         {}
+func (h *NoopSimulationProgressHandler) RoadDestroyed(fromCity, toCity string, alienIDs []int) {}
+func (h *NoopSimulationProgressHandler) AttackRepelled(cityName string, alienIDs []int, defenceLeft int) {
+}
+func (h *NoopSimulationProgressHandler) AlienKilledByDefenders(cityName string, alienID int) {}
+func (h *NoopSimulationProgressHandler) CityRebuilt(cityName string)                         {}
+func (h *NoopSimulationProgressHandler) ReinforcementsArrived(alienIDs []int)                {}
+func (h *NoopSimulationProgressHandler) FightWon(cityName string, alienID int, speciesName string) {
+}
+func (h *NoopSimulationProgressHandler) AlienBorn(alienID, parentID int, cityName string) {}
+func (h *NoopSimulationProgressHandler) SpeciesEliminated(speciesName string)             {}
+func (h *NoopSimulationProgressHandler) IterationCompleted(status SimulationStatus)       {}
+func (h *NoopSimulationProgressHandler) AllAliensTrapped()                                {}
+func (h *NoopSimulationProgressHandler) AllAliensExhausted()                              {}
+func (h *NoopSimulationProgressHandler) AllAliensDead()                                   {}
 
 // CityDestroyed prints out the fact that a city has been destroyed to Stdout.
 func (h *StdoutSimulationProgressHandler) CityDestroyed(cityName string, alienIDs []int) {
@@ -217,10 +947,189 @@ func (h *StdoutSimulationProgressHandler) CityDestroyed(cityName string, alienID
 	)
 }
 
+// RoadDestroyed prints out the fact that the road between two cities has been
+// destroyed to Stdout.
+func (h *StdoutSimulationProgressHandler) RoadDestroyed(fromCity, toCity string, alienIDs []int) {
+	if len(alienIDs) == 0 {
+		fmt.Println(fmt.Sprintf("The road between %s and %s has collapsed!", fromCity, toCity))
+		return
+	}
+	idStrings := []string{}
+	for _, id := range alienIDs {
+		idStrings = append(idStrings, fmt.Sprintf("alien %d", id))
+	}
+	fmt.Println(
+		fmt.Sprintf(
+			"The road between %s and %s has been destroyed, along with %s!",
+			fromCity,
+			toCity,
+			strings.Join(idStrings, " and "),
+		),
+	)
+}
+
+// AttackRepelled prints out the fact that a city's defenders have repelled an
+// alien attack to Stdout.
+func (h *StdoutSimulationProgressHandler) AttackRepelled(cityName string, alienIDs []int, defenceLeft int) {
+	idStrings := []string{}
+	for _, id := range alienIDs {
+		idStrings = append(idStrings, fmt.Sprintf("alien %d", id))
+	}
+	fmt.Println(
+		fmt.Sprintf(
+			"%s has repelled an attack by %s (defence left: %d)!",
+			cityName,
+			strings.Join(idStrings, " and "),
+			defenceLeft,
+		),
+	)
+}
+
+// AlienKilledByDefenders prints out the fact that a lone alien has been killed
+// by a city's defenders to Stdout.
+func (h *StdoutSimulationProgressHandler) AlienKilledByDefenders(cityName string, alienID int) {
+	fmt.Println(fmt.Sprintf("Alien %d has been killed by the defenders of %s!", alienID, cityName))
+}
+
+// CityRebuilt prints out the fact that a destroyed city has been rebuilt to
+// Stdout.
+func (h *StdoutSimulationProgressHandler) CityRebuilt(cityName string) {
+	fmt.Println(fmt.Sprintf("%s has been rebuilt!", cityName))
+}
+
+// ReinforcementsArrived prints out the fact that more aliens have arrived to
+// Stdout.
+func (h *StdoutSimulationProgressHandler) ReinforcementsArrived(alienIDs []int) {
+	fmt.Println(fmt.Sprintf("%d more aliens have arrived!", len(alienIDs)))
+}
+
+// FightWon prints out the fact that an alien has survived a fight to Stdout.
+func (h *StdoutSimulationProgressHandler) FightWon(cityName string, alienID int, speciesName string) {
+	fmt.Println(fmt.Sprintf("Alien %d (%s) has survived the fight in %s!", alienID, speciesName, cityName))
+}
+
+// AlienBorn prints out the fact that an alien has given birth to another to
+// Stdout.
+func (h *StdoutSimulationProgressHandler) AlienBorn(alienID, parentID int, cityName string) {
+	fmt.Println(fmt.Sprintf("Alien %d has given birth to alien %d in %s!", parentID, alienID, cityName))
+}
+
+// SpeciesEliminated prints out the fact that the last alien of a species has
+// been killed to Stdout.
+func (h *StdoutSimulationProgressHandler) SpeciesEliminated(speciesName string) {
+	fmt.Println(fmt.Sprintf("The last of the %s aliens has been killed!", speciesName))
+}
+
+// IterationCompleted doesn't print anything, since doing so for every iteration
+// would drown out the other events.
+func (h *StdoutSimulationProgressHandler) IterationCompleted(status SimulationStatus) {}
+
 func (h *StdoutSimulationProgressHandler) AllAliensTrapped() {
 	fmt.Println("All aliens have been trapped! Simulation will be ended here.")
 }
 
+func (h *StdoutSimulationProgressHandler) AllAliensExhausted() {
+	fmt.Println("All aliens have run out of energy! Simulation will be ended here.")
+}
+
 func (h *StdoutSimulationProgressHandler) AllAliensDead() {
 	fmt.Println("All aliens are dead!")
 }
+
+func (h MultiSimulationProgressHandler) CityDestroyed(cityName string, alienIDs []int) {
+	for _, handler := range h {
+		handler.CityDestroyed(cityName, alienIDs)
+	}
+}
+
+func (h MultiSimulationProgressHandler) RoadDestroyed(fromCity, toCity string, alienIDs []int) {
+	for _, handler := range h {
+		if handler, ok := handler.(RoadEventHandler); ok {
+			handler.RoadDestroyed(fromCity, toCity, alienIDs)
+		}
+	}
+}
+
+func (h MultiSimulationProgressHandler) AttackRepelled(cityName string, alienIDs []int, defenceLeft int) {
+	for _, handler := range h {
+		if handler, ok := handler.(DefenceEventHandler); ok {
+			handler.AttackRepelled(cityName, alienIDs, defenceLeft)
+		}
+	}
+}
+
+func (h MultiSimulationProgressHandler) AlienKilledByDefenders(cityName string, alienID int) {
+	for _, handler := range h {
+		if handler, ok := handler.(DefenceEventHandler); ok {
+			handler.AlienKilledByDefenders(cityName, alienID)
+		}
+	}
+}
+
+func (h MultiSimulationProgressHandler) CityRebuilt(cityName string) {
+	for _, handler := range h {
+		if handler, ok := handler.(RebuildEventHandler); ok {
+			handler.CityRebuilt(cityName)
+		}
+	}
+}
+
+func (h MultiSimulationProgressHandler) ReinforcementsArrived(alienIDs []int) {
+	for _, handler := range h {
+		if handler, ok := handler.(ReinforcementEventHandler); ok {
+			handler.ReinforcementsArrived(alienIDs)
+		}
+	}
+}
+
+func (h MultiSimulationProgressHandler) FightWon(cityName string, alienID int, speciesName string) {
+	for _, handler := range h {
+		if handler, ok := handler.(SpeciesEventHandler); ok {
+			handler.FightWon(cityName, alienID, speciesName)
+		}
+	}
+}
+
+func (h MultiSimulationProgressHandler) AlienBorn(alienID, parentID int, cityName string) {
+	for _, handler := range h {
+		if handler, ok := handler.(ReproductionEventHandler); ok {
+			handler.AlienBorn(alienID, parentID, cityName)
+		}
+	}
+}
+
+func (h MultiSimulationProgressHandler) SpeciesEliminated(speciesName string) {
+	for _, handler := range h {
+		if handler, ok := handler.(SpeciesEventHandler); ok {
+			handler.SpeciesEliminated(speciesName)
+		}
+	}
+}
+
+func (h MultiSimulationProgressHandler) IterationCompleted(status SimulationStatus) {
+	for _, handler := range h {
+		if handler, ok := handler.(IterationHandler); ok {
+			handler.IterationCompleted(status)
+		}
+	}
+}
+
+func (h MultiSimulationProgressHandler) AllAliensTrapped() {
+	for _, handler := range h {
+		handler.AllAliensTrapped()
+	}
+}
+
+func (h MultiSimulationProgressHandler) AllAliensExhausted() {
+	for _, handler := range h {
+		if handler, ok := handler.(EnergyEventHandler); ok {
+			handler.AllAliensExhausted()
+		}
+	}
+}
+
+func (h MultiSimulationProgressHandler) AllAliensDead() {
+	for _, handler := range h {
+		handler.AllAliensDead()
+	}
+}
@@ -1,6 +1,7 @@
 package aliensim
 
 import (
+	"context"
 	"io"
 	"strings"
 	"testing"
@@ -23,7 +24,7 @@ func newTestSimulationConfig(worldReader io.Reader, aliens int) *SimulationConfi
 		worldReader:     worldReader,
 		aliens:          aliens,
 		rnd:             NewSequenceGenerator(),
-		maxAlienMoves:   10,
+		energyBudget:    10,
 		progressHandler: nil, // no need to print out progress during testing
 	}
 }
@@ -120,3 +121,320 @@ func TestControlledSimulationOfExampleMap(t *testing.T) {
 		}
 	}
 }
+
+// Two aliens starting at opposite ends of a long road will either pass each
+// other or meet head-on, depending on whether road encounters are enabled. On a
+// road of odd length, they meet while crossing each other mid-iteration, rather
+// than in the same spot.
+func TestSimulationWithWeightedRoads(t *testing.T) {
+	tests := []struct {
+		worldMap            string
+		roadEncounters      bool
+		iterationsSimulated int
+		aliensStillAlive    int
+		distance            int
+		roadsDestroyed      int
+	}{
+		{"Foo east=Bar:3\n", false, 10, 2, 10, 0},
+		{"Foo east=Bar:3\n", true, 2, 0, 2, 1},
+		{"Foo east=Bar:4\n", false, 10, 2, 10, 0},
+		{"Foo east=Bar:4\n", true, 2, 0, 2, 1},
+		{"Foo east=Bar:5\n", true, 3, 0, 3, 1},
+	}
+	for _, test := range tests {
+		res, err := NewSimulation(
+			newTestSimulationConfig(
+				strings.NewReader(test.worldMap),
+				2,
+			).WithRoadEncounters(test.roadEncounters),
+		).Simulate()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if res.IterationsSimulated != test.iterationsSimulated {
+			t.Error(
+				"For", test.worldMap, "with roadEncounters =", test.roadEncounters,
+				"expected iterationsSimulated =", test.iterationsSimulated,
+				"but got", res.IterationsSimulated,
+			)
+		}
+		if res.AliensStillAlive != test.aliensStillAlive {
+			t.Error(
+				"For", test.worldMap, "with roadEncounters =", test.roadEncounters,
+				"expected aliensStillAlive =", test.aliensStillAlive,
+				"but got", res.AliensStillAlive,
+			)
+		}
+		if res.RoadsDestroyed != test.roadsDestroyed {
+			t.Error(
+				"For", test.worldMap, "with roadEncounters =", test.roadEncounters,
+				"expected roadsDestroyed =", test.roadsDestroyed,
+				"but got", res.RoadsDestroyed,
+			)
+		}
+		for id, distance := range res.AlienDistances {
+			if distance != test.distance {
+				t.Error(
+					"For", test.worldMap, "with roadEncounters =", test.roadEncounters,
+					"expected alien", id, "to have travelled", test.distance,
+					"but got", distance,
+				)
+			}
+		}
+	}
+}
+
+// If roads are guaranteed to collapse, the aliens will be trapped after their
+// first move.
+func TestSimulationWithCollapsingRoads(t *testing.T) {
+	res, err := NewSimulation(
+		newTestSimulationConfig(
+			strings.NewReader("Foo east=Bar\n"),
+			2,
+		).WithRoadCollapseChance(1),
+	).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if res.IterationsSimulated != 1 || res.AliensStillAlive != 2 || res.RoadsDestroyed != 1 {
+		t.Error(
+			"Expected 1 iteration, 2 living aliens and 1 destroyed road, but got",
+			res.IterationsSimulated, res.AliensStillAlive, res.RoadsDestroyed,
+		)
+	}
+	if render := res.FinalMap.Render(); render != "Foo\nBar\n" {
+		t.Errorf("Expected destroyed road to be omitted from the map, but got %q", render)
+	}
+}
+
+// Tests that defended cities repel attacks until their defences run out, and
+// that their defenders can kill lone aliens.
+func TestSimulationWithDefendedCities(t *testing.T) {
+	tests := []struct {
+		worldMap            string
+		defenderKillChance  float64
+		iterationsSimulated int
+		aliensStillAlive    int
+		citiesRemaining     []string
+		citiesHeldOut       map[string]int
+	}{
+		{"Foo\n", 0, 0, 0, []string{}, map[string]int{}},
+		{"Foo[def=2]\n", 0, 0, 2, []string{"Foo"}, map[string]int{"Foo": 1}},
+		{"Foo[def=1] east=Bar\n", 1, 2, 0, []string{"Foo", "Bar"}, map[string]int{}},
+	}
+	for _, test := range tests {
+		res, err := NewSimulation(
+			newTestSimulationConfig(
+				strings.NewReader(test.worldMap),
+				2,
+			).WithDefenderKillChance(test.defenderKillChance),
+		).Simulate()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if res.IterationsSimulated != test.iterationsSimulated || res.AliensStillAlive != test.aliensStillAlive {
+			t.Error(
+				"For map", test.worldMap,
+				"expected", test.iterationsSimulated, "iterations and", test.aliensStillAlive, "living aliens",
+				"but got", res.IterationsSimulated, "and", res.AliensStillAlive,
+			)
+		}
+		if !stringSlicesEqual(res.CitiesRemaining, test.citiesRemaining) {
+			t.Error("For map", test.worldMap, "expected citiesRemaining =", test.citiesRemaining, "but got", res.CitiesRemaining)
+		}
+		if len(res.CitiesHeldOut) != len(test.citiesHeldOut) {
+			t.Error("For map", test.worldMap, "expected citiesHeldOut =", test.citiesHeldOut, "but got", res.CitiesHeldOut)
+		}
+		for cityName, defence := range test.citiesHeldOut {
+			if res.CitiesHeldOut[cityName] != defence {
+				t.Error("For map", test.worldMap, "expected", cityName, "to have defence", defence, "left, but got", res.CitiesHeldOut[cityName])
+			}
+		}
+	}
+}
+
+// Tests that destroyed cities are rebuilt after the configured number of
+// iterations.
+func TestSimulationWithRebuilding(t *testing.T) {
+	tests := []struct {
+		rebuildAfter    int
+		citiesRemaining []string
+		rebuilds        []CityRebuild
+	}{
+		{0, []string{"Bar", "Baz"}, []CityRebuild{}},
+		{2, []string{"Foo", "Bar", "Baz"}, []CityRebuild{{"Foo", 0, 2}}},
+	}
+	for _, test := range tests {
+		res, err := NewSimulation(
+			newTestSimulationConfig(
+				strings.NewReader("Foo east=Bar\nBar east=Baz\n"),
+				4,
+			).WithRebuilding(test.rebuildAfter, true),
+		).Simulate()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if !stringSlicesEqual(res.CitiesRemaining, test.citiesRemaining) {
+			t.Error(
+				"With rebuildAfter =", test.rebuildAfter,
+				"expected citiesRemaining =", test.citiesRemaining,
+				"but got", res.CitiesRemaining,
+			)
+		}
+		if len(res.Rebuilds) != len(test.rebuilds) {
+			t.Fatal("With rebuildAfter =", test.rebuildAfter, "expected rebuilds", test.rebuilds, "but got", res.Rebuilds)
+		}
+		for i, rebuild := range test.rebuilds {
+			if res.Rebuilds[i] != rebuild {
+				t.Error("With rebuildAfter =", test.rebuildAfter, "expected rebuild", rebuild, "but got", res.Rebuilds[i])
+			}
+		}
+	}
+}
+
+// Tests that running a simulation one iteration at a time has the same outcome
+// as running it in one go, and that it can be finished early.
+func TestSteppingThroughSimulation(t *testing.T) {
+	expected, err := NewSimulation(newTestSimulationConfig(strings.NewReader(ExampleWorld), 4)).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	sim := NewSimulation(newTestSimulationConfig(strings.NewReader(ExampleWorld), 4))
+	if err := sim.Start(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	steps := 0
+	for done := false; !done; steps++ {
+		if done, err = sim.Step(); err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+	}
+	res := sim.Finish()
+	if res.IterationsSimulated != expected.IterationsSimulated || res.AliensStillAlive != expected.AliensStillAlive ||
+		res.StopReason != expected.StopReason || !stringSlicesEqual(res.CitiesRemaining, expected.CitiesRemaining) {
+		t.Error("Expected", expected, "but got", res)
+	}
+	if done, _ := sim.Step(); !done || sim.Finish() != res {
+		t.Error("Expected the simulation to stay finished")
+	}
+
+	sim = NewSimulation(newTestSimulationConfig(strings.NewReader(ExampleWorld), 4))
+	if err := sim.Start(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if _, err := sim.Step(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if res := sim.Finish(); res.IterationsSimulated != 1 || res.StopReason != StopReasonStopped {
+		t.Error("Expected simulation to have been stopped after 1 iteration, but got", res)
+	}
+}
+
+func TestFailedSimulationsGivePartialResults(t *testing.T) {
+	// the placement file runs out of cities for the reinforcements
+	placement, err := NewExplicitPlacement(strings.NewReader("A\nE\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	res, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("A east=B\nB east=C\nC east=D\nD east=E\n"), 2).
+			WithPlacement(placement).
+			WithReinforcements(AlienWave{Count: 1, Start: 2}),
+	).Simulate()
+	if err == nil {
+		t.Fatal("Expected the reinforcements not to be placed")
+	}
+	if res == nil || res.IterationsSimulated != 2 || res.StopReason != StopReasonFailed {
+		t.Error("Expected the partial result of the first 2 iterations, but got", res)
+	}
+}
+
+// minimalHandler only implements SimulationProgressHandler itself, counting the
+// cities destroyed.
+type minimalHandler struct {
+	destroyed int
+}
+
+func (h *minimalHandler) CityDestroyed(cityName string, alienIDs []int) { h.destroyed++ }
+func (h *minimalHandler) AllAliensTrapped()                             {}
+func (h *minimalHandler) AllAliensDead()                                {}
+
+func TestMinimalProgressHandlers(t *testing.T) {
+	minimal, collector := &minimalHandler{}, NewStatsCollector()
+	res, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader(ExampleWorld), 4).
+			WithProgressHandler(MultiSimulationProgressHandler{minimal, collector}),
+	).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	destroyed := len(res.FinalMap.Cities()) - len(res.CitiesRemaining)
+	if destroyed == 0 || minimal.destroyed != destroyed {
+		t.Errorf("Expected the handler to have been told of %d destroyed cities, but got %d", destroyed, minimal.destroyed)
+	}
+	if len(collector.Iterations) < res.IterationsSimulated {
+		t.Errorf("Expected the collector to have recorded at least %d iterations, but got %d", res.IterationsSimulated, len(collector.Iterations))
+	}
+}
+
+// cancellingHandler cancels a simulation's context once the given iteration
+// has been simulated.
+type cancellingHandler struct {
+	NoopSimulationProgressHandler
+	after  int
+	cancel context.CancelFunc
+}
+
+func (h *cancellingHandler) IterationCompleted(status SimulationStatus) {
+	if status.Iteration == h.after {
+		h.cancel()
+	}
+}
+
+func TestCancellingSimulation(t *testing.T) {
+	full, err := NewSimulation(newTestSimulationConfig(strings.NewReader(ExampleWorld), 4)).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if full.IterationsSimulated < 2 || full.Cancelled {
+		t.Fatal("Expected the simulation to run for more than one iteration, but got", full)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader(ExampleWorld), 4).
+			WithProgressHandler(&cancellingHandler{after: 0, cancel: cancel}),
+	).SimulateContext(ctx)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if !res.Cancelled || res.StopReason != StopReasonCancelled || res.IterationsSimulated != 1 {
+		t.Error("Expected the simulation to have been cancelled after 1 iteration, but got", res)
+	}
+
+	// a simulation whose context is already done doesn't get anywhere
+	res, err = NewSimulation(newTestSimulationConfig(strings.NewReader(ExampleWorld), 4)).SimulateContext(ctx)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if !res.Cancelled || res.IterationsSimulated != 0 || res.AliensStillAlive != 4 {
+		t.Error("Expected the simulation to have been cancelled before it began, but got", res)
+	}
+}
+
+func TestCancellingSimulationStepByStep(t *testing.T) {
+	sim := NewSimulation(newTestSimulationConfig(strings.NewReader(ExampleWorld), 4))
+	if err := sim.Start(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if _, err := sim.Step(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	sim.Cancel()
+	if done, err := sim.Step(); !done || err != nil {
+		t.Error("Expected a cancelled simulation to be over, but got", done, err)
+	}
+	if res := sim.Finish(); !res.Cancelled || res.StopReason != StopReasonCancelled || res.IterationsSimulated != 1 {
+		t.Error("Expected the simulation to have been cancelled after 1 iteration, but got", res)
+	}
+}
@@ -0,0 +1,273 @@
+package aliensim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SpawnPattern determines how newly arriving aliens are distributed across the
+// cities in which they can spawn.
+type SpawnPattern int
+
+// The supported spawn patterns.
+const (
+	// SpawnUniform places each alien in any of the spawn cities at random.
+	SpawnUniform SpawnPattern = 0
+	// SpawnClustered picks a random spawn city as the centre of each wave,
+	// and places the aliens in that wave in spawn cities close to it.
+	SpawnClustered SpawnPattern = 1
+	// SpawnEdge only places aliens in spawn cities on the edge of the map
+	// (i.e. cities missing a neighbour to the North, East, South or West).
+	SpawnEdge SpawnPattern = 2
+)
+
+// CityAttrSpawn is the attribute used in the input map to mark a city as a
+// spawn point for aliens (e.g. "Foo[spawn]").
+const CityAttrSpawn = "spawn"
+
+// spawnClusterRadius is the maximum number of roads between the centre of a
+// clustered wave and the cities in which its aliens are placed.
+const spawnClusterRadius = 2
+
+var mapSpawnPatterns = map[string]SpawnPattern{
+	"uniform":   SpawnUniform,
+	"clustered": SpawnClustered,
+	"edge":      SpawnEdge,
+}
+
+// ParseSpawnPattern converts the given spawn pattern name ("uniform",
+// "clustered" or "edge") into its corresponding SpawnPattern value.
+func ParseSpawnPattern(name string) (SpawnPattern, error) {
+	p, ok := mapSpawnPatterns[strings.ToLower(name)]
+	if !ok {
+		return SpawnUniform, NewExtendedSimulationError(
+			ErrInvalidConfig,
+			fmt.Sprintf("Unknown spawn pattern \"%s\" (must be one of uniform, clustered or edge).", name),
+			nil,
+		)
+	}
+	return p, nil
+}
+
+// AlienWave schedules the arrival of reinforcements during a simulation.
+type AlienWave struct {
+	Count int // How many aliens arrive in each wave.
+	Start int // The iteration at the start of which the first wave arrives.
+	Every int // How many iterations apart the waves arrive (0 = only a single wave).
+	Times int // The maximum number of waves to send (0 = unlimited, if Every > 0).
+}
+
+// arrivesAt checks whether a wave of aliens arrives at the start of the given
+// iteration.
+func (w AlienWave) arrivesAt(iteration int) bool {
+	if iteration < w.Start {
+		return false
+	}
+	if w.Every <= 0 {
+		return iteration == w.Start
+	}
+	if (iteration-w.Start)%w.Every != 0 {
+		return false
+	}
+	return w.Times <= 0 || (iteration-w.Start)/w.Every < w.Times
+}
+
+// arrivesAfter checks whether any waves of aliens are still to arrive after the
+// given iteration.
+func (w AlienWave) arrivesAfter(iteration int) bool {
+	if w.Start > iteration {
+		return true
+	}
+	if w.Every <= 0 {
+		return false
+	}
+	return w.Times <= 0 || w.Start+(w.Times-1)*w.Every > iteration
+}
+
+// SpawnedAt returns the iteration at the start of which this alien arrived.
+func (a *Alien) SpawnedAt() int {
+	return a.spawnedAt
+}
+
+// spawnCities works out which cities aliens may spawn in, given the spawn
+// cities from the configuration, any cities marked as spawn points in the map,
+// and the spawn pattern. If no spawn cities are specified, aliens can spawn in
+// any city.
+func (s *Simulation) spawnCities() ([]*City, error) {
+	candidates := []*City{}
+	for _, cityName := range s.config.spawnCities {
+		city := s.worldMap.city(cityName)
+		if city == nil {
+			return nil, NewExtendedSimulationError(
+				ErrUnknownCity,
+				fmt.Sprintf("Cannot spawn aliens in %s, as it is not on the map.", cityName),
+				nil,
+			)
+		}
+		candidates = append(candidates, city)
+	}
+	if len(candidates) == 0 {
+		for _, city := range s.worldMap.cities {
+			if _, marked := city.attributes[CityAttrSpawn]; marked {
+				candidates = append(candidates, city)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, s.worldMap.cities...)
+	}
+	if s.config.spawnPattern == SpawnEdge {
+		edges := []*City{}
+		for _, city := range candidates {
+			if city.onEdge() {
+				edges = append(edges, city)
+			}
+		}
+		if len(edges) == 0 {
+			return nil, NewExtendedSimulationError(
+				ErrInvalidConfig,
+				"None of the spawn cities are on the edge of the map.",
+				nil,
+			)
+		}
+		candidates = edges
+	}
+	if len(candidates) == 0 {
+		return nil, NewExtendedSimulationError(
+			ErrInvalidConfig,
+			"There are no cities on the map in which to place the aliens.",
+			nil,
+		)
+	}
+	return candidates, nil
+}
+
+// spawnAliens places the given number of new aliens in those spawn cities
+// still standing, according to the configured spawn pattern and placement
+// strategy, and gives them the next available IDs. Returns the IDs of the new
+// aliens.
+func (s *Simulation) spawnAliens(count int) ([]int, error) {
+	cities := []*City{}
+	for _, city := range s.spawnPoints {
+		if !city.Destroyed() {
+			cities = append(cities, city)
+		}
+	}
+	if s.config.spawnPattern == SpawnClustered && len(cities) > 0 {
+		centre := cities[s.config.rnd.Uint32()%uint32(len(cities))]
+		cities = centre.nearby(cities, spawnClusterRadius)
+	}
+	occupied := map[*City]bool{}
+	for _, alien := range s.aliens {
+		if alien.alive && alien.state == AlienInCity {
+			occupied[alien.city] = true
+		}
+	}
+	if s.placement == nil {
+		s.placement = placementFor(s.config.placement)
+	}
+	placed, err := s.placement.Place(s.config.rnd, cities, occupied, count)
+	if err != nil {
+		return nil, err
+	}
+	alienIDs := []int{}
+	for _, city := range placed {
+		alien := NewAlien(len(s.aliens), city)
+		alien.spawnedAt = s.iteration
+		alien.species = s.assignSpecies(alien.id)
+		alien.perception = s.config.perception
+		alien.energy = s.config.energyBudget
+		s.speciesStatsFor(alien.species).Spawned++
+		s.aliens = append(s.aliens, alien)
+		s.recordArrival(alien)
+		alienIDs = append(alienIDs, alien.id)
+	}
+	return alienIDs, nil
+}
+
+// spawnReinforcements sends in any waves of aliens due to arrive at the start
+// of the current iteration. Waves can't land once all of the spawn cities have
+// been destroyed.
+func (s *Simulation) spawnReinforcements() error {
+	alienIDs := []int{}
+	for _, wave := range s.config.reinforcements {
+		if !wave.arrivesAt(s.iteration) || !s.spawnPointsStanding() {
+			continue
+		}
+		waveIDs, err := s.spawnAliens(wave.Count)
+		if err != nil {
+			return err
+		}
+		alienIDs = append(alienIDs, waveIDs...)
+	}
+	if handler, ok := s.config.progressHandler.(ReinforcementEventHandler); ok && len(alienIDs) > 0 {
+		handler.ReinforcementsArrived(alienIDs)
+	}
+	return nil
+}
+
+// spawnPointsStanding checks whether any of the spawn cities are still
+// standing.
+func (s *Simulation) spawnPointsStanding() bool {
+	for _, city := range s.spawnPoints {
+		if !city.Destroyed() {
+			return true
+		}
+	}
+	return false
+}
+
+// reinforcementsPending checks whether any more waves of aliens are still due
+// to arrive, and whether any spawn cities are (or will be) standing for them to
+// land in.
+func (s *Simulation) reinforcementsPending() bool {
+	if !s.spawnPointsStanding() && !s.rebuildsPending() {
+		return false
+	}
+	for _, wave := range s.config.reinforcements {
+		if wave.arrivesAfter(s.iteration) {
+			return true
+		}
+	}
+	return false
+}
+
+// onEdge checks whether this city is on the edge of the map, i.e. whether it
+// is missing a neighbour in any of the four primary directions.
+func (c *City) onEdge() bool {
+	for dir := DirNorth; dir <= DirWest; dir++ {
+		if c.neighbours[dir] == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// nearby returns those of the given cities that are at most the given number
+// of links away from this city, closest first.
+func (c *City) nearby(cities []*City, radius int) []*City {
+	wanted := map[*City]bool{}
+	for _, city := range cities {
+		wanted[city] = true
+	}
+	distances := map[*City]int{c: 0}
+	queue := []*City{c}
+	result := []*City{}
+	for len(queue) > 0 {
+		city := queue[0]
+		queue = queue[1:]
+		if wanted[city] {
+			result = append(result, city)
+		}
+		if distances[city] == radius {
+			continue
+		}
+		for _, neighbour := range city.neighbours {
+			if _, seen := distances[neighbour]; neighbour != nil && !seen {
+				distances[neighbour] = distances[city] + 1
+				queue = append(queue, neighbour)
+			}
+		}
+	}
+	return result
+}
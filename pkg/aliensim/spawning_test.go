@@ -0,0 +1,130 @@
+package aliensim
+
+import (
+	"strings"
+	"testing"
+)
+
+const gridTestMap string = `A east=B south=D
+B east=C south=E
+C south=F
+D east=E south=G
+E east=F south=H
+F south=I
+G east=H
+H east=I
+`
+
+func TestAlienWaveSchedule(t *testing.T) {
+	wave := AlienWave{Count: 10, Start: 5, Every: 10, Times: 3}
+	expected := map[int]bool{5: true, 15: true, 25: true}
+	for i := 0; i <= 40; i++ {
+		if wave.arrivesAt(i) != expected[i] {
+			t.Error("Expected arrivesAt(", i, ") to be", expected[i])
+		}
+	}
+	if !wave.arrivesAfter(24) || wave.arrivesAfter(25) {
+		t.Error("Expected the last wave to arrive at iteration 25")
+	}
+	if once := (AlienWave{Count: 1, Start: 3}); !once.arrivesAt(3) || once.arrivesAt(6) || once.arrivesAfter(3) {
+		t.Error("Expected a single wave to arrive at iteration 3 only")
+	}
+}
+
+func TestSimulationWithReinforcements(t *testing.T) {
+	res, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader(ExampleWorld), 2).
+			WithReinforcements(AlienWave{Count: 2, Start: 3, Every: 3, Times: 2}),
+	).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	expected := map[int]int{0: 0, 1: 0, 2: 3, 3: 3, 4: 6, 5: 6}
+	if len(res.AlienSpawnTimes) != len(expected) {
+		t.Fatal("Expected spawn times", expected, "but got", res.AlienSpawnTimes)
+	}
+	for id, spawnedAt := range expected {
+		if res.AlienSpawnTimes[id] != spawnedAt {
+			t.Error("Expected alien", id, "to have spawned at", spawnedAt, "but got", res.AlienSpawnTimes[id])
+		}
+	}
+}
+
+func TestSpawnCities(t *testing.T) {
+	tests := []struct {
+		worldMap    string
+		spawnCities []string
+		pattern     SpawnPattern
+		expected    []string
+	}{
+		{gridTestMap, nil, SpawnUniform, []string{"A", "B", "D", "C", "E", "F", "G", "H", "I"}},
+		{gridTestMap, nil, SpawnEdge, []string{"A", "B", "D", "C", "F", "G", "H", "I"}},
+		{gridTestMap, []string{"E", "F"}, SpawnEdge, []string{"F"}},
+		{"Foo[spawn] north=Bar\nBar east=Baz\nBaz[spawn]\n", nil, SpawnUniform, []string{"Foo", "Baz"}},
+		{"Foo[spawn] north=Bar\n", []string{"Bar"}, SpawnUniform, []string{"Bar"}},
+	}
+	for _, test := range tests {
+		m, err := ParseWorldMap(strings.NewReader(test.worldMap))
+		if err != nil {
+			t.Fatal("Parsing failed with error:", err)
+		}
+		s := NewSimulation(
+			newTestSimulationConfig(nil, 2).
+				WithSpawnCities(test.spawnCities).
+				WithSpawnPattern(test.pattern),
+		)
+		s.worldMap = m
+		cities, err := s.spawnCities()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		names := []string{}
+		for _, city := range cities {
+			names = append(names, city.name)
+		}
+		if !stringSlicesEqual(names, test.expected) {
+			t.Error("Expected spawn cities", test.expected, "but got", names)
+		}
+	}
+}
+
+func TestClusteredSpawning(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader(gridTestMap))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	nearby := m.city("A").nearby([]*City{m.city("A"), m.city("E"), m.city("I")}, spawnClusterRadius)
+	if len(nearby) != 2 || nearby[0].name != "A" || nearby[1].name != "E" {
+		t.Error("Expected A and E to be near A, but got", nearby)
+	}
+}
+
+func TestReinforcementsSkipDestroyedSpawnCities(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader("Foo[spawn] north=Bar\nBar[spawn] east=Baz\n"))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	s := NewSimulation(
+		newTestSimulationConfig(nil, 2).
+			WithReinforcements(AlienWave{Count: 2, Start: 1}),
+	)
+	s.worldMap = m
+	if s.spawnPoints, err = s.spawnCities(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	m.city("Foo").state = CityDestroyed
+	s.iteration = 1
+	if err := s.spawnReinforcements(); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	for _, alien := range s.aliens {
+		if alien.city.name != "Bar" {
+			t.Error("Expected all reinforcements to land in Bar, but got", alien)
+		}
+	}
+	m.city("Bar").state = CityDestroyed
+	s.aliens = nil
+	if err := s.spawnReinforcements(); err != nil || len(s.aliens) != 0 {
+		t.Error("Expected no reinforcements to land once all spawn cities are destroyed")
+	}
+}
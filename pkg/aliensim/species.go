@@ -0,0 +1,313 @@
+package aliensim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Species describes the traits shared by a particular kind of alien.
+type Species struct {
+	Name          string
+	Speed         int              // How many moves an alien of this species makes per iteration.
+	Strength      int              // How strong an alien of this species is in a fight.
+	Movement      MovementStrategy // How an alien of this species picks the next road to take.
+	FightsOwnKind bool             // Do aliens of this species fight each other?
+	Weight        int              // The share of aliens belonging to this species, relative to the other species.
+}
+
+// SpeciesStats summarises how the aliens of a particular species fared during
+// a simulation.
+type SpeciesStats struct {
+	Spawned         int `json:"spawned"`          // How many aliens of this species arrived.
+	Born            int `json:"born"`             // How many aliens of this species were born on the map.
+	StillAlive      int `json:"still_alive"`      // How many aliens of this species are still alive.
+	FightsWon       int `json:"fights_won"`       // How many fights were survived by an alien of this species.
+	CitiesDestroyed int `json:"cities_destroyed"` // How many cities were destroyed by fights involving this species.
+	Distance        int `json:"distance"`         // The total distance travelled by aliens of this species.
+}
+
+// MovementStrategy decides which road an alien in a city takes next.
+type MovementStrategy interface {
+	// ChooseRoad picks one of the given roads, all of which the alien is able
+	// to travel along, or returns nil if the alien should stay where it is.
+	ChooseRoad(rnd RandomGenerator, alien *Alien, roads []*Road) *Road
+}
+
+// RandomMovement has aliens take any of the roads available to them at random.
+type RandomMovement struct{}
+
+// StraightMovement has aliens keep travelling in the same direction for as
+// long as they can, only picking a new direction at random when they have to.
+type StraightMovement struct{}
+
+// DefaultSpeciesName is the name of the species to which all aliens belong if
+// no species are configured for a simulation.
+const DefaultSpeciesName = "alien"
+
+// defaultSpecies has the traits of the aliens from the original problem
+// statement.
+var defaultSpecies = &Species{
+	Name:          DefaultSpeciesName,
+	Speed:         1,
+	Strength:      1,
+	Movement:      RandomMovement{},
+	FightsOwnKind: true,
+	Weight:        1,
+}
+
+// ParseMovementStrategy converts the given movement strategy name ("random",
+// "straight", "avoid-revisits" or "explore") into its corresponding strategy.
+func ParseMovementStrategy(name string) (MovementStrategy, error) {
+	switch strings.ToLower(name) {
+	case "random":
+		return RandomMovement{}, nil
+	case "straight":
+		return StraightMovement{}, nil
+	case "avoid-revisits":
+		return AvoidRevisitsMovement{}, nil
+	case "explore":
+		return ExploreMovement{}, nil
+	}
+	return nil, NewExtendedSimulationError(
+		ErrInvalidSpecies,
+		fmt.Sprintf("Unknown movement strategy \"%s\" (must be one of random, straight, avoid-revisits or explore).", name),
+		nil,
+	)
+}
+
+// ParseSpecies reads species definitions, one per line, from the given reader.
+// Each line starts with the name of the species, followed by any of its traits
+// as key=value pairs, e.g.:
+//
+//	Grunt speed=1 strength=3 movement=random fights-own-kind=false weight=2
+//
+// Traits that aren't given take on the values of the default species.
+func ParseSpecies(r io.Reader) ([]*Species, error) {
+	species := []*Species{}
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		parts := strings.Fields(scanner.Text())
+		if len(parts) == 0 {
+			continue
+		}
+		sp, err := parseSpeciesLine(parts)
+		if err == nil && seen[sp.Name] {
+			err = NewExtendedSimulationError(ErrInvalidSpecies, fmt.Sprintf("%s has already been defined.", sp.Name), nil)
+		}
+		if err != nil {
+			return nil, NewExtendedSimulationError(
+				ErrInvalidSpecies,
+				fmt.Sprintf("Parsing error on line %d.", lineNo),
+				err,
+			)
+		}
+		seen[sp.Name] = true
+		species = append(species, sp)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, NewExtendedSimulationError(ErrInvalidSpecies, "Failed to read species definitions.", err)
+	}
+	return species, nil
+}
+
+// parseSpeciesLine parses the name and traits of a single species.
+func parseSpeciesLine(parts []string) (*Species, error) {
+	sp := *defaultSpecies
+	sp.Name = parts[0]
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, NewExtendedSimulationError(
+				ErrInvalidSpecies,
+				fmt.Sprintf("Expected a key=value pair, but got \"%s\".", part),
+				nil,
+			)
+		}
+		var err error
+		switch kv[0] {
+		case "speed":
+			sp.Speed, err = strconv.Atoi(kv[1])
+		case "strength":
+			sp.Strength, err = strconv.Atoi(kv[1])
+		case "movement":
+			sp.Movement, err = ParseMovementStrategy(kv[1])
+		case "fights-own-kind":
+			sp.FightsOwnKind, err = strconv.ParseBool(kv[1])
+		case "weight":
+			sp.Weight, err = strconv.Atoi(kv[1])
+		default:
+			return nil, NewExtendedSimulationError(ErrInvalidSpecies, fmt.Sprintf("Unknown trait \"%s\".", kv[0]), nil)
+		}
+		if err != nil {
+			return nil, NewExtendedSimulationError(
+				ErrInvalidSpecies,
+				fmt.Sprintf("Invalid value for %s of %s: \"%s\".", kv[0], sp.Name, kv[1]),
+				err,
+			)
+		}
+	}
+	if err := sp.validate(); err != nil {
+		return nil, err
+	}
+	return &sp, nil
+}
+
+// validate checks that this species' traits make sense.
+func (sp *Species) validate() error {
+	var problem string
+	switch {
+	case len(sp.Name) == 0:
+		problem = "Species must have a name."
+	case sp.Speed < 1:
+		problem = fmt.Sprintf("The speed of %s must be at least 1.", sp.Name)
+	case sp.Strength < 1:
+		problem = fmt.Sprintf("The strength of %s must be at least 1.", sp.Name)
+	case sp.Weight < 0:
+		problem = fmt.Sprintf("The weight of %s cannot be negative.", sp.Name)
+	case sp.Movement == nil:
+		problem = fmt.Sprintf("%s must have a movement strategy.", sp.Name)
+	default:
+		return nil
+	}
+	return NewExtendedSimulationError(ErrInvalidSpecies, problem, nil)
+}
+
+// ChooseRoad implements MovementStrategy.
+func (m RandomMovement) ChooseRoad(rnd RandomGenerator, alien *Alien, roads []*Road) *Road {
+	if len(roads) == 0 {
+		return nil
+	}
+	return roads[rnd.Uint32()%uint32(len(roads))]
+}
+
+// ChooseRoad implements MovementStrategy.
+func (m StraightMovement) ChooseRoad(rnd RandomGenerator, alien *Alien, roads []*Road) *Road {
+	if alien.heading >= 0 {
+		for _, road := range roads {
+			if road == alien.city.roads[alien.heading] {
+				return road
+			}
+		}
+	}
+	return RandomMovement{}.ChooseRoad(rnd, alien, roads)
+}
+
+// Species returns the species to which this alien belongs.
+func (a *Alien) Species() *Species {
+	return a.species
+}
+
+// assignSpecies works out which of the configured species the alien with the
+// given ID belongs to. Species take turns, in proportion to their weights.
+func (s *Simulation) assignSpecies(alienID int) *Species {
+	total := 0
+	for _, sp := range s.config.species {
+		total += sp.Weight
+	}
+	if total == 0 {
+		return defaultSpecies
+	}
+	turn := alienID % total
+	for _, sp := range s.config.species {
+		if turn < sp.Weight {
+			return sp
+		}
+		turn -= sp.Weight
+	}
+	return defaultSpecies
+}
+
+// validateSpecies checks that the configured species make sense.
+func (s *Simulation) validateSpecies() error {
+	total := 0
+	seen := map[string]bool{}
+	for _, sp := range s.config.species {
+		if err := sp.validate(); err != nil {
+			return err
+		}
+		if seen[sp.Name] {
+			return NewExtendedSimulationError(ErrInvalidSpecies, fmt.Sprintf("%s has been defined more than once.", sp.Name), nil)
+		}
+		seen[sp.Name] = true
+		total += sp.Weight
+	}
+	if len(s.config.species) > 0 && total == 0 {
+		return NewExtendedSimulationError(ErrInvalidSpecies, "At least one species must have a positive weight.", nil)
+	}
+	return nil
+}
+
+// willFight checks whether the aliens with the given IDs will fight each other
+// when they meet. Aliens of different species always fight, but aliens of the
+// same species only fight if their species fights its own kind.
+func (s *Simulation) willFight(alienIDs []int) bool {
+	if len(alienIDs) < 2 {
+		return false
+	}
+	first := s.aliens[alienIDs[0]].species
+	for _, id := range alienIDs[1:] {
+		if s.aliens[id].species != first {
+			return true
+		}
+	}
+	return first.FightsOwnKind
+}
+
+// strongest returns the ID of the strongest of the given aliens, or -1 if more
+// than one of them are equally strong.
+func (s *Simulation) strongest(alienIDs []int) int {
+	strongest, strength := -1, 0
+	for _, id := range alienIDs {
+		switch sp := s.aliens[id].species; {
+		case sp.Strength > strength:
+			strongest, strength = id, sp.Strength
+		case sp.Strength == strength:
+			strongest = -1
+		}
+	}
+	return strongest
+}
+
+// speciesStatsFor returns the running stats for the given species.
+func (s *Simulation) speciesStatsFor(sp *Species) *SpeciesStats {
+	stats, exists := s.speciesStats[sp.Name]
+	if !exists {
+		stats = &SpeciesStats{}
+		s.speciesStats[sp.Name] = stats
+	}
+	return stats
+}
+
+// checkSpeciesEliminated lets the progress handler know of any species whose
+// last living alien has just been killed. Only applies when there is more than
+// one species, since otherwise all of the aliens are dead.
+func (s *Simulation) checkSpeciesEliminated() {
+	if len(s.config.species) < 2 {
+		return
+	}
+	alive := map[string]bool{}
+	for _, alien := range s.aliens {
+		if alien.alive {
+			alive[alien.species.Name] = true
+		}
+	}
+	for _, sp := range s.config.species {
+		_, spawned := s.speciesStats[sp.Name]
+		if !spawned || alive[sp.Name] {
+			delete(s.eliminated, sp.Name)
+			continue
+		}
+		if !s.eliminated[sp.Name] {
+			s.eliminated[sp.Name] = true
+			if handler, ok := s.config.progressHandler.(SpeciesEventHandler); ok {
+				handler.SpeciesEliminated(sp.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package aliensim
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSpecies(t *testing.T) {
+	species, err := ParseSpecies(strings.NewReader(
+		"Grunt strength=3 fights-own-kind=false weight=2\n\nScout speed=2 movement=straight\n",
+	))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	expected := []Species{
+		{Name: "Grunt", Speed: 1, Strength: 3, Movement: RandomMovement{}, FightsOwnKind: false, Weight: 2},
+		{Name: "Scout", Speed: 2, Strength: 1, Movement: StraightMovement{}, FightsOwnKind: true, Weight: 1},
+	}
+	if len(species) != len(expected) {
+		t.Fatal("Expected", len(expected), "species, but got", len(species))
+	}
+	for i, sp := range species {
+		if *sp != expected[i] {
+			t.Error("Expected", expected[i], "but got", *sp)
+		}
+	}
+
+	invalid := []string{
+		"Grunt speed=0\n",
+		"Grunt strength=lots\n",
+		"Grunt movement=teleport\n",
+		"Grunt colour=green\n",
+		"Grunt weight\n",
+		"Grunt\nGrunt\n",
+	}
+	for _, definition := range invalid {
+		if _, err := ParseSpecies(strings.NewReader(definition)); err == nil {
+			t.Errorf("Expected an error when parsing %q", definition)
+		}
+	}
+}
+
+func TestAssignSpecies(t *testing.T) {
+	grunt := &Species{Name: "Grunt", Speed: 1, Strength: 1, Movement: RandomMovement{}, Weight: 2}
+	scout := &Species{Name: "Scout", Speed: 1, Strength: 1, Movement: RandomMovement{}, Weight: 1}
+	s := NewSimulation(newTestSimulationConfig(nil, 2).WithSpecies(grunt, scout))
+	expected := []*Species{grunt, grunt, scout, grunt, grunt, scout}
+	for id, sp := range expected {
+		if assigned := s.assignSpecies(id); assigned != sp {
+			t.Error("Expected alien", id, "to be a", sp.Name, "but got", assigned.Name)
+		}
+	}
+	if sp := NewSimulation(newTestSimulationConfig(nil, 2)).assignSpecies(0); sp.Name != DefaultSpeciesName {
+		t.Error("Expected the default species when none are configured, but got", sp.Name)
+	}
+}
+
+// Tests that the strongest alien survives a fight, and that aliens of a
+// species that doesn't fight its own kind can share a city.
+func TestSimulationWithSpecies(t *testing.T) {
+	tests := []struct {
+		species          string
+		aliensStillAlive int
+		citiesRemaining  []string
+		stats            map[string]SpeciesStats
+	}{
+		{
+			"Grunt strength=2\nScout\n",
+			1,
+			[]string{},
+			map[string]SpeciesStats{
+				"Grunt": {Spawned: 1, StillAlive: 1, FightsWon: 1, CitiesDestroyed: 1},
+				"Scout": {Spawned: 1, StillAlive: 0, FightsWon: 0, CitiesDestroyed: 1},
+			},
+		},
+		{
+			"Grunt\nScout\n",
+			0,
+			[]string{},
+			map[string]SpeciesStats{
+				"Grunt": {Spawned: 1, CitiesDestroyed: 1},
+				"Scout": {Spawned: 1, CitiesDestroyed: 1},
+			},
+		},
+		{
+			"Grunt fights-own-kind=false\n",
+			2,
+			[]string{"Foo"},
+			map[string]SpeciesStats{
+				"Grunt": {Spawned: 2, StillAlive: 2},
+			},
+		},
+	}
+	for _, test := range tests {
+		species, err := ParseSpecies(strings.NewReader(test.species))
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		res, err := NewSimulation(
+			newTestSimulationConfig(strings.NewReader("Foo\n"), 2).WithSpecies(species...),
+		).Simulate()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if res.AliensStillAlive != test.aliensStillAlive {
+			t.Error("For species", test.species, "expected", test.aliensStillAlive, "living aliens, but got", res.AliensStillAlive)
+		}
+		if !stringSlicesEqual(res.CitiesRemaining, test.citiesRemaining) {
+			t.Error("For species", test.species, "expected citiesRemaining =", test.citiesRemaining, "but got", res.CitiesRemaining)
+		}
+		if len(res.SpeciesStats) != len(test.stats) {
+			t.Error("For species", test.species, "expected stats", test.stats, "but got", res.SpeciesStats)
+		}
+		for name, stats := range test.stats {
+			if res.SpeciesStats[name] != stats {
+				t.Error("For species", test.species, "expected", name, "stats", stats, "but got", res.SpeciesStats[name])
+			}
+		}
+	}
+}
+
+// Tests that fast aliens make several moves per iteration, and that aliens
+// moving in straight lines keep going until they have to turn.
+func TestSimulationWithFastStraightMovers(t *testing.T) {
+	species, err := ParseSpecies(strings.NewReader("Runner speed=2 movement=straight fights-own-kind=false\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	s := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("A east=B\nB east=C\n"), 2).
+			WithSpawnCities([]string{"A"}).
+			WithSpecies(species...),
+	)
+	res, err := s.Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if res.IterationsSimulated != 5 || res.SpeciesStats["Runner"].Distance != 20 {
+		t.Error(
+			"Expected 5 iterations and a total distance of 20, but got",
+			res.IterationsSimulated, res.SpeciesStats["Runner"].Distance,
+		)
+	}
+	// after 5 iterations of 2 moves each, bouncing between A and C
+	for _, alien := range res.FinalAliens {
+		if alien.city.name != "C" {
+			t.Error("Expected all runners to end up in C, but got", alien)
+		}
+	}
+}
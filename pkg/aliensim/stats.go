@@ -0,0 +1,133 @@
+package aliensim
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// IterationStats captures the state of a simulation at the end of a single
+// iteration.
+type IterationStats struct {
+	Iteration              int
+	AliensAlive            int
+	CitiesStanding         int
+	Moves                  int // The number of moves made by all of the aliens during the iteration.
+	Collisions             int // The number of fights between aliens during the iteration.
+	AliensTrapped          int // How many living aliens have energy left, but nowhere to go.
+	LargestIntactComponent int // The number of cities in the largest group of standing cities still linked by roads.
+}
+
+// StatsCollector is a SimulationProgressHandler that records statistics about
+// a simulation at the end of every iteration, ignoring all other events.
+type StatsCollector struct {
+	NoopSimulationProgressHandler
+	Iterations []IterationStats // The statistics for each iteration, in order.
+}
+
+// NewStatsCollector creates a StatsCollector that hasn't recorded any
+// iterations yet.
+func NewStatsCollector() *StatsCollector {
+	return &StatsCollector{Iterations: []IterationStats{}}
+}
+
+// IterationCompleted records the statistics for the iteration that has just
+// been simulated.
+func (c *StatsCollector) IterationCompleted(status SimulationStatus) {
+	c.Iterations = append(c.Iterations, IterationStats{
+		Iteration:              status.Iteration,
+		AliensAlive:            status.AliensAlive,
+		CitiesStanding:         status.CitiesStanding,
+		Moves:                  status.Moves,
+		Collisions:             status.Collisions,
+		AliensTrapped:          status.AliensTrapped,
+		LargestIntactComponent: status.LargestIntactComponent,
+	})
+}
+
+// WriteCSV writes the statistics recorded so far to the given writer as CSV,
+// with a header row followed by one row for each iteration.
+func (c *StatsCollector) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	err := out.Write([]string{
+		"iteration", "aliens_alive", "cities_standing", "moves",
+		"collisions", "aliens_trapped", "largest_intact_component",
+	})
+	if err != nil {
+		return err
+	}
+	for _, stats := range c.Iterations {
+		err = out.Write([]string{
+			strconv.Itoa(stats.Iteration),
+			strconv.Itoa(stats.AliensAlive),
+			strconv.Itoa(stats.CitiesStanding),
+			strconv.Itoa(stats.Moves),
+			strconv.Itoa(stats.Collisions),
+			strconv.Itoa(stats.AliensTrapped),
+			strconv.Itoa(stats.LargestIntactComponent),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// LargestIntactComponent returns the number of cities in the largest group of
+// cities that are still standing and linked to one another by roads that
+// haven't been destroyed, regardless of the direction in which the roads can be
+// travelled.
+func (m *WorldMap) LargestIntactComponent() int {
+	seen := make([]bool, len(m.cities))
+	largest := 0
+	pending := []*City{}
+	for _, start := range m.cities {
+		if seen[start.id] || start.Destroyed() {
+			continue
+		}
+		seen[start.id] = true
+		size := 0
+		pending = append(pending[:0], start)
+		for len(pending) > 0 {
+			city := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+			size++
+			for _, road := range city.roads {
+				if road == nil || road.destroyed {
+					continue
+				}
+				if n := road.Other(city); !seen[n.id] && !n.Destroyed() {
+					seen[n.id] = true
+					pending = append(pending, n)
+				}
+			}
+		}
+		if size > largest {
+			largest = size
+		}
+	}
+	return largest
+}
+
+// largestIntactComponent returns the number of cities in the largest intact
+// component of the world map, keeping track of the components from then on so
+// that they only need updating as cities and roads are destroyed or rebuilt.
+func (s *Simulation) largestIntactComponent() int {
+	if s.components == nil {
+		s.components = newComponentTracker(s.worldMap)
+	}
+	return s.components.largestComponent()
+}
+
+// aliensTrapped counts the living aliens that have energy left, but are in
+// cities from which they can't travel anywhere.
+func (s *Simulation) aliensTrapped() int {
+	trapped := 0
+	for _, alien := range s.aliens {
+		if alien.alive && !alien.Exhausted() && alien.trapped() {
+			trapped++
+		}
+	}
+	return trapped
+}
@@ -0,0 +1,76 @@
+package aliensim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStatsCollector(t *testing.T) {
+	collector, other := NewStatsCollector(), NewStatsCollector()
+	_, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("A east=B\n"), 2).
+			WithSpawnCities([]string{"A"}).
+			WithProgressHandler(MultiSimulationProgressHandler{collector, other}),
+	).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	// both aliens set off for B, but destroy each other and A in the process
+	expected := []IterationStats{
+		{
+			Iteration:              0,
+			AliensAlive:            0,
+			CitiesStanding:         1,
+			Moves:                  2,
+			Collisions:             1,
+			AliensTrapped:          0,
+			LargestIntactComponent: 1,
+		},
+		{
+			Iteration:              1,
+			AliensAlive:            0,
+			CitiesStanding:         1,
+			LargestIntactComponent: 1,
+		},
+	}
+	for _, c := range []*StatsCollector{collector, other} {
+		if len(c.Iterations) != len(expected) {
+			t.Fatal("Expected", expected, "but got", c.Iterations)
+		}
+		for i, stats := range expected {
+			if c.Iterations[i] != stats {
+				t.Error("Expected", stats, "for iteration", i, "but got", c.Iterations[i])
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := collector.WriteCSV(&buf); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	expectedCSV := "iteration,aliens_alive,cities_standing,moves,collisions,aliens_trapped,largest_intact_component\n" +
+		"0,0,1,2,1,0,1\n" +
+		"1,0,1,0,0,0,1\n"
+	if buf.String() != expectedCSV {
+		t.Errorf("Expected CSV:\n%s\nbut got:\n%s", expectedCSV, buf.String())
+	}
+}
+
+func TestLargestIntactComponent(t *testing.T) {
+	m, _ := parseGridTestMap(t)
+	if largest := m.LargestIntactComponent(); largest != 9 {
+		t.Error("Expected the whole map to be intact, but got", largest)
+	}
+	// the cities around the edge are still linked to one another
+	m.city("E").state = CityDestroyed
+	if largest := m.LargestIntactComponent(); largest != 8 {
+		t.Error("Expected 8 cities to be linked, but got", largest)
+	}
+	// cutting A and D off leaves C, F, I, H and G
+	m.city("B").state = CityDestroyed
+	m.city("D").roads[DirSouth].destroyed = true
+	if largest := m.LargestIntactComponent(); largest != 5 {
+		t.Error("Expected 5 cities to be linked, but got", largest)
+	}
+}
@@ -0,0 +1,220 @@
+package aliensim
+
+import (
+	"fmt"
+	"time"
+)
+
+// The reasons for which a simulation can stop, regardless of which stop
+// criteria are configured.
+const (
+	StopReasonAllAliensDead = "all of the aliens are dead"
+	StopReasonAliensStuck   = "none of the aliens can move"
+	StopReasonHardLimit     = "the iteration hard limit has been reached"
+	StopReasonStopped       = "the simulation was stopped before it was over"
+	StopReasonCancelled     = "the simulation was cancelled before it was over"
+	StopReasonFailed        = "the simulation failed before it was over"
+)
+
+// The kinds of reason for which a simulation can stop. Unlike the reasons
+// themselves, these don't include any of the numbers the stop criteria were
+// configured with, so they can be used to group simulations by why they
+// stopped.
+const (
+	StopKindAllAliensDead    = "all_dead"
+	StopKindAliensStuck      = "aliens_stuck"
+	StopKindHardLimit        = "hard_limit"
+	StopKindStopped          = "stopped"
+	StopKindCancelled        = "cancelled"
+	StopKindFailed           = "failed"
+	StopKindMaxMovesPerAlien = "max_moves_per_alien"
+	StopKindMaxTotalMoves    = "max_total_moves"
+	StopKindWallClock        = "wall_clock"
+	StopKindNoDestruction    = "no_destruction"
+	StopKindMaxIterations    = "max_iterations"
+	StopKindPredicate        = "predicate"
+	StopKindCustom           = "custom" // Any other StopCriterion.
+)
+
+// StopCriterion decides when a simulation should stop, in addition to when all
+// of the aliens are dead or none of them can move.
+type StopCriterion interface {
+	// ShouldStop checks whether the simulation should stop after the iteration
+	// it has just simulated.
+	ShouldStop(s *Simulation) bool
+	// Reason describes why the simulation stopped, if this criterion was
+	// responsible.
+	Reason() string
+}
+
+// SimulationStatus describes how far a simulation has progressed.
+type SimulationStatus struct {
+	Iteration              int           // The iteration that has just been simulated.
+	Elapsed                time.Duration // How long the simulation has been running for.
+	AliensAlive            int
+	CitiesStanding         int
+	Moves                  int // The number of moves made by all of the aliens during the iteration.
+	TotalMoves             int // The number of moves made by all of the aliens so far.
+	LastDestruction        int // The iteration during which a city was last destroyed (-1 if none have been).
+	Collisions             int // The number of fights between aliens during the iteration.
+	AliensTrapped          int // How many living aliens have energy left, but nowhere to go.
+	LargestIntactComponent int // The number of cities in the largest group of standing cities still linked by roads.
+}
+
+// MaxMovesPerAlien stops a simulation once every alien that is still able to
+// move has made at least the given number of moves, as in the original problem
+// statement. It leaves simulations in which none of the aliens can move to stop
+// for that reason instead.
+type MaxMovesPerAlien struct {
+	Moves int
+}
+
+// MaxTotalMoves stops a simulation once the aliens have made at least the given
+// number of moves between them.
+type MaxTotalMoves struct {
+	Moves int
+}
+
+// WallClockLimit stops a simulation once it has been running for at least the
+// given duration.
+type WallClockLimit struct {
+	Duration time.Duration
+}
+
+// NoDestructionFor stops a simulation once no cities have been destroyed for
+// the given number of iterations.
+type NoDestructionFor struct {
+	Iterations int
+}
+
+// MaxIterations stops a simulation once it has simulated the given number of
+// iterations. Simulations never run for more than SimulationIterationsHardLimit
+// iterations, regardless.
+type MaxIterations struct {
+	Iterations int
+}
+
+// StopPredicate stops a simulation as soon as the given predicate returns true
+// for its status, for the given reason.
+type StopPredicate struct {
+	Description string
+	Predicate   func(status SimulationStatus) bool
+}
+
+// Status describes how far this simulation has progressed.
+func (s *Simulation) Status() SimulationStatus {
+	return SimulationStatus{
+		Iteration:              s.iteration,
+		Elapsed:                time.Since(s.started),
+		AliensAlive:            s.livingAliens(),
+		CitiesStanding:         len(s.worldMap.cities) - s.citiesDestroyed,
+		Moves:                  s.moves,
+		TotalMoves:             s.totalMoves,
+		LastDestruction:        s.lastDestruction,
+		Collisions:             s.collisions,
+		AliensTrapped:          s.aliensTrapped(),
+		LargestIntactComponent: s.largestIntactComponent(),
+	}
+}
+
+// checkStopCriteria checks whether any of the configured stop criteria say the
+// simulation should stop after the current iteration, returning the criterion
+// if so.
+func (s *Simulation) checkStopCriteria() StopCriterion {
+	for _, criterion := range s.config.stopCriteria {
+		if criterion.ShouldStop(s) {
+			return criterion
+		}
+	}
+	return nil
+}
+
+// stopKind returns the kind of reason for which the given criterion stops a
+// simulation.
+func stopKind(criterion StopCriterion) string {
+	switch criterion.(type) {
+	case MaxMovesPerAlien:
+		return StopKindMaxMovesPerAlien
+	case MaxTotalMoves:
+		return StopKindMaxTotalMoves
+	case WallClockLimit:
+		return StopKindWallClock
+	case NoDestructionFor:
+		return StopKindNoDestruction
+	case MaxIterations:
+		return StopKindMaxIterations
+	case StopPredicate:
+		return StopKindPredicate
+	}
+	return StopKindCustom
+}
+
+// ShouldStop implements StopCriterion.
+func (c MaxMovesPerAlien) ShouldStop(s *Simulation) bool {
+	canMove := false
+	for _, alien := range s.aliens {
+		if !alien.alive || alien.Exhausted() || alien.trapped() {
+			continue
+		}
+		if alien.distance < c.Moves {
+			return false
+		}
+		canMove = true
+	}
+	return canMove
+}
+
+// Reason implements StopCriterion.
+func (c MaxMovesPerAlien) Reason() string {
+	return fmt.Sprintf("every alien that can move has made %d moves", c.Moves)
+}
+
+// ShouldStop implements StopCriterion.
+func (c MaxTotalMoves) ShouldStop(s *Simulation) bool {
+	return s.totalMoves >= c.Moves
+}
+
+// Reason implements StopCriterion.
+func (c MaxTotalMoves) Reason() string {
+	return fmt.Sprintf("the aliens have made %d moves in total", c.Moves)
+}
+
+// ShouldStop implements StopCriterion.
+func (c WallClockLimit) ShouldStop(s *Simulation) bool {
+	return time.Since(s.started) >= c.Duration
+}
+
+// Reason implements StopCriterion.
+func (c WallClockLimit) Reason() string {
+	return fmt.Sprintf("the simulation has been running for %s", c.Duration)
+}
+
+// ShouldStop implements StopCriterion.
+func (c NoDestructionFor) ShouldStop(s *Simulation) bool {
+	return s.iteration-s.lastDestruction >= c.Iterations
+}
+
+// Reason implements StopCriterion.
+func (c NoDestructionFor) Reason() string {
+	return fmt.Sprintf("no cities have been destroyed for %d iterations", c.Iterations)
+}
+
+// ShouldStop implements StopCriterion.
+func (c MaxIterations) ShouldStop(s *Simulation) bool {
+	return s.iteration+1 >= c.Iterations
+}
+
+// Reason implements StopCriterion.
+func (c MaxIterations) Reason() string {
+	return fmt.Sprintf("%d iterations have been simulated", c.Iterations)
+}
+
+// ShouldStop implements StopCriterion.
+func (c StopPredicate) ShouldStop(s *Simulation) bool {
+	return c.Predicate(s.Status())
+}
+
+// Reason implements StopCriterion.
+func (c StopPredicate) Reason() string {
+	return c.Description
+}
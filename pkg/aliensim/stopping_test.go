@@ -0,0 +1,95 @@
+package aliensim
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that each of the stop criteria stops a simulation of two aliens that
+// bounce back and forth between two cities (making a move each per iteration)
+// at the right time, and that the criterion is reported.
+func TestStopCriteria(t *testing.T) {
+	tests := []struct {
+		criterion           StopCriterion
+		iterationsSimulated int
+		stopKind            string
+	}{
+		{MaxMovesPerAlien{Moves: 4}, 4, StopKindMaxMovesPerAlien},
+		{MaxTotalMoves{Moves: 5}, 3, StopKindMaxTotalMoves},
+		{WallClockLimit{Duration: 0}, 1, StopKindWallClock},
+		{NoDestructionFor{Iterations: 3}, 3, StopKindNoDestruction},
+		{MaxIterations{Iterations: 2}, 2, StopKindMaxIterations},
+		{
+			StopPredicate{
+				Description: "the aliens have made 4 moves",
+				Predicate:   func(status SimulationStatus) bool { return status.TotalMoves >= 4 },
+			},
+			2,
+			StopKindPredicate,
+		},
+	}
+	species, err := ParseSpecies(strings.NewReader("Drifter fights-own-kind=false\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	for _, test := range tests {
+		res, err := NewSimulation(
+			newTestSimulationConfig(strings.NewReader("A east=B\n"), 2).
+				WithSpawnCities([]string{"A"}).
+				WithSpecies(species...).
+				WithStopCriteria(test.criterion),
+		).Simulate()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if res.IterationsSimulated != test.iterationsSimulated || res.TotalMoves != 2*test.iterationsSimulated {
+			t.Error(
+				"Expected", test.criterion.Reason(), "after", test.iterationsSimulated, "iterations,",
+				"but got", res.IterationsSimulated, "iterations and", res.TotalMoves, "moves",
+			)
+		}
+		if res.StopReason != test.criterion.Reason() || res.StopKind != test.stopKind {
+			t.Errorf("Expected stop reason %q (%s), but got %q (%s)", test.criterion.Reason(), test.stopKind, res.StopReason, res.StopKind)
+		}
+	}
+}
+
+func TestBuiltInStopReasons(t *testing.T) {
+	tests := []struct {
+		worldMap   string
+		stopReason string
+		stopKind   string
+	}{
+		{"Foo\n", StopReasonAllAliensDead, StopKindAllAliensDead},
+		{"Foo east=Bar\n", StopReasonAliensStuck, StopKindAliensStuck},
+	}
+	for _, test := range tests {
+		res, err := NewSimulation(
+			newTestSimulationConfig(strings.NewReader(test.worldMap), 2).
+				WithStopCriteria(WallClockLimit{Duration: time.Hour}),
+		).Simulate()
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if res.StopReason != test.stopReason || res.StopKind != test.stopKind {
+			t.Errorf("For map %q, expected stop reason %q (%s), but got %q (%s)", test.worldMap, test.stopReason, test.stopKind, res.StopReason, res.StopKind)
+		}
+	}
+}
+
+// Tests that aliens destroying each other aren't mistaken for having made
+// enough moves.
+func TestMaxMovesPerAlienWhenAllAliensDie(t *testing.T) {
+	res, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("A east=B\n"), 2).
+			WithSpawnCities([]string{"A"}).
+			WithStopCriteria(MaxMovesPerAlien{Moves: 100}),
+	).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if res.StopReason != StopReasonAllAliensDead {
+		t.Errorf("Expected stop reason %q, but got %q", StopReasonAllAliensDead, res.StopReason)
+	}
+}
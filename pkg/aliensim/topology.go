@@ -0,0 +1,54 @@
+package aliensim
+
+import "fmt"
+
+// Topology determines in which directions cities can be linked to one another,
+// and therefore in which directions aliens can travel.
+type Topology int
+
+// The supported world map topologies.
+const (
+	// TopologyFourWay only allows for links to the North, East, South and
+	// West. This is the default topology.
+	TopologyFourWay Topology = 0
+	// TopologyEightWay additionally allows for diagonal links (NorthEast,
+	// SouthEast, SouthWest and NorthWest).
+	TopologyEightWay Topology = 1
+)
+
+var mapTopologies = map[string]Topology{
+	"four":  TopologyFourWay,
+	"eight": TopologyEightWay,
+}
+
+// ParseTopology converts the given topology name ("four" or "eight") into its
+// corresponding Topology value.
+func ParseTopology(name string) (Topology, error) {
+	t, ok := mapTopologies[name]
+	if !ok {
+		return TopologyFourWay, NewExtendedSimulationError(
+			ErrInvalidDirective,
+			fmt.Sprintf("Unknown topology \"%s\" (must be one of four or eight).", name),
+			nil,
+		)
+	}
+	return t, nil
+}
+
+// Allows checks whether the given direction index is valid for this topology.
+// Vertical links (Up and Down) are allowed regardless of the topology.
+func (t Topology) Allows(dir int) bool {
+	if dir < 0 || dir >= numDirections {
+		return false
+	}
+	return !directions[dir].diagonal || t == TopologyEightWay
+}
+
+func (t Topology) String() string {
+	for name, topology := range mapTopologies {
+		if topology == t {
+			return name
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,131 @@
+package aliensim
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// The ways in which an alien can die.
+const (
+	CauseCityDestroyed = "destroyed along with a city"
+	CauseRoadDestroyed = "destroyed along with a road"
+	CauseDefenders     = "killed by a city's defenders"
+)
+
+// PathStep records an alien's arrival in a city.
+type PathStep struct {
+	City      string `json:"city"`
+	Iteration int    `json:"iteration"` // The iteration during which the alien arrived in the city.
+}
+
+// Trajectory describes where a single alien went during a simulation, and how
+// it fared.
+type Trajectory struct {
+	AlienID      int        `json:"alien_id"`
+	Species      string     `json:"species"`
+	Path         []PathStep `json:"path"`          // The cities the alien has been in, starting with the one in which it arrived.
+	Distance     int        `json:"distance"`      // The total distance travelled by the alien.
+	BlockedTurns int        `json:"blocked_turns"` // The number of iterations the alien spent stuck in a city with energy to spare.
+	Alive        bool       `json:"alive"`
+	DiedAt       int        `json:"died_at"`                  // The iteration during which the alien died (-1 if it's still alive).
+	CauseOfDeath string     `json:"cause_of_death,omitempty"` // How the alien died, if it's dead.
+}
+
+// Path returns the cities this alien has been in, in order, if trajectories
+// were being recorded.
+func (a *Alien) Path() []PathStep {
+	return a.path
+}
+
+// DiedAt returns the iteration during which this alien died, or -1 if it's
+// still alive.
+func (a *Alien) DiedAt() int {
+	return a.diedAt
+}
+
+// CauseOfDeath describes how this alien died, or returns an empty string if
+// it's still alive.
+func (a *Alien) CauseOfDeath() string {
+	return a.causeOfDeath
+}
+
+// BlockedTurns returns the number of iterations this alien has spent in a city
+// without moving, despite having energy to spare.
+func (a *Alien) BlockedTurns() int {
+	return a.blockedTurns
+}
+
+// Trajectory describes where this alien has been so far, and how it has fared.
+func (a *Alien) Trajectory() Trajectory {
+	return Trajectory{
+		AlienID:      a.id,
+		Species:      a.species.Name,
+		Path:         a.path,
+		Distance:     a.distance,
+		BlockedTurns: a.blockedTurns,
+		Alive:        a.alive,
+		DiedAt:       a.diedAt,
+		CauseOfDeath: a.causeOfDeath,
+	}
+}
+
+// recordArrival adds the city the given alien is in to its path, if
+// trajectories are being recorded.
+func (s *Simulation) recordArrival(alien *Alien) {
+	if s.config.trajectories {
+		alien.path = append(alien.path, PathStep{City: alien.city.name, Iteration: s.iteration})
+	}
+}
+
+// kill records the death of the alien with the given ID, and how it died.
+func (s *Simulation) kill(alienID int, cause string) {
+	alien := s.aliens[alienID]
+	alien.alive = false
+	alien.diedAt = s.iteration
+	alien.causeOfDeath = cause
+}
+
+// WriteTrajectoriesCSV writes the given trajectories to the given writer as
+// CSV, with a header row followed by one row for each step along each alien's
+// path.
+func WriteTrajectoriesCSV(w io.Writer, trajectories []Trajectory) error {
+	out := csv.NewWriter(w)
+	err := out.Write([]string{
+		"alien_id", "species", "step", "city", "iteration",
+		"distance", "blocked_turns", "alive", "died_at", "cause_of_death",
+	})
+	if err != nil {
+		return err
+	}
+	for _, t := range trajectories {
+		for step, p := range t.Path {
+			err = out.Write([]string{
+				strconv.Itoa(t.AlienID),
+				t.Species,
+				strconv.Itoa(step),
+				p.City,
+				strconv.Itoa(p.Iteration),
+				strconv.Itoa(t.Distance),
+				strconv.Itoa(t.BlockedTurns),
+				strconv.FormatBool(t.Alive),
+				strconv.Itoa(t.DiedAt),
+				t.CauseOfDeath,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteTrajectoriesJSON writes the given trajectories to the given writer as a
+// JSON array.
+func WriteTrajectoriesJSON(w io.Writer, trajectories []Trajectory) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(trajectories)
+}
@@ -0,0 +1,111 @@
+package aliensim
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSimulationRecordsTrajectories(t *testing.T) {
+	species, err := ParseSpecies(strings.NewReader("Drifter fights-own-kind=false\n"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	res, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("A east=B\n"), 2).
+			WithSpawnCities([]string{"A"}).
+			WithSpecies(species...).
+			WithTrajectories(true),
+	).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if len(res.Trajectories) != 2 {
+		t.Fatal("Expected trajectories for 2 aliens, but got", res.Trajectories)
+	}
+	for id, trajectory := range res.Trajectories {
+		if trajectory.AlienID != id || !trajectory.Alive || trajectory.DiedAt != -1 || trajectory.Distance != 10 {
+			t.Error("Expected alien", id, "to have survived after travelling 10 roads, but got", trajectory)
+		}
+		// the aliens bounce back and forth between A and B until they run out
+		// of energy
+		if len(trajectory.Path) != 11 {
+			t.Fatal("Expected a path of 11 steps, but got", trajectory.Path)
+		}
+		for i, step := range trajectory.Path {
+			expected := PathStep{City: "A", Iteration: i - 1}
+			if i%2 == 1 {
+				expected.City = "B"
+			}
+			if i == 0 {
+				expected.Iteration = 0
+			}
+			if step != expected {
+				t.Error("Expected step", i, "to be", expected, "but got", step)
+			}
+		}
+	}
+}
+
+func TestSimulationRecordsCauseOfDeath(t *testing.T) {
+	res, err := NewSimulation(
+		newTestSimulationConfig(strings.NewReader("Foo\n"), 2).WithTrajectories(true),
+	).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	for _, trajectory := range res.Trajectories {
+		if trajectory.Alive || trajectory.DiedAt != 0 || trajectory.CauseOfDeath != CauseCityDestroyed {
+			t.Error("Expected alien to have been destroyed along with Foo, but got", trajectory)
+		}
+		if trajectory.BlockedTurns != 1 || len(trajectory.Path) != 1 || trajectory.Path[0].City != "Foo" {
+			t.Error("Expected alien to have been stuck in Foo, but got", trajectory)
+		}
+	}
+
+	res, err = NewSimulation(newTestSimulationConfig(strings.NewReader("Foo\n"), 2)).Simulate()
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if res.Trajectories != nil {
+		t.Error("Expected no trajectories unless requested, but got", res.Trajectories)
+	}
+}
+
+func TestWriteTrajectories(t *testing.T) {
+	trajectories := []Trajectory{
+		{
+			AlienID:      0,
+			Species:      "alien",
+			Path:         []PathStep{{"Foo", 0}, {"Bar", 1}},
+			Distance:     1,
+			BlockedTurns: 2,
+			DiedAt:       3,
+			CauseOfDeath: CauseDefenders,
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteTrajectoriesCSV(&buf, trajectories); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	expected := "alien_id,species,step,city,iteration,distance,blocked_turns,alive,died_at,cause_of_death\n" +
+		"0,alien,0,Foo,0,1,2,false,3,killed by a city's defenders\n" +
+		"0,alien,1,Bar,1,1,2,false,3,killed by a city's defenders\n"
+	if buf.String() != expected {
+		t.Errorf("Expected CSV:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteTrajectoriesJSON(&buf, trajectories); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	var decoded []Trajectory
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal("Expected valid JSON, but got", err)
+	}
+	if len(decoded) != 1 || decoded[0].CauseOfDeath != CauseDefenders || len(decoded[0].Path) != 2 ||
+		decoded[0].Path[1] != trajectories[0].Path[1] {
+		t.Error("Expected", trajectories, "but got", decoded)
+	}
+}
@@ -1,62 +1,160 @@
 package aliensim
 
 import (
-	"bufio"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 // WorldMap contains all of the data structures relevant to mapping out our
 // simulated world.
 type WorldMap struct {
-	cityNames   []string         // The names of the cities in the order read from the input.
-	cities      map[string]*City // A map of the cities read from the input (key=city name).
-	aliens      []*Alien         // A list of our aliens.
-	parsedLines uint64           // How many lines of the input have we parsed so far?
+	cities      []*City        // The cities in the order read from the input, indexed by their IDs.
+	cityIDs     map[string]int // The ID of each city (key=city name).
+	roads       []*Road        // All of the roads between the cities, in the order they were found.
+	aliens      []*Alien       // A list of our aliens.
+	dirty       []bool         // Have the links of each city (indexed by ID) changed since they were last inferred?
+	dirtyCities []*City        // The cities whose links have changed since they were last inferred.
+	parsedLines uint64         // How many lines of the input have we parsed so far?
+	topology    Topology       // Which directions cities can be linked in.
+	laidOut     bool           // Have the cities been given coordinates (see layOut)?
 }
 
 // NewEmptyWorldMap creates an empty world map, but initialises its structures
 // so data can easily be added to it.
 func NewEmptyWorldMap() *WorldMap {
 	return &WorldMap{
-		cityNames:   []string{},
-		cities:      map[string]*City{},
+		cities:      []*City{},
+		cityIDs:     map[string]int{},
+		dirty:       []bool{},
+		roads:       []*Road{},
 		aliens:      []*Alien{},
 		parsedLines: 0,
+		topology:    TopologyFourWay,
 	}
 }
 
 // ParseWorldMap takes the given input reader, scans it one line at a time,
 // attempts to parse each line, and produces a WorldMap data structure on
 // success, or an error on failure. A reader is used to allow for greater memory
-// efficiency when supplying larger input files.
+// efficiency when supplying larger input files. Use a MapParser to change how
+// the map is parsed.
 func ParseWorldMap(worldReader io.Reader) (*WorldMap, error) {
-	scanner := bufio.NewScanner(worldReader)
-	worldMap := NewEmptyWorldMap()
-	for scanner.Scan() {
-		line := scanner.Text()
-		// skip empty lines
-		if len(line) > 0 {
-			err := worldMap.ParseLine(line)
-			if err != nil {
-				return nil, err
+	return NewMapParser().Parse(worldReader)
+}
+
+// inferLinks makes sure the neighbours of each city whose links have changed
+// since this was last done know about each other, and builds any roads along
+// the links that were inferred rather than declared. Cities that are more than
+// a couple of links away from any changes are left alone, so this can be done
+// every so often while a map is being parsed.
+func (m *WorldMap) inferLinks() {
+	// each city's neighbours are inferred from its neighbours' neighbours, so
+	// the cities next to any changes need recomputing too
+	m.markNeighboursDirty()
+	m.sortDirtyCities()
+	for _, city := range m.dirtyCities {
+		city.recomputeNeighbours(m.topology)
+	}
+	// and recomputing a city's neighbours can link its neighbours to other
+	// cities, so their roads need looking at as well
+	m.markNeighboursDirty()
+	m.sortDirtyCities()
+	m.inferRoads()
+	for _, city := range m.dirtyCities {
+		m.dirty[city.id] = false
+	}
+	m.dirtyCities = m.dirtyCities[:0]
+}
+
+// markNeighboursDirty marks the neighbours of each city whose links have
+// changed as having changed too.
+func (m *WorldMap) markNeighboursDirty() {
+	dirty := m.dirtyCities
+	for _, city := range dirty {
+		for _, neighbour := range city.neighbours {
+			if neighbour != nil {
+				m.markDirty(neighbour)
 			}
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, NewExtendedSimulationError(ErrFailedToScanWorldInput, "", err)
+}
+
+// sortDirtyCities puts the cities whose links have changed in the order in
+// which they were read, so that their roads are numbered in that order.
+func (m *WorldMap) sortDirtyCities() {
+	sort.Slice(m.dirtyCities, func(i, j int) bool {
+		return m.dirtyCities[i].id < m.dirtyCities[j].id
+	})
+}
+
+// markDirty records that the links of the given cities have changed.
+func (m *WorldMap) markDirty(cities ...*City) {
+	for _, city := range cities {
+		if !m.dirty[city.id] {
+			m.dirty[city.id] = true
+			m.dirtyCities = append(m.dirtyCities, city)
+		}
+	}
+}
+
+// Cities returns the cities on this world map, in the order in which they were
+// first mentioned in the input map. The returned slice must not be modified.
+func (m *WorldMap) Cities() []*City {
+	return m.cities
+}
+
+// city returns the city with the given name, or nil if there is no such city on
+// this world map.
+func (m *WorldMap) city(name string) *City {
+	if id, ok := m.cityIDs[name]; ok {
+		return m.cities[id]
 	}
-	// now run through the map to ensure all the neighbours know about each other
-	for _, cityName := range worldMap.cityNames {
-		worldMap.cities[cityName].recomputeNeighbours()
+	return nil
+}
+
+// addCity returns the city with the given name, adding it to this world map if
+// it isn't there yet.
+func (m *WorldMap) addCity(name string) *City {
+	if city := m.city(name); city != nil {
+		return city
 	}
-	return worldMap, nil
+	// the name may be part of a much longer line of the input, which we don't
+	// want to hold on to
+	name = string([]byte(name))
+	city := NewCity(name)
+	city.id = len(m.cities)
+	m.cities = append(m.cities, city)
+	m.cityIDs[name] = city.id
+	m.dirty = append(m.dirty, false)
+	return city
+}
+
+// Roads returns all of the roads on this world map.
+func (m *WorldMap) Roads() []*Road {
+	return m.roads
+}
+
+// LaidOut checks whether the cities on this world map could be laid out on a
+// grid, and so have meaningful coordinates. Maps whose links don't fit on a
+// grid (e.g. ones that wrap around) can still be simulated, but can't be drawn.
+func (m *WorldMap) LaidOut() bool {
+	return m.laidOut
+}
+
+// Topology returns the topology of this world map.
+func (m *WorldMap) Topology() Topology {
+	return m.topology
 }
 
 // ParseLine parses a single line from a world map file. On success, updates the
 // world map. On failure, returns an error.
 func (m *WorldMap) ParseLine(line string) error {
+	if strings.HasPrefix(line, "@") {
+		return m.parseDirective(line)
+	}
 	parts := strings.Split(line, " ")
 	if len(parts) < 1 {
 		return NewExtendedSimulationError(
@@ -68,12 +166,32 @@ func (m *WorldMap) ParseLine(line string) error {
 			nil,
 		)
 	}
-	cityName := parts[0]
-	city, exists := m.cities[cityName]
-	if !exists {
-		city = NewCity(cityName)
-		m.cities[cityName] = city
-		m.cityNames = append(m.cityNames, cityName)
+	cityName, attributes, err := parseCityAttributes(parts[0])
+	if err == nil {
+		err = checkCityName(cityName)
+	}
+	if err != nil {
+		return NewExtendedSimulationError(
+			ErrFailedToParseLine,
+			fmt.Sprintf(
+				"Parsing error on line %d.",
+				m.parsedLines+1,
+			),
+			err,
+		)
+	}
+	city := m.addCity(cityName)
+	for _, attr := range attributes {
+		if err := city.setAttribute(attr[0], attr[1]); err != nil {
+			return NewExtendedSimulationError(
+				ErrFailedToParseLine,
+				fmt.Sprintf(
+					"Parsing error on line %d.",
+					m.parsedLines+1,
+				),
+				err,
+			)
+		}
 	}
 
 	for i := 1; i < len(parts); i++ {
@@ -82,22 +200,82 @@ func (m *WorldMap) ParseLine(line string) error {
 			return NewExtendedSimulationError(
 				ErrFailedToParseLine,
 				fmt.Sprintf(
-					"Invalid city location format on line %d (must be of the form \"direction=CityName\").",
+					"Invalid city location format on line %d (must be of the form \"direction=CityName\", optionally with \">=\" for one-way roads and \":length\" after the city name).",
 					m.parsedLines+1,
 				),
 				nil,
 			)
 		}
 		dir, otherCityName := strings.ToLower(dirParts[0]), dirParts[1]
-		otherCity, exists := m.cities[otherCityName]
-		if !exists {
-			otherCity = NewCity(otherCityName)
-			m.cities[otherCityName] = otherCity
-			m.cityNames = append(m.cityNames, otherCityName)
+		// a trailing ">" on the direction indicates a one-way road
+		oneWay := strings.HasSuffix(dir, ">")
+		dir = strings.TrimSuffix(dir, ">")
+		// a ":length" suffix on the city name gives the length of the road
+		roadLength := 0
+		if sep := strings.LastIndex(otherCityName, ":"); sep >= 0 && isDigits(otherCityName[sep+1:]) {
+			l, err := strconv.Atoi(otherCityName[sep+1:])
+			if err != nil || l < 1 {
+				return NewExtendedSimulationError(
+					ErrFailedToParseLine,
+					fmt.Sprintf(
+						"Parsing error on line %d.",
+						m.parsedLines+1,
+					),
+					NewExtendedSimulationError(
+						ErrInvalidRoadLength,
+						fmt.Sprintf(
+							"Road lengths must be positive whole numbers, but got \"%s\".",
+							otherCityName[sep+1:],
+						),
+						nil,
+					),
+				)
+			}
+			otherCityName, roadLength = otherCityName[:sep], l
+		}
+		if err := checkCityName(otherCityName); err != nil {
+			return NewExtendedSimulationError(
+				ErrFailedToParseLine,
+				fmt.Sprintf(
+					"Parsing error on line %d.",
+					m.parsedLines+1,
+				),
+				err,
+			)
 		}
+		if d, ok := mapDirections[dir]; ok && !m.topology.Allows(d) {
+			return NewExtendedSimulationError(
+				ErrFailedToParseLine,
+				fmt.Sprintf(
+					"Parsing error on line %d.",
+					m.parsedLines+1,
+				),
+				NewExtendedSimulationError(
+					ErrUnknownDirection,
+					fmt.Sprintf(
+						"Direction %s is not available on %s-way maps (add \"@topology=eight\" to the top of the map).",
+						dir,
+						m.topology,
+					),
+					nil,
+				),
+			)
+		}
+		otherCity := m.addCity(otherCityName)
 
 		// now we situate the other city relative to our current one
-		err := city.LocateRelativeTo(otherCity, dir)
+		var err error
+		if oneWay {
+			err = city.LocateOneWayRelativeTo(otherCity, dir)
+		} else {
+			err = city.LocateRelativeTo(otherCity, dir)
+		}
+		if err == nil && roadLength > 0 {
+			err = city.roads[mapDirections[dir]].setLength(roadLength)
+		}
+		if err == nil {
+			m.markDirty(city, otherCity)
+		}
 		if err != nil {
 			return NewExtendedSimulationError(
 				ErrFailedToParseLine,
@@ -113,18 +291,220 @@ func (m *WorldMap) ParseLine(line string) error {
 	return nil
 }
 
+// parseCityAttributes splits a city definition of the form
+// "CityName[key=value,flag]" into the city's name and its attributes, in the
+// order in which they were given. Attributes without values are given the value
+// "true".
+func parseCityAttributes(token string) (string, [][2]string, error) {
+	start := strings.Index(token, "[")
+	if start < 0 {
+		return token, nil, nil
+	}
+	if !strings.HasSuffix(token, "]") || start == 0 {
+		return "", nil, NewExtendedSimulationError(
+			ErrInvalidCityAttribute,
+			fmt.Sprintf(
+				"Invalid city definition \"%s\" (must be of the form \"CityName[key=value,...]\").",
+				token,
+			),
+			nil,
+		)
+	}
+	attributes := [][2]string{}
+	for _, attr := range strings.Split(token[start+1:len(token)-1], ",") {
+		if len(attr) == 0 {
+			continue
+		}
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "true")
+		}
+		attributes = append(attributes, [2]string{strings.ToLower(kv[0]), kv[1]})
+	}
+	return token[:start], attributes, nil
+}
+
+// checkCityName makes sure the given city name doesn't contain any of the
+// characters used to give road lengths and city attributes, so that maps with
+// such names are rejected rather than being read in a different way than was
+// intended.
+func checkCityName(name string) error {
+	if strings.ContainsAny(name, ":[]") {
+		return NewExtendedSimulationError(
+			ErrInvalidCityName,
+			fmt.Sprintf(
+				"City names can't contain \":\", \"[\" or \"]\", but got \"%s\" (use \":length\" after a neighbouring city's name for the length of the road to it, and \"[key=value,...]\" after a city's name at the start of a line for its attributes).",
+				name,
+			),
+			nil,
+		)
+	}
+	return nil
+}
+
+// isDigits checks whether the given string is made up of one or more decimal
+// digits.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// parseDirective handles map-wide settings, which take the form
+// "@setting=value" and must precede any city definitions.
+func (m *WorldMap) parseDirective(line string) error {
+	parts := strings.SplitN(strings.TrimPrefix(line, "@"), "=", 2)
+	if len(parts) != 2 {
+		return NewExtendedSimulationError(
+			ErrInvalidDirective,
+			fmt.Sprintf(
+				"Invalid directive on line %d (must be of the form \"@setting=value\").",
+				m.parsedLines+1,
+			),
+			nil,
+		)
+	}
+	if len(m.cities) > 0 {
+		return NewExtendedSimulationError(
+			ErrInvalidDirective,
+			fmt.Sprintf(
+				"Directive on line %d must appear before any city definitions.",
+				m.parsedLines+1,
+			),
+			nil,
+		)
+	}
+	setting, value := strings.ToLower(parts[0]), strings.ToLower(parts[1])
+	switch setting {
+	case "topology":
+		topology, err := ParseTopology(value)
+		if err != nil {
+			return err
+		}
+		m.topology = topology
+	default:
+		return NewExtendedSimulationError(
+			ErrInvalidDirective,
+			fmt.Sprintf("Unknown setting \"%s\" on line %d.", setting, m.parsedLines+1),
+			nil,
+		)
+	}
+	m.parsedLines++
+	return nil
+}
+
+// layOut assigns coordinates to the cities if their links allow them to be laid
+// out on a grid. Otherwise every city is left at the origin, and the map is
+// marked as not laid out rather than being rejected, since it can still be
+// simulated.
+func (m *WorldMap) layOut() {
+	m.laidOut = m.computeCoordinates() == nil
+	if !m.laidOut {
+		for _, city := range m.cities {
+			city.x, city.y, city.z = 0, 0, 0
+		}
+	}
+}
+
+// computeCoordinates walks through each group of connected cities, assigning
+// coordinates to each city relative to the first city in its group. Groups are
+// laid out side by side (from West to East) so that they don't overlap. Returns
+// an error if the links between the cities contradict each other.
+func (m *WorldMap) computeCoordinates() error {
+	placed := make([]bool, len(m.cities))
+	occupied := make(map[[3]int]*City, len(m.cities))
+	offsetX := 0
+	for _, start := range m.cities {
+		if placed[start.id] {
+			continue
+		}
+		start.x, start.y, start.z = 0, 0, 0
+		placed[start.id] = true
+		group := []*City{start}
+		minX, maxX := 0, 0
+		for i := 0; i < len(group); i++ {
+			city := group[i]
+			for dir, neighbour := range city.neighbours {
+				if neighbour == nil {
+					continue
+				}
+				x := city.x + directions[dir].dx
+				y := city.y + directions[dir].dy
+				z := city.z + directions[dir].dz
+				if placed[neighbour.id] {
+					if neighbour.x != x || neighbour.y != y || neighbour.z != z {
+						return NewExtendedSimulationError(
+							ErrInconsistentLayout,
+							fmt.Sprintf(
+								"City %s cannot be to the %s of %s, as it is already located elsewhere.",
+								neighbour.name,
+								directionNames[dir],
+								city.name,
+							),
+							nil,
+						)
+					}
+					continue
+				}
+				neighbour.x, neighbour.y, neighbour.z = x, y, z
+				placed[neighbour.id] = true
+				group = append(group, neighbour)
+				if x < minX {
+					minX = x
+				}
+				if x > maxX {
+					maxX = x
+				}
+			}
+		}
+		for _, city := range group {
+			city.x += offsetX - minX
+			loc := [3]int{city.x, city.y, city.z}
+			if other, exists := occupied[loc]; exists {
+				return NewExtendedSimulationError(
+					ErrInconsistentLayout,
+					fmt.Sprintf("Cities %s and %s would occupy the same location.", other.name, city.name),
+					nil,
+				)
+			}
+			occupied[loc] = city
+		}
+		offsetX += maxX - minX + 2
+	}
+	return nil
+}
+
 // Render will generate a mapping similar to the input map format, but only
-// containing cities that have not yet been destroyed.
+// containing cities and roads that have not yet been destroyed. One-way roads
+// are only rendered for the city from which they can be travelled, and each
+// city's defence is rendered as the defence it has left.
 func (m *WorldMap) Render() string {
 	var b strings.Builder
 
-	for _, cityName := range m.cityNames {
-		city := m.cities[cityName]
-		if !city.destroyed {
-			fmt.Fprintf(&b, "%s", cityName)
-			for dir, neighbour := range city.neighbours {
-				if neighbour != nil && !neighbour.destroyed {
-					fmt.Fprintf(&b, " %s=%s", strings.ToLower(directionNames[dir]), neighbour.name)
+	if m.topology != TopologyFourWay {
+		fmt.Fprintf(&b, "@topology=%s\n", m.topology)
+	}
+	for _, city := range m.cities {
+		if !city.Destroyed() {
+			fmt.Fprintf(&b, "%s%s", city.name, city.renderAttributes())
+			for dir, road := range city.roads {
+				if road == nil || !road.AllowsTravelFrom(city) {
+					continue
+				}
+				neighbour := road.Other(city)
+				if neighbour.Destroyed() {
+					continue
+				}
+				link := "="
+				if road.oneWay {
+					link = ">="
+				}
+				fmt.Fprintf(&b, " %s%s%s", strings.ToLower(directionNames[dir]), link, neighbour.name)
+				if road.length != DefaultRoadLength {
+					fmt.Fprintf(&b, ":%d", road.length)
 				}
 			}
 			fmt.Fprintf(&b, "\n")
@@ -9,6 +9,29 @@ import (
 const (
 	contradictoryTestMap string = `Foo north=Bar east=Baz south=Qu-ux
 Baz west=Bar
+`
+	eightWayTestMap string = `@topology=eight
+Foo north=Bar west=Baz south=Qu-ux
+Bar south=Foo west=Bee
+Qu-ux southeast=Zap
+`
+	diagonalOnFourWayTestMap string = `Foo north=Bar northwest=Bee
+`
+	multiLevelTestMap string = `Foo north=Bar down=Tunnel
+Tunnel east=Mine
+Mine up=Baz
+`
+	oneWayTestMap string = `Foo north>=Bar east=Baz
+Bar east>=Qux
+Qux south=Baz
+`
+	inconsistentLayoutTestMap string = `Foo east=Bar down=Tunnel
+Tunnel east=Mine
+Mine up=Baz
+`
+	wraparoundTestMap string = `A east=B
+B east=C
+C east=A
 `
 )
 
@@ -27,8 +50,8 @@ func TestSuccessfullyParsingExampleWorldMap(t *testing.T) {
 
 		for cityName, expectedNeighbours := range cityLinks {
 			// first make sure the city exists in the map
-			city, exists := m.cities[cityName]
-			if !exists {
+			city := m.city(cityName)
+			if city == nil {
 				t.Error(
 					"Expected city",
 					cityName,
@@ -101,3 +124,220 @@ func TestParsingContradictoryMap(t *testing.T) {
 		)
 	}
 }
+
+func TestParsingEightWayMap(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader(eightWayTestMap))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	if m.Topology() != TopologyEightWay {
+		t.Error("Expected eight-way topology, but got", m.Topology())
+	}
+	// diagonals inferred from the orthogonal links, plus one declared one
+	diagonals := []struct {
+		city, neighbour string
+		dir             int
+	}{
+		{"Foo", "Bee", DirNorthWest},
+		{"Bee", "Foo", DirSouthEast},
+		{"Baz", "Bar", DirNorthEast},
+		{"Bar", "Baz", DirSouthWest},
+		{"Qu-ux", "Baz", DirNorthWest},
+		{"Qu-ux", "Zap", DirSouthEast},
+		{"Zap", "Qu-ux", DirNorthWest},
+	}
+	for _, d := range diagonals {
+		neighbour := m.city(d.city).neighbours[d.dir]
+		if neighbour == nil || neighbour.name != d.neighbour {
+			t.Error(
+				"Expected", d.neighbour, "to the", directionNames[d.dir],
+				"of", d.city, "but got", neighbour,
+			)
+		}
+	}
+}
+
+func TestParsingDiagonalOnFourWayMap(t *testing.T) {
+	_, err := ParseWorldMap(strings.NewReader(diagonalOnFourWayTestMap))
+	serr, ok := err.(*SimulationError)
+	if !ok {
+		t.Fatal("Expected a simulation error, but got", err)
+	}
+	uerr, _ := serr.upstream.(*SimulationError)
+	if uerr == nil || uerr.kind != ErrUnknownDirection {
+		t.Error("Expected upstream error kind to be", ErrUnknownDirection, "but got", serr.upstream)
+	}
+}
+
+func TestParsingMultiLevelMap(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader(multiLevelTestMap))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	// the opposite direction must have been inferred
+	if up := m.city("Tunnel").neighbours[DirUp]; up == nil || up.name != "Foo" {
+		t.Error("Expected Foo to be above Tunnel, but got", up)
+	}
+	coords := map[string][3]int{
+		"Foo":    {0, 0, 0},
+		"Bar":    {0, 1, 0},
+		"Tunnel": {0, 0, -1},
+		"Mine":   {1, 0, -1},
+		"Baz":    {1, 0, 0},
+	}
+	for cityName, expected := range coords {
+		x, y, z := m.city(cityName).Coordinates()
+		if [3]int{x, y, z} != expected {
+			t.Error("Expected", cityName, "to be at", expected, "but was at", [3]int{x, y, z})
+		}
+	}
+	levels := m.Levels()
+	if len(levels) != 2 || levels[0] != 0 || levels[1] != -1 {
+		t.Error("Expected levels [0 -1], but got", levels)
+	}
+	expectedGrid := "Tunnel - Mine\n"
+	if grid := m.RenderGrid(-1); grid != expectedGrid {
+		t.Errorf("Expected level -1 to render as %q, but got %q", expectedGrid, grid)
+	}
+}
+
+func TestParsingInconsistentLayout(t *testing.T) {
+	for _, worldMap := range []string{inconsistentLayoutTestMap, wraparoundTestMap} {
+		// maps that don't fit on a grid are still accepted, just not laid out
+		m, err := ParseWorldMap(strings.NewReader(worldMap))
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if m.LaidOut() {
+			t.Errorf("Expected %q not to be laid out", worldMap)
+		}
+		if grid := m.RenderGrid(0); grid != "" {
+			t.Error("Expected no grid to be rendered, but got", grid)
+		}
+		err = m.computeCoordinates()
+		if serr, ok := err.(*SimulationError); !ok || serr.kind != ErrInconsistentLayout {
+			t.Error("Expected error kind to be", ErrInconsistentLayout, "but got", err)
+		}
+	}
+
+	res, err := NewSimulation(newTestSimulationConfig(strings.NewReader(wraparoundTestMap), 2)).Simulate()
+	if err != nil {
+		t.Fatal("Expected a map that wraps around to be simulated, but got", err)
+	}
+	if res.TotalMoves == 0 {
+		t.Error("Expected the aliens to move around the map, but got", res.TotalMoves, "moves")
+	}
+}
+
+func TestParsingOneWayRoads(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader(oneWayTestMap))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	foo, bar := m.city("Foo"), m.city("Bar")
+	road := foo.roads[DirNorth]
+	if road == nil || road != bar.roads[DirSouth] {
+		t.Fatal("Expected Foo and Bar to share a road, but got", road, "and", bar.roads[DirSouth])
+	}
+	if !road.OneWay() || !road.AllowsTravelFrom(foo) || road.AllowsTravelFrom(bar) {
+		t.Error("Expected a one-way road from Foo to Bar, but got", road)
+	}
+	// an alien in Bar can only leave towards Qux
+	alien := NewAlien(0, bar)
+	if !alien.MoveInRandomDirection(NewSequenceGenerator()) || alien.city.name != "Qux" {
+		t.Error("Expected alien to move from Bar to Qux, but it is in", alien.city.name)
+	}
+	alien = NewAlien(1, bar)
+	bar.roads[DirEast].to.state = CityDestroyed
+	if alien.MoveInRandomDirection(NewSequenceGenerator()) {
+		t.Error("Expected alien in Bar to be trapped, but it moved to", alien.city.name)
+	}
+	bar.roads[DirEast].to.state = CityIntact
+
+	expectedRender := `Foo north>=Bar east=Baz
+Bar east>=Qux
+Baz north=Qux west=Foo
+Qux south=Baz
+`
+	if render := m.Render(); render != expectedRender {
+		t.Errorf("Expected map to render as %q, but got %q", expectedRender, render)
+	}
+	expectedGrid := "Bar > Qux\n ^     |\nFoo - Baz\n"
+	if grid := m.RenderGrid(0); grid != expectedGrid {
+		t.Errorf("Expected grid to render as %q, but got %q", expectedGrid, grid)
+	}
+}
+
+func TestParsingRoadLengths(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader("Foo east=Bar:3 north=Baz\nBar west=Foo:3\n"))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	if l := m.city("Foo").roads[DirEast].Length(); l != 3 {
+		t.Error("Expected road from Foo to Bar to have length 3, but got", l)
+	}
+	if l := m.city("Foo").roads[DirNorth].Length(); l != DefaultRoadLength {
+		t.Error("Expected road from Foo to Baz to have the default length, but got", l)
+	}
+	if render := m.Render(); !strings.HasPrefix(render, "Foo north=Baz east=Bar:3\n") {
+		t.Error("Expected road length to be rendered, but got", render)
+	}
+
+	for _, invalid := range []string{"Foo east=Bar:0\n", "Foo east=Bar:3\nBar west=Foo:2\n"} {
+		_, err := ParseWorldMap(strings.NewReader(invalid))
+		serr, ok := err.(*SimulationError)
+		if !ok {
+			t.Error("Expected a simulation error for", invalid, "but got", err)
+			continue
+		}
+		if uerr, _ := serr.upstream.(*SimulationError); uerr == nil || uerr.kind != ErrInvalidRoadLength {
+			t.Error("Expected upstream error kind to be", ErrInvalidRoadLength, "but got", serr.upstream)
+		}
+	}
+}
+
+func TestParsingAmbiguousCityNames(t *testing.T) {
+	for _, invalid := range []string{"Foo east=Bar:x\n", "Foo east=Bar:3x\n", "Foo east=Bar[x]\n", "Foo:1 east=Bar\n", "Foo] east=Bar\n"} {
+		_, err := ParseWorldMap(strings.NewReader(invalid))
+		serr, ok := err.(*SimulationError)
+		if !ok || serr.kind != ErrFailedToParseLine {
+			t.Error("Expected a parsing error for", invalid, "but got", err)
+			continue
+		}
+		if uerr, _ := serr.upstream.(*SimulationError); uerr == nil || uerr.kind != ErrInvalidCityName {
+			t.Error("Expected upstream error kind to be", ErrInvalidCityName, "but got", serr.upstream)
+		}
+	}
+}
+
+func TestParsingCityAttributes(t *testing.T) {
+	m, err := ParseWorldMap(strings.NewReader("Foo[def=3,spawn] north=Bar\nBar[colour=red]\n"))
+	if err != nil {
+		t.Fatal("Parsing failed with error:", err)
+	}
+	if d := m.city("Foo").Defence(); d != 3 {
+		t.Error("Expected Foo to have defence 3, but got", d)
+	}
+	if v, ok := m.city("Foo").Attribute("spawn"); !ok || v != "true" {
+		t.Error("Expected Foo to have the spawn attribute, but got", v)
+	}
+	if v, _ := m.city("Bar").Attribute("colour"); v != "red" {
+		t.Error("Expected Bar to have the colour attribute, but got", v)
+	}
+	expectedRender := "Foo[def=3,spawn] north=Bar\nBar[colour=red] south=Foo\n"
+	if render := m.Render(); render != expectedRender {
+		t.Errorf("Expected map to render as %q, but got %q", expectedRender, render)
+	}
+
+	for _, invalid := range []string{"Foo[def=-1]\n", "Foo[def=x]\n", "[def=1]\n", "Foo[def=1\n"} {
+		_, err := ParseWorldMap(strings.NewReader(invalid))
+		serr, ok := err.(*SimulationError)
+		if !ok {
+			t.Error("Expected a simulation error for", invalid, "but got", err)
+			continue
+		}
+		if uerr, _ := serr.upstream.(*SimulationError); uerr == nil || uerr.kind != ErrInvalidCityAttribute {
+			t.Error("Expected upstream error kind to be", ErrInvalidCityAttribute, "but got", serr.upstream)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Package metrics collects metrics about simulations and reports them in the
+// Prometheus text exposition format, so that long-running servers and batch
+// jobs can be monitored.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metric is implemented by each kind of metric a registry can hold.
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds a set of metrics, so that they can be reported together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric // In the order in which they were registered.
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: []metric{}}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// Write writes out all of the metrics in the registry in the Prometheus text
+// exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP implements http.Handler, so that the registry can be scraped.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	if err := r.Write(w); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write metrics:", err)
+	}
+}
+
+// Counter is a value that only ever goes up, optionally broken down by the
+// values of one or more labels.
+type Counter struct {
+	name       string
+	help       string
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]float64 // The value for each combination of label values (key=the encoded labels).
+}
+
+// NewCounter registers a new counter broken down by the given labels, if any.
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{name: name, help: help, labelNames: labelNames, values: map[string]float64{}}
+	if len(labelNames) == 0 {
+		// unlabelled counters are reported even before they're incremented
+		c.values[""] = 0
+	}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the given (non-negative) amount to the counter for the given label
+// values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if len(labelValues) != len(c.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, but got %d", c.name, len(c.labelNames), len(labelValues)))
+	}
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: %s cannot be decreased", c.name))
+	}
+	labels := encodeLabels(c.labelNames, labelValues)
+	c.mu.Lock()
+	c.values[labels] += v
+	c.mu.Unlock()
+}
+
+// Value returns the counter's value for the given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[encodeLabels(c.labelNames, labelValues)]
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	labels := make([]string, 0, len(c.values))
+	for l := range c.values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		writeSample(w, c.name, l, c.values[l])
+	}
+}
+
+// Histogram counts observed values in buckets, as well as keeping track of
+// their sum.
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64 // The upper bounds of the buckets, in increasing order.
+
+	mu     sync.Mutex
+	counts []uint64 // The number of observations in each bucket (not cumulative), with one more for +Inf.
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a new histogram with the given bucket upper bounds,
+// which must be in increasing order. A bucket for +Inf is always added.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			panic(fmt.Sprintf("metrics: the buckets of %s must be in increasing order", name))
+		}
+	}
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+	r.register(h)
+	return h
+}
+
+// ExponentialBuckets returns count bucket upper bounds, starting at start and
+// each one factor times the previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Observe records the given value.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// Count returns the number of values observed so far.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, count := range h.counts {
+		cumulative += count
+		le := math.Inf(1)
+		if i < len(h.buckets) {
+			le = h.buckets[i]
+		}
+		writeSample(w, h.name+"_bucket", encodeLabels([]string{"le"}, []string{formatValue(le)}), float64(cumulative))
+	}
+	writeSample(w, h.name+"_sum", "", h.sum)
+	writeSample(w, h.name+"_count", "", float64(h.count))
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	help = strings.Replace(strings.Replace(help, `\`, `\\`, -1), "\n", `\n`, -1)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	if len(labels) > 0 {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatValue(v))
+	} else {
+		fmt.Fprintf(w, "%s %s\n", name, formatValue(v))
+	}
+}
+
+// encodeLabels renders the given label names and values as they appear
+// between the braces of a sample.
+func encodeLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(values[i]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func escapeLabelValue(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	return strings.Replace(v, "\n", `\n`, -1)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	total := r.NewCounter("test_total", "A test counter.")
+	byKind := r.NewCounter("test_by_kind_total", "A test counter\nwith labels.", "kind")
+	h := r.NewHistogram("test_seconds", "A test histogram.", []float64{0.5, 1})
+	total.Inc()
+	total.Add(2)
+	byKind.Inc(`say "hi"`)
+	byKind.Inc("a")
+	byKind.Inc("a")
+	for _, v := range []float64{0.25, 0.5, 0.75, 2} {
+		h.Observe(v)
+	}
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	expected := `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total 3
+# HELP test_by_kind_total A test counter\nwith labels.
+# TYPE test_by_kind_total counter
+test_by_kind_total{kind="a"} 2
+test_by_kind_total{kind="say \"hi\""} 1
+# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.5"} 2
+test_seconds_bucket{le="1"} 3
+test_seconds_bucket{le="+Inf"} 4
+test_seconds_sum 3.5
+test_seconds_count 4
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	buckets := ExponentialBuckets(1, 10, 3)
+	if len(buckets) != 3 || buckets[0] != 1 || buckets[1] != 10 || buckets[2] != 100 {
+		t.Error("Expected [1 10 100], but got", buckets)
+	}
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+)
+
+// SimulationMetrics records metrics about the simulations it's notified of. It
+// implements aliensim.Instrumentation, and can be shared between any number of
+// simulations.
+type SimulationMetrics struct {
+	Registry *Registry
+
+	started      *Counter
+	completed    *Counter
+	failed       *Counter
+	stopReasons  *Counter
+	parseErrors  *Counter
+	iterations   *Histogram
+	parseLatency *Histogram
+	simLatency   *Histogram
+}
+
+// NewSimulationMetrics registers the simulation metrics with a new registry.
+func NewSimulationMetrics() *SimulationMetrics {
+	r := NewRegistry()
+	return &SimulationMetrics{
+		Registry: r,
+		started: r.NewCounter(
+			"alien_invasion_simulations_started_total",
+			"The number of simulations started.",
+		),
+		completed: r.NewCounter(
+			"alien_invasion_simulations_completed_total",
+			"The number of simulations run to completion.",
+		),
+		failed: r.NewCounter(
+			"alien_invasion_simulations_failed_total",
+			"The number of simulations that could not be run to completion.",
+		),
+		stopReasons: r.NewCounter(
+			"alien_invasion_simulations_by_stop_reason_total",
+			"The number of simulations completed, by the kind of reason for which they stopped.",
+			"reason",
+		),
+		parseErrors: r.NewCounter(
+			"alien_invasion_parse_errors_total",
+			"The number of world maps that could not be parsed, by SimulationErrorCode.",
+			"code",
+		),
+		iterations: r.NewHistogram(
+			"alien_invasion_simulation_iterations",
+			"The number of iterations simulated by each completed simulation.",
+			ExponentialBuckets(1, 10, 5),
+		),
+		parseLatency: r.NewHistogram(
+			"alien_invasion_parse_duration_seconds",
+			"How long it took to parse each world map.",
+			ExponentialBuckets(0.0001, 10, 6),
+		),
+		simLatency: r.NewHistogram(
+			"alien_invasion_simulate_duration_seconds",
+			"How long it took to simulate each completed simulation, once its world map had been parsed.",
+			ExponentialBuckets(0.001, 10, 6),
+		),
+	}
+}
+
+// SimulationStarted implements aliensim.Instrumentation.
+func (m *SimulationMetrics) SimulationStarted() {
+	m.started.Inc()
+}
+
+// WorldMapParsed implements aliensim.Instrumentation.
+func (m *SimulationMetrics) WorldMapParsed(duration time.Duration, err error) {
+	m.parseLatency.Observe(duration.Seconds())
+	if err != nil {
+		code := "unknown"
+		if serr, ok := err.(*aliensim.SimulationError); ok {
+			code = fmt.Sprintf("%d", serr.Code())
+		}
+		m.parseErrors.Inc(code)
+	}
+}
+
+// SimulationFailed implements aliensim.Instrumentation.
+func (m *SimulationMetrics) SimulationFailed(err error) {
+	m.failed.Inc()
+}
+
+// SimulationFinished implements aliensim.Instrumentation.
+func (m *SimulationMetrics) SimulationFinished(result *aliensim.SimulationResult, duration time.Duration) {
+	m.completed.Inc()
+	m.stopReasons.Inc(result.StopKind)
+	m.iterations.Observe(float64(result.IterationsSimulated))
+	m.simLatency.Observe(duration.Seconds())
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+)
+
+func TestSimulationMetrics(t *testing.T) {
+	m := NewSimulationMetrics()
+	for _, worldMap := range []string{"A east=B\n", "A east=B\nB east=C\n", "A sideways=B\n"} {
+		aliensim.NewSimulation(
+			aliensim.NewSimulationConfig(strings.NewReader(worldMap), 2).
+				WithRandomGenerator(aliensim.NewSeededGenerator(1)).
+				WithProgressHandler(nil).
+				WithInstrumentation(m),
+		).Simulate()
+	}
+	if m.started.Value() != 3 || m.completed.Value() != 2 || m.failed.Value() != 1 {
+		t.Error(
+			"Expected 3 simulations to have started, 2 to have completed and 1 to have failed, but got",
+			m.started.Value(), m.completed.Value(), m.failed.Value(),
+		)
+	}
+	if m.parseErrors.Value("2") != 1 {
+		t.Error("Expected a single ErrFailedToParseLine error, but got", m.parseErrors.Value("2"))
+	}
+	if m.parseLatency.Count() != 3 || m.iterations.Count() != 2 || m.simLatency.Count() != 2 {
+		t.Error("Expected each parse and simulation to have been timed")
+	}
+
+	var buf bytes.Buffer
+	if err := m.Registry.Write(&buf); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	for _, line := range []string{
+		"alien_invasion_simulations_started_total 3\n",
+		"alien_invasion_parse_errors_total{code=\"2\"} 1\n",
+		"alien_invasion_simulation_iterations_count 2\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected the metrics to include %q, but got:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestStopReasonsAreLabelledByKind(t *testing.T) {
+	m := NewSimulationMetrics()
+	for _, description := range []string{"the first predicate held", "the second predicate held"} {
+		aliensim.NewSimulation(
+			aliensim.NewSimulationConfig(strings.NewReader("A east=B\nB east=C\nC east=D\n"), 2).
+				WithRandomGenerator(aliensim.NewSeededGenerator(1)).
+				WithProgressHandler(nil).
+				WithStopCriteria(aliensim.StopPredicate{
+					Description: description,
+					Predicate:   func(status aliensim.SimulationStatus) bool { return true },
+				}).
+				WithInstrumentation(m),
+		).Simulate()
+	}
+	if m.stopReasons.Value(aliensim.StopKindPredicate) != 2 {
+		t.Error("Expected both simulations to be counted as stopped by a predicate, but got", m.stopReasons.Value(aliensim.StopKindPredicate))
+	}
+}
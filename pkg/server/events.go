@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+)
+
+// Event is something that happened during a run, as streamed to clients.
+type Event struct {
+	ID        int                    `json:"id"`
+	Type      string                 `json:"type"`
+	Iteration int                    `json:"iteration"` // The iteration during which the event happened.
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// runHandler records the events emitted by a run's simulation.
+type runHandler struct {
+	run *Run
+}
+
+// emit records an event of the given type, and wakes up anyone waiting for it.
+func (h *runHandler) emit(eventType string, data map[string]interface{}) {
+	r := h.run
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) >= MaxRunEvents {
+		// forget the oldest half of the events, copying the rest so that the
+		// memory taken up by the forgotten ones can be freed
+		forgotten := len(r.events) / 2
+		r.events = append([]Event(nil), r.events[forgotten:]...)
+		r.first += forgotten
+	}
+	r.events = append(r.events, Event{
+		ID:        r.first + len(r.events),
+		Type:      eventType,
+		Iteration: r.status.Iteration + 1,
+		Data:      data,
+	})
+	r.notify()
+}
+
+func (h *runHandler) CityDestroyed(cityName string, alienIDs []int) {
+	h.emit("city_destroyed", map[string]interface{}{"city": cityName, "aliens": alienIDs})
+}
+
+func (h *runHandler) RoadDestroyed(fromCity, toCity string, alienIDs []int) {
+	h.emit("road_destroyed", map[string]interface{}{"from": fromCity, "to": toCity, "aliens": alienIDs})
+}
+
+func (h *runHandler) AttackRepelled(cityName string, alienIDs []int, defenceLeft int) {
+	h.emit("attack_repelled", map[string]interface{}{"city": cityName, "aliens": alienIDs, "defence_left": defenceLeft})
+}
+
+func (h *runHandler) AlienKilledByDefenders(cityName string, alienID int) {
+	h.emit("alien_killed_by_defenders", map[string]interface{}{"city": cityName, "alien": alienID})
+}
+
+func (h *runHandler) CityRebuilt(cityName string) {
+	h.emit("city_rebuilt", map[string]interface{}{"city": cityName})
+}
+
+func (h *runHandler) ReinforcementsArrived(alienIDs []int) {
+	h.emit("reinforcements_arrived", map[string]interface{}{"aliens": alienIDs})
+}
+
+func (h *runHandler) FightWon(cityName string, alienID int, speciesName string) {
+	h.emit("fight_won", map[string]interface{}{"city": cityName, "alien": alienID, "species": speciesName})
+}
+
+func (h *runHandler) AlienBorn(alienID, parentID int, cityName string) {
+	h.emit("alien_born", map[string]interface{}{"alien": alienID, "parent": parentID, "city": cityName})
+}
+
+func (h *runHandler) SpeciesEliminated(speciesName string) {
+	h.emit("species_eliminated", map[string]interface{}{"species": speciesName})
+}
+
+// IterationCompleted keeps track of the run's progress, as well as recording
+// the event.
+func (h *runHandler) IterationCompleted(status aliensim.SimulationStatus) {
+	h.emit("iteration_completed", map[string]interface{}{
+		"aliens_alive":    status.AliensAlive,
+		"cities_standing": status.CitiesStanding,
+		"moves":           status.Moves,
+		"collisions":      status.Collisions,
+	})
+	h.run.mu.Lock()
+	h.run.status = status
+	h.run.mu.Unlock()
+}
+
+func (h *runHandler) AllAliensTrapped() {
+	h.emit("all_aliens_trapped", nil)
+}
+
+func (h *runHandler) AllAliensExhausted() {
+	h.emit("all_aliens_exhausted", nil)
+}
+
+func (h *runHandler) AllAliensDead() {
+	h.emit("all_aliens_dead", nil)
+}
+
+// eventsFrom returns the run's events from the one with the given ID onwards,
+// skipping any events the run has since forgotten. The caller must hold the
+// run's lock.
+func (r *Run) eventsFrom(id int) []Event {
+	if id < r.first {
+		id = r.first
+	}
+	if id-r.first >= len(r.events) {
+		return nil
+	}
+	return r.events[id-r.first:]
+}
+
+// streamEvents sends the given run's events to the client as Server-Sent
+// Events, as they happen, followed by a final "done" event describing the run
+// once it has finished. Clients reconnecting with a Last-Event-ID header only
+// receive the events that followed that one.
+func streamEvents(w http.ResponseWriter, req *http.Request, run *Run) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming is not supported.")
+		return
+	}
+	next := 0
+	if lastID, err := strconv.Atoi(req.Header.Get("Last-Event-ID")); err == nil {
+		next = lastID + 1
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for {
+		run.mu.Lock()
+		events := run.eventsFrom(next)
+		done, changed := run.done(), run.changed
+		run.mu.Unlock()
+
+		for _, event := range events {
+			if err := writeEvent(w, strconv.Itoa(event.ID), event.Type, event); err != nil {
+				return
+			}
+			next = event.ID + 1
+		}
+		if done {
+			writeEvent(w, "", "done", run.Info())
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-changed:
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes a single Server-Sent Event with the given ID (if any), type
+// and JSON-encoded data.
+func writeEvent(w http.ResponseWriter, id, eventType string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if len(id) > 0 {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, encoded)
+	return err
+}
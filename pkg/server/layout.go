@@ -0,0 +1,93 @@
+package server
+
+import (
+	"math"
+
+	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+)
+
+// CityLayout places a city on the map.
+type CityLayout struct {
+	Name string `json:"name"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"` // Increases towards the North.
+	Z    int    `json:"z"` // The level on which the city is.
+}
+
+// RoadLayout describes a road between two cities.
+type RoadLayout struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	OneWay bool   `json:"one_way,omitempty"`
+}
+
+// MapLayout describes a world map, so that it can be drawn.
+type MapLayout struct {
+	Cities []CityLayout `json:"cities"`
+	Roads  []RoadLayout `json:"roads"`
+}
+
+// AlienPosition describes where a living alien is.
+type AlienPosition struct {
+	ID      int    `json:"id"`
+	Species string `json:"species"`
+	City    string `json:"city"`         // The city the alien is in, or last left if it's on a road.
+	To      string `json:"to,omitempty"` // The city the alien is travelling to, if it's on a road.
+}
+
+// WorldState describes the state of a world map at the end of an iteration.
+type WorldState struct {
+	Iteration      int             `json:"iteration"`       // The last iteration simulated (-1 if none have been yet).
+	CityStates     []string        `json:"city_states"`     // The state of each city, in the same order as the map layout's cities.
+	DestroyedRoads []int           `json:"destroyed_roads"` // The indices of the destroyed roads in the map layout's roads.
+	Aliens         []AlienPosition `json:"aliens"`          // The living aliens.
+}
+
+func newMapLayout(worldMap *aliensim.WorldMap) *MapLayout {
+	layout := &MapLayout{Cities: []CityLayout{}, Roads: []RoadLayout{}}
+	// maps that don't fit on a grid are drawn with their cities in rows instead
+	width := int(math.Ceil(math.Sqrt(float64(len(worldMap.Cities())))))
+	for i, city := range worldMap.Cities() {
+		x, y, z := city.Coordinates()
+		if !worldMap.LaidOut() {
+			x, y = i%width, -(i / width)
+		}
+		layout.Cities = append(layout.Cities, CityLayout{Name: city.Name(), X: x, Y: y, Z: z})
+	}
+	for _, road := range worldMap.Roads() {
+		layout.Roads = append(layout.Roads, RoadLayout{
+			From:   road.From().Name(),
+			To:     road.To().Name(),
+			OneWay: road.OneWay(),
+		})
+	}
+	return layout
+}
+
+func newWorldState(iteration int, sim *aliensim.Simulation) *WorldState {
+	state := &WorldState{
+		Iteration:      iteration,
+		CityStates:     []string{},
+		DestroyedRoads: []int{},
+		Aliens:         []AlienPosition{},
+	}
+	for _, city := range sim.WorldMap().Cities() {
+		state.CityStates = append(state.CityStates, city.State().String())
+	}
+	for i, road := range sim.WorldMap().Roads() {
+		if road.Destroyed() {
+			state.DestroyedRoads = append(state.DestroyedRoads, i)
+		}
+	}
+	for _, alien := range sim.Aliens() {
+		if !alien.Alive() {
+			continue
+		}
+		position := AlienPosition{ID: alien.ID(), Species: alien.Species().Name, City: alien.City().Name()}
+		if road := alien.Road(); road != nil {
+			position.To = road.Other(alien.City()).Name()
+		}
+		state.Aliens = append(state.Aliens, position)
+	}
+	return state
+}
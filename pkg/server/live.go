@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// liveMessage is sent to clients following a run live over a WebSocket. Only
+// the field corresponding to the message's type is set.
+type liveMessage struct {
+	Type   string      `json:"type"` // One of "run", "layout", "state", "event" or "error".
+	Run    *RunInfo    `json:"run,omitempty"`
+	Layout *MapLayout  `json:"layout,omitempty"`
+	State  *WorldState `json:"state,omitempty"`
+	Event  *Event      `json:"event,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// liveCommand is sent by clients following a run live to control it.
+type liveCommand struct {
+	Command string `json:"command"` // One of "pause", "resume", "step" or "cancel".
+}
+
+// controlRun applies the given command ("pause", "resume", "step" or "cancel")
+// to the given run.
+func controlRun(run *Run, command string) error {
+	switch command {
+	case "pause":
+		return run.Pause()
+	case "resume":
+		return run.Resume()
+	case "step":
+		return run.Step()
+	case "cancel":
+		return run.Cancel()
+	}
+	return fmt.Errorf("Unknown command \"%s\" (must be one of pause, resume, step or cancel).", command)
+}
+
+// streamLive follows the given run over a WebSocket. The client is sent the
+// run's description whenever its state changes, the layout of its world map,
+// each of its events and the state of its world map after each iteration from
+// the one after it connected, and can pause, step through, resume and cancel
+// the run by sending commands. The connection is closed once the run has
+// finished.
+func streamLive(w http.ResponseWriter, req *http.Request, run *Run) {
+	conn, err := upgradeWebSocket(w, req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer conn.Close()
+	run.watch()
+	defer run.unwatch()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			message, err := conn.readMessage()
+			if err != nil {
+				return
+			}
+			var cmd liveCommand
+			if err := json.Unmarshal(message, &cmd); err != nil {
+				conn.writeJSON(liveMessage{Type: "error", Error: fmt.Sprintf("Invalid command: %s", err)})
+				continue
+			}
+			if err := controlRun(run, cmd.Command); err != nil {
+				conn.writeJSON(liveMessage{Type: "error", Error: err.Error()})
+			}
+		}
+	}()
+
+	next := 0
+	var lastInfo *RunInfo
+	var lastWorld *WorldState
+	layoutSent := false
+	for {
+		info := run.Info()
+		run.mu.Lock()
+		events := run.eventsFrom(next)
+		layout, world, done, changed := run.layout, run.world, run.done(), run.changed
+		run.mu.Unlock()
+
+		messages := []liveMessage{}
+		if lastInfo == nil || info.State != lastInfo.State || info.Paused != lastInfo.Paused {
+			messages = append(messages, liveMessage{Type: "run", Run: &info})
+			lastInfo = &info
+		}
+		if layout != nil && !layoutSent {
+			messages = append(messages, liveMessage{Type: "layout", Layout: layout})
+			layoutSent = true
+		}
+		for i := range events {
+			messages = append(messages, liveMessage{Type: "event", Event: &events[i]})
+		}
+		if len(events) > 0 {
+			next = events[len(events)-1].ID + 1
+		}
+		if world != nil && world != lastWorld {
+			messages = append(messages, liveMessage{Type: "state", State: world})
+			lastWorld = world
+		}
+		for _, message := range messages {
+			if err := conn.writeJSON(message); err != nil {
+				return
+			}
+		}
+		if done {
+			conn.writeFrame(wsClose, nil)
+			return
+		}
+		select {
+		case <-changed:
+		case <-closed:
+			return
+		}
+	}
+}
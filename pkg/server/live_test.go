@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWSClient is a bare-bones WebSocket client for testing purposes.
+type testWSClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialTestWS(t *testing.T, ts *httptest.Server, path string) *testWSClient {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	// the key and accept values are the ones from the example in RFC 6455
+	_, err = io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatal("Expected the WebSocket handshake to be accepted, but got", resp.Status, resp.Header)
+	}
+	return &testWSClient{conn: conn, r: r}
+}
+
+// send sends the given text message, masked as clients are required to do.
+func (c *testWSClient) send(t *testing.T, message string) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | wsText, 0x80 | byte(len(message))}
+	frame = append(frame, mask...)
+	for i := 0; i < len(message); i++ {
+		frame = append(frame, message[i]^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+}
+
+// receive reads the next message from the server, returning nil once the
+// server closes the connection.
+func (c *testWSClient) receive(t *testing.T) *liveMessage {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	length := int(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.r, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.r, ext[:])
+		length = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	if header[0]&0x0F == wsClose {
+		return nil
+	}
+	var message liveMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		t.Fatal("Expected a JSON message, but got", err)
+	}
+	return &message
+}
+
+// receiveUntil reads messages from the server until one matches the given
+// condition.
+func (c *testWSClient) receiveUntil(t *testing.T, condition func(m *liveMessage) bool) *liveMessage {
+	for {
+		m := c.receive(t)
+		if m == nil {
+			t.Fatal("Expected the connection to remain open")
+		}
+		if condition(m) {
+			return m
+		}
+	}
+}
+
+func TestFollowingRunLive(t *testing.T) {
+	ts := httptest.NewServer(New(1, 10))
+	defer ts.Close()
+
+	id := startTestRun(t, ts, `{"map_text": "A east=B\nB east=C\n", "aliens": 2, "seed": 1, "paused": true}`)
+	client := dialTestWS(t, ts, "/runs/"+id+"/live")
+	defer client.conn.Close()
+
+	m := client.receiveUntil(t, func(m *liveMessage) bool { return m.Type == "layout" })
+	if len(m.Layout.Cities) != 3 || len(m.Layout.Roads) != 2 || m.Layout.Cities[2].X != 2 {
+		t.Error("Expected the layout of the map, but got", m.Layout)
+	}
+	m = client.receiveUntil(t, func(m *liveMessage) bool { return m.Type == "state" })
+	if m.State.Iteration != -1 || len(m.State.Aliens) != 2 {
+		t.Error("Expected the initial state of the map, but got", m.State)
+	}
+
+	// the run stays paused until we ask it to take a step
+	client.send(t, `{"command": "step"}`)
+	m = client.receiveUntil(t, func(m *liveMessage) bool { return m.Type == "state" })
+	if m.State.Iteration != 0 {
+		t.Error("Expected the state after the first iteration, but got", m.State)
+	}
+	if info := ts.Config.Handler.(*Server).Run(id).Info(); !info.Paused || info.Iteration != 0 {
+		t.Error("Expected the run to be paused after a single iteration, but got", info)
+	}
+
+	client.send(t, `{"command": "jump"}`)
+	m = client.receiveUntil(t, func(m *liveMessage) bool { return m.Type == "error" })
+	if !strings.Contains(m.Error, "jump") {
+		t.Error("Expected an error about the unknown command, but got", m.Error)
+	}
+
+	client.send(t, `{"command": "resume"}`)
+	for m = client.receive(t); m != nil; m = client.receive(t) {
+		if m.Type == "run" && m.Run.State == RunFinished {
+			return
+		}
+	}
+	t.Error("Expected to be told that the run has finished before the connection closed")
+}
+
+func TestOnlyWatchedRunsCaptureTheirWorld(t *testing.T) {
+	srv := New(1, 10)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	id := startTestRun(t, ts, `{"map_text": "A east=B\nB east=C\n", "aliens": 2, "seed": 1}`)
+	waitForTestRun(t, ts, id)
+	run := srv.Run(id)
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.layout != nil || run.world != nil {
+		t.Error("Expected a run nobody followed live not to have captured its world map, but got", run.world)
+	}
+}
+
+func TestControllingRunOverHTTP(t *testing.T) {
+	ts := httptest.NewServer(New(1, 10))
+	defer ts.Close()
+
+	id := startTestRun(t, ts, `{"map_text": "A east=B\nB east=C\n", "aliens": 2, "paused": true}`)
+	resp, err := http.Post(ts.URL+"/runs/"+id+"/resume", "application/json", nil)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Expected status 200, but got", resp.StatusCode)
+	}
+	waitForTestRun(t, ts, id)
+
+	resp, err = http.Post(ts.URL+"/runs/"+id+"/pause", "application/json", nil)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Error("Expected a finished run not to be pausable, but got status", resp.StatusCode)
+	}
+}
+
+// controlTestRun applies the given action (e.g. "pause") to the run with the
+// given ID on the given test server, returning the response's status code.
+func controlTestRun(t *testing.T, ts *httptest.Server, id, action string) int {
+	resp, err := http.Post(ts.URL+"/runs/"+id+"/"+action, "application/json", nil)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestPausedRunsDontHoldOnToWorkers(t *testing.T) {
+	srv := New(1, 10)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	paused := startTestRun(t, ts, `{"map_text": "A east=B\nB east=C\n", "aliens": 2, "seed": 1, "paused": true}`)
+	// with only a single worker, this run can only go ahead once the paused
+	// one has let go of the worker
+	waitForTestRun(t, ts, startTestRun(t, ts, `{"map_text": "A east=B\nB east=C\n", "aliens": 2, "seed": 1}`))
+	if info := srv.Run(paused).Info(); info.State != RunRunning || !info.Paused || info.Iteration != -1 {
+		t.Fatal("Expected the paused run not to have simulated any iterations yet, but got", info)
+	}
+
+	if status := controlTestRun(t, ts, paused, "step"); status != http.StatusOK {
+		t.Fatal("Expected status 200, but got", status)
+	}
+	for deadline := time.Now().Add(10 * time.Second); srv.Run(paused).Info().Iteration != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the paused run to take a step")
+		}
+	}
+	if status := controlTestRun(t, ts, paused, "resume"); status != http.StatusOK {
+		t.Fatal("Expected status 200, but got", status)
+	}
+	if res := waitForTestRun(t, ts, paused); res.Cancelled || len(res.StopReason) == 0 {
+		t.Error("Expected the resumed run to finish, but got", res)
+	}
+}
+
+func TestCancellingRuns(t *testing.T) {
+	srv := New(1, 10)
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	for _, cfg := range []string{
+		`{"map_text": "A east=B\nB east=C\n", "aliens": 2, "paused": true}`,
+		`{"map_text": "A east=B\nB east=C\n", "aliens": 2, "step_delay_ms": 10000}`,
+	} {
+		id := startTestRun(t, ts, cfg)
+		for deadline := time.Now().Add(10 * time.Second); srv.Run(id).Info().State != RunRunning; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for run", id, "to start")
+			}
+		}
+		if status := controlTestRun(t, ts, id, "cancel"); status != http.StatusOK {
+			t.Fatal("Expected status 200, but got", status)
+		}
+		if res := waitForTestRun(t, ts, id); !res.Cancelled {
+			t.Error("Expected run", id, "to have been cancelled, but got", res)
+		}
+		if info := srv.Run(id).Info(); info.State != RunCancelled {
+			t.Error("Expected run", id, "to be cancelled, but got", info)
+		}
+		if status := controlTestRun(t, ts, id, "cancel"); status != http.StatusConflict {
+			t.Error("Expected a cancelled run not to be cancelled again, but got status", status)
+		}
+	}
+
+	id := startTestRun(t, ts, `{"map_text": "A east=B\nB east=C\n", "aliens": 2, "paused": true}`)
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/runs/"+id, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Error("Expected status 204, but got", resp.StatusCode)
+	}
+	if resp, err = http.Get(ts.URL + "/runs/" + id); err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Error("Expected a deleted run to be gone, but got status", resp.StatusCode)
+	}
+}
+
+func TestServingViewer(t *testing.T) {
+	ts := httptest.NewServer(New(1, 10))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || !strings.Contains(string(body), "new WebSocket(") {
+		t.Error("Expected the viewer page, but got", resp.Header.Get("Content-Type"))
+	}
+	// the viewer mustn't depend on anything it can't get from us
+	if strings.Contains(string(body), "src=\"http") || strings.Contains(string(body), "href=\"http") {
+		t.Error("Expected the viewer to be self-contained")
+	}
+}
@@ -0,0 +1,500 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+)
+
+// The states a run can be in.
+const (
+	RunQueued    = "queued"    // The run is waiting for a free worker.
+	RunRunning   = "running"   // The simulation is under way.
+	RunFinished  = "finished"  // The simulation has finished and its result is available.
+	RunFailed    = "failed"    // The simulation could not be run.
+	RunCancelled = "cancelled" // The simulation was cancelled before it was over, and its partial result is available.
+)
+
+// RunConfig describes the simulation to run, as submitted by clients. Either
+// the name of a map known to the server or the text of a map must be given.
+type RunConfig struct {
+	Map                string   `json:"map,omitempty"`      // The name of a map uploaded to (or loaded by) the server.
+	MapText            string   `json:"map_text,omitempty"` // The world map itself, in the usual format.
+	Aliens             int      `json:"aliens"`
+	Seed               *int64   `json:"seed,omitempty"`          // The seed for the random number generator (picked at random if not given).
+	SpawnPattern       string   `json:"spawn_pattern,omitempty"` // How to distribute aliens across the spawn cities (uniform, clustered or edge).
+	Placement          string   `json:"placement,omitempty"`     // How to place aliens (uniform, unique, weighted[:attr] or clustered).
+	Species            string   `json:"species,omitempty"`       // Species definitions, in the same format as a species file.
+	SpawnCities        []string `json:"spawn_cities,omitempty"`
+	RoadEncounters     bool     `json:"road_encounters,omitempty"`
+	RoadCollapseChance float64  `json:"road_collapse_chance,omitempty"`
+	DefenderKillChance float64  `json:"defender_kill_chance,omitempty"`
+	RebuildAfter       int      `json:"rebuild_after,omitempty"`
+	Energy             int      `json:"energy,omitempty"` // The energy budget of each alien (0 means the default).
+	Recharge           int      `json:"recharge,omitempty"`
+	MaxIterations      int      `json:"max_iterations,omitempty"`
+	MaxMovesPerAlien   int      `json:"max_moves_per_alien,omitempty"`
+	Trajectories       bool     `json:"trajectories,omitempty"`  // Include each alien's trajectory in the result.
+	Paused             bool     `json:"paused,omitempty"`        // Start the run paused, so that it can be stepped through.
+	StepDelay          int      `json:"step_delay_ms,omitempty"` // How long to wait between iterations (in milliseconds), to follow the run live.
+}
+
+// RunInfo describes a run and how far it has progressed.
+type RunInfo struct {
+	ID             string     `json:"id"`
+	State          string     `json:"state"`
+	Paused         bool       `json:"paused"`
+	Seed           int64      `json:"seed"`
+	Iteration      int        `json:"iteration"` // The last iteration simulated (-1 if none have been yet).
+	AliensAlive    int        `json:"aliens_alive"`
+	CitiesStanding int        `json:"cities_standing"`
+	TotalMoves     int        `json:"total_moves"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// RunResult is the outcome of a finished run.
+type RunResult struct {
+	IterationsSimulated int                              `json:"iterations_simulated"`
+	StopReason          string                           `json:"stop_reason"`
+	StopKind            string                           `json:"stop_kind"`
+	AliensStillAlive    int                              `json:"aliens_still_alive"`
+	AliensExhausted     int                              `json:"aliens_exhausted"`
+	AliensTrapped       int                              `json:"aliens_trapped"`
+	AliensDead          int                              `json:"aliens_dead"`
+	FinalAliens         []string                         `json:"final_aliens"`
+	CitiesRemaining     []string                         `json:"cities_remaining"`
+	RoadsDestroyed      int                              `json:"roads_destroyed"`
+	TotalMoves          int                              `json:"total_moves"`
+	Cancelled           bool                             `json:"cancelled,omitempty"` // Was the run cancelled before it was over (in which case the result is partial)?
+	SpeciesStats        map[string]aliensim.SpeciesStats `json:"species_stats"`
+	FinalMap            string                           `json:"final_map"`
+	Trajectories        []aliensim.Trajectory            `json:"trajectories,omitempty"`
+}
+
+// Run is a single simulation submitted to the server.
+type Run struct {
+	id       string
+	seed     int64
+	config   *aliensim.SimulationConfig
+	delay    time.Duration // How long to wait between iterations.
+	created  time.Time
+	ctx      context.Context // Done once the run has been cancelled.
+	cancel   context.CancelFunc
+	queue    chan<- *Run // Where the run waits for a worker (see unpark).
+	onFinish func(*Run)  // Called once the run has finished, if set.
+
+	mu       sync.Mutex
+	sim      *aliensim.Simulation // The simulation, once a worker has started it.
+	parked   bool                 // Has the run been paused without holding on to a worker?
+	paused   bool
+	steps    int // The number of iterations to simulate while paused.
+	state    string
+	err      string
+	status   aliensim.SimulationStatus // The status at the end of the last iteration simulated.
+	result   *RunResult
+	events   []Event
+	first    int           // The ID of the oldest event still held on to.
+	watchers int           // How many clients are following the run live (see watch).
+	layout   *MapLayout    // The layout of the world map, once the simulation has started (only while watched).
+	world    *WorldState   // The state of the world after the last iteration simulated (only while watched).
+	changed  chan struct{} // Closed (and replaced) whenever an event is added or the run's state changes.
+	started  time.Time
+	finished time.Time
+}
+
+// newRun prepares a run of the simulation described by the given config, using
+// the given world map and notifying the given instrumentation of how it goes.
+// Returns an error if the config is invalid.
+func newRun(id string, cfg RunConfig, worldMap string, instrumentation aliensim.Instrumentation) (*Run, error) {
+	if cfg.Aliens > MaxAliens {
+		return nil, fmt.Errorf("Runs can have at most %d aliens, but got %d.", MaxAliens, cfg.Aliens)
+	}
+	if cfg.StepDelay < 0 || cfg.StepDelay > int(MaxStepDelay/time.Millisecond) {
+		return nil, fmt.Errorf("The step delay must be between 0 and %d ms, but got %d ms.", MaxStepDelay/time.Millisecond, cfg.StepDelay)
+	}
+	seed := time.Now().UnixNano()
+	if cfg.Seed != nil {
+		seed = *cfg.Seed
+	}
+	run := &Run{
+		id:      id,
+		seed:    seed,
+		created: time.Now(),
+		state:   RunQueued,
+		status:  aliensim.SimulationStatus{Iteration: -1, LastDestruction: -1},
+		events:  []Event{},
+		changed: make(chan struct{}),
+		paused:  cfg.Paused,
+		delay:   time.Duration(cfg.StepDelay) * time.Millisecond,
+	}
+	run.ctx, run.cancel = context.WithCancel(context.Background())
+	placementName := cfg.Placement
+	if len(placementName) == 0 {
+		placementName = "uniform"
+	}
+	placement, err := aliensim.ParsePlacementStrategy(placementName)
+	if err != nil {
+		return nil, err
+	}
+	spawnPattern := aliensim.SpawnUniform
+	if len(cfg.SpawnPattern) > 0 {
+		if spawnPattern, err = aliensim.ParseSpawnPattern(cfg.SpawnPattern); err != nil {
+			return nil, err
+		}
+	}
+	var species []*aliensim.Species
+	if len(cfg.Species) > 0 {
+		species, err = aliensim.ParseSpecies(strings.NewReader(cfg.Species))
+		if err != nil {
+			return nil, err
+		}
+	}
+	config := aliensim.NewSimulationConfig(strings.NewReader(worldMap), cfg.Aliens).
+		WithRandomGenerator(aliensim.NewSeededGenerator(seed)).
+		WithProgressHandler(&runHandler{run: run}).
+		WithInstrumentation(instrumentation).
+		WithRoadEncounters(cfg.RoadEncounters).
+		WithRoadCollapseChance(cfg.RoadCollapseChance).
+		WithDefenderKillChance(cfg.DefenderKillChance).
+		WithRebuilding(cfg.RebuildAfter, false).
+		WithSpawnCities(cfg.SpawnCities).
+		WithSpawnPattern(spawnPattern).
+		WithPlacement(placement).
+		WithSpecies(species...).
+		WithTrajectories(cfg.Trajectories)
+	if cfg.Energy > 0 {
+		config = config.WithEnergy(cfg.Energy, cfg.Recharge)
+	}
+	if cfg.MaxMovesPerAlien > 0 {
+		config = config.WithStopCriteria(aliensim.MaxMovesPerAlien{Moves: cfg.MaxMovesPerAlien})
+	}
+	if cfg.MaxIterations > 0 {
+		config = config.WithStopCriteria(aliensim.MaxIterations{Iterations: cfg.MaxIterations})
+	}
+	run.config = config
+	return run, nil
+}
+
+// execute simulates the run one iteration at a time until it's over, or until
+// it's paused, in which case the run is parked instead of holding on to the
+// worker executing it (see takeTurn).
+func (r *Run) execute() {
+	r.mu.Lock()
+	sim := r.sim
+	if sim == nil {
+		r.state = RunRunning
+		r.started = time.Now()
+		r.notify()
+	}
+	r.mu.Unlock()
+
+	if sim == nil {
+		sim = aliensim.NewSimulation(r.config)
+		if err := sim.Start(); err != nil {
+			r.finish(nil, err)
+			return
+		}
+		r.mu.Lock()
+		r.sim = sim
+		r.mu.Unlock()
+		r.capture(sim)
+	}
+	for {
+		if !r.takeTurn() {
+			return
+		}
+		select {
+		case <-r.ctx.Done():
+			sim.Cancel()
+			r.finish(sim, nil)
+			return
+		default:
+		}
+		done, err := sim.Step()
+		if err != nil {
+			r.finish(nil, err)
+			return
+		}
+		r.capture(sim)
+		if done {
+			r.finish(sim, nil)
+			return
+		}
+	}
+}
+
+// takeTurn checks whether the run should go on to simulate another iteration,
+// after waiting for the configured delay between iterations. Returns false if
+// the run has been parked, because it's paused without any steps to take.
+func (r *Run) takeTurn() bool {
+	r.mu.Lock()
+	if r.paused && r.steps == 0 && r.ctx.Err() == nil {
+		r.parked = true
+		r.captureParked()
+		r.mu.Unlock()
+		return false
+	}
+	stepping := r.steps > 0
+	if stepping {
+		r.steps--
+	}
+	r.mu.Unlock()
+	if !stepping && r.delay > 0 {
+		select {
+		case <-time.After(r.delay):
+		case <-r.ctx.Done():
+		}
+	}
+	return true
+}
+
+// unpark queues a parked run again, now that it has been resumed or asked to
+// take a step. Returns false if the queue is full, in which case the run stays
+// parked. The caller must hold the run's lock.
+func (r *Run) unpark() bool {
+	select {
+	case r.queue <- r:
+		r.parked = false
+		return true
+	default:
+		return false
+	}
+}
+
+// finish records the result of the given simulation, or the error that kept
+// it from being run, once the simulation is over.
+func (r *Run) finish(sim *aliensim.Simulation, err error) {
+	// finishing the simulation may emit more events, so we can't hold the lock
+	var result *RunResult
+	if err == nil {
+		result = newRunResult(sim.Finish())
+	}
+	r.mu.Lock()
+	r.finished = time.Now()
+	switch {
+	case err != nil:
+		r.state = RunFailed
+		r.err = err.Error()
+	case result.Cancelled:
+		r.state = RunCancelled
+		r.result = result
+	default:
+		r.state = RunFinished
+		r.result = result
+	}
+	r.sim = nil
+	r.notify()
+	r.mu.Unlock()
+	r.cancel()
+	if r.onFinish != nil {
+		r.onFinish(r)
+	}
+}
+
+// capture records the layout (the first time around) and the current state of
+// the given simulation's world map, if anyone is following the run live.
+// Copying the state of a large world map takes a while, so it's done without
+// holding the run's lock.
+func (r *Run) capture(sim *aliensim.Simulation) {
+	r.mu.Lock()
+	watched, needLayout, iteration := r.watchers > 0, r.layout == nil, r.status.Iteration
+	r.mu.Unlock()
+	if !watched {
+		return
+	}
+	var layout *MapLayout
+	if needLayout {
+		layout = newMapLayout(sim.WorldMap())
+	}
+	world := newWorldState(iteration, sim)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.watchers == 0 {
+		return
+	}
+	if r.layout == nil {
+		r.layout = layout
+	}
+	r.world = world
+	r.notify()
+}
+
+// watch records that another client is following the run live, so that the
+// state of its world map is captured after each iteration from now on.
+func (r *Run) watch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.watchers++
+	r.captureParked()
+}
+
+// captureParked captures the state of a parked run's world map if anyone is
+// following it but it hasn't been captured yet, since it won't be until the
+// run is resumed otherwise. No worker touches the simulation of a parked run,
+// so this can be done while holding the run's lock, which the caller must.
+func (r *Run) captureParked() {
+	if r.parked && r.sim != nil && r.watchers > 0 && r.world == nil {
+		r.layout = newMapLayout(r.sim.WorldMap())
+		r.world = newWorldState(r.status.Iteration, r.sim)
+		r.notify()
+	}
+}
+
+// unwatch records that a client has stopped following the run live, letting go
+// of the state of its world map once nobody is following it any more.
+func (r *Run) unwatch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.watchers--
+	if r.watchers == 0 {
+		r.layout, r.world = nil, nil
+	}
+}
+
+// Pause pauses the run after the iteration currently being simulated.
+func (r *Run) Pause() error {
+	return r.control(func() {
+		r.paused = true
+		r.steps = 0
+	})
+}
+
+// Resume resumes a paused run.
+func (r *Run) Resume() error {
+	return r.control(func() {
+		r.paused = false
+	})
+}
+
+// Step has a paused run simulate a single iteration.
+func (r *Run) Step() error {
+	return r.control(func() {
+		if r.paused {
+			r.steps++
+		}
+	})
+}
+
+// Cancel stops the run after the iteration currently being simulated, after
+// which its partial result is available.
+func (r *Run) Cancel() error {
+	r.mu.Lock()
+	if r.done() {
+		r.mu.Unlock()
+		return fmt.Errorf("Run %s has already %s.", r.id, r.state)
+	}
+	r.cancel()
+	// a parked run has no worker to finish it, so it's up to us
+	sim, parked := r.sim, r.parked
+	r.parked = false
+	r.mu.Unlock()
+	if parked {
+		sim.Cancel()
+		r.finish(sim, nil)
+	}
+	return nil
+}
+
+// control applies the given change to the run, as long as it hasn't finished
+// yet, and queues the run again if the change means a parked run has work to
+// do.
+func (r *Run) control(change func()) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done() {
+		return fmt.Errorf("Run %s has already %s.", r.id, r.state)
+	}
+	paused, steps := r.paused, r.steps
+	change()
+	if r.parked && (!r.paused || r.steps > 0) && !r.unpark() {
+		r.paused, r.steps = paused, steps
+		return fmt.Errorf("Too many runs are waiting to be executed.")
+	}
+	r.notify()
+	return nil
+}
+
+// done checks whether the run has finished, successfully or otherwise. The
+// caller must hold the run's lock.
+func (r *Run) done() bool {
+	return r.state == RunFinished || r.state == RunFailed || r.state == RunCancelled
+}
+
+// notify wakes up anyone waiting for the run to change. The caller must hold
+// the run's lock.
+func (r *Run) notify() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Info describes the run and how far it has progressed.
+func (r *Run) Info() RunInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := RunInfo{
+		ID:             r.id,
+		State:          r.state,
+		Paused:         r.paused && !r.done(),
+		Seed:           r.seed,
+		Iteration:      r.status.Iteration,
+		AliensAlive:    r.status.AliensAlive,
+		CitiesStanding: r.status.CitiesStanding,
+		TotalMoves:     r.status.TotalMoves,
+		Error:          r.err,
+		CreatedAt:      r.created,
+	}
+	if !r.started.IsZero() {
+		started := r.started
+		info.StartedAt = &started
+	}
+	if !r.finished.IsZero() {
+		finished := r.finished
+		info.FinishedAt = &finished
+	}
+	if r.result != nil {
+		info.Iteration = r.result.IterationsSimulated - 1
+		info.AliensAlive = r.result.AliensStillAlive
+		info.CitiesStanding = len(r.result.CitiesRemaining)
+		info.TotalMoves = r.result.TotalMoves
+	}
+	return info
+}
+
+// Result returns the result of the run, or nil if it hasn't finished yet.
+func (r *Run) Result() *RunResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.result
+}
+
+func newRunResult(res *aliensim.SimulationResult) *RunResult {
+	finalAliens := []string{}
+	for _, alien := range res.FinalAliens {
+		finalAliens = append(finalAliens, alien.String())
+	}
+	return &RunResult{
+		IterationsSimulated: res.IterationsSimulated,
+		StopReason:          res.StopReason,
+		StopKind:            res.StopKind,
+		AliensStillAlive:    res.AliensStillAlive,
+		AliensExhausted:     res.AliensExhausted,
+		AliensTrapped:       res.AliensTrapped,
+		AliensDead:          res.AliensDead,
+		FinalAliens:         finalAliens,
+		CitiesRemaining:     res.CitiesRemaining,
+		RoadsDestroyed:      res.RoadsDestroyed,
+		TotalMoves:          res.TotalMoves,
+		Cancelled:           res.Cancelled,
+		SpeciesStats:        res.SpeciesStats,
+		FinalMap:            res.FinalMap.Render(),
+		Trajectories:        res.Trajectories,
+	}
+}
@@ -0,0 +1,388 @@
+// Package server exposes the alien invasion simulator over HTTP, so that other
+// tools can run simulations without shelling out to the command line tool.
+//
+// The server offers the following endpoints:
+//
+//	GET  /maps              - lists the names of the maps known to the server
+//	GET  /maps/{name}       - fetches a map
+//	PUT  /maps/{name}       - uploads a map (the request body is the map itself)
+//	GET  /runs              - lists all of the runs submitted so far
+//	POST /runs              - starts a run (the request body is a JSON RunConfig)
+//	GET  /runs/{id}         - describes how far a run has progressed
+//	GET  /runs/{id}/result  - fetches the result of a finished run
+//	GET  /runs/{id}/events  - streams a run's events as Server-Sent Events
+//	GET  /runs/{id}/live    - follows and controls a run over a WebSocket
+//	POST /runs/{id}/pause   - pauses a run
+//	POST /runs/{id}/step    - has a paused run simulate a single iteration
+//	POST /runs/{id}/resume  - resumes a paused run
+//	POST /runs/{id}/cancel  - cancels a run, keeping its partial result
+//	DELETE /runs/{id}       - cancels a run (if need be) and forgets about it
+//	GET  /                  - serves a viewer through which runs can be followed live
+//	GET  /metrics           - reports metrics about the runs in the Prometheus text format
+//
+// Runs are queued and executed concurrently by a fixed number of workers.
+// Paused runs don't hold on to a worker, and only the most recently finished
+// runs are held on to (see MaxFinishedRuns).
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+	"github.com/thanethomson/alien-invasion/pkg/metrics"
+)
+
+// MaxMapSize is the largest map (in bytes) that can be uploaded.
+const MaxMapSize = 10 * 1024 * 1024
+
+// MaxAliens is the largest number of aliens a run can start off with.
+const MaxAliens = 100000
+
+// MaxStepDelay is the longest a run can wait between iterations.
+const MaxStepDelay = 10 * time.Second
+
+// MaxRunEvents is the number of events each run holds on to for clients
+// following it, after which the oldest are forgotten.
+const MaxRunEvents = 100000
+
+// MaxFinishedRuns is the number of finished runs the server holds on to, after
+// which the oldest are forgotten to make room for newer ones.
+const MaxFinishedRuns = 1000
+
+// mapNamePattern restricts the names under which maps can be stored.
+var mapNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Server runs simulations on behalf of HTTP clients.
+type Server struct {
+	mu           sync.Mutex
+	maps         map[string]string // The text of each known map (key=map name).
+	runs         map[string]*Run
+	runIDs       []string // The IDs of the runs, in the order in which they were submitted.
+	finished     []string // The IDs of the finished runs, in the order in which they finished.
+	keepFinished int      // How many finished runs to hold on to.
+	nextRun      int
+	queue        chan *Run
+	metrics      *metrics.SimulationMetrics
+}
+
+// New creates a server that executes up to the given number of runs at a time,
+// with up to queueSize more runs waiting for a free worker.
+func New(workers, queueSize int) *Server {
+	s := &Server{
+		maps:         map[string]string{},
+		runs:         map[string]*Run{},
+		queue:        make(chan *Run, queueSize),
+		metrics:      metrics.NewSimulationMetrics(),
+		keepFinished: MaxFinishedRuns,
+	}
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+// work executes queued runs, one at a time.
+func (s *Server) work() {
+	for run := range s.queue {
+		run.execute()
+	}
+}
+
+// retire records that the given run has finished, forgetting the oldest
+// finished runs if there are now too many of them.
+func (s *Server) retire(run *Run) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.runs[run.id] != run {
+		// the run has already been deleted
+		return
+	}
+	s.finished = append(s.finished, run.id)
+	if len(s.finished) <= s.keepFinished {
+		return
+	}
+	expired := map[string]bool{}
+	for _, id := range s.finished[:len(s.finished)-s.keepFinished] {
+		expired[id] = true
+		delete(s.runs, id)
+	}
+	s.finished = append([]string(nil), s.finished[len(s.finished)-s.keepFinished:]...)
+	runIDs := []string{}
+	for _, id := range s.runIDs {
+		if !expired[id] {
+			runIDs = append(runIDs, id)
+		}
+	}
+	s.runIDs = runIDs
+}
+
+// Delete cancels the run with the given ID, if it hasn't finished yet, and
+// forgets about it. Returns false if there is no such run.
+func (s *Server) Delete(id string) bool {
+	s.mu.Lock()
+	run, ok := s.runs[id]
+	if ok {
+		delete(s.runs, id)
+		s.runIDs = without(s.runIDs, id)
+		s.finished = without(s.finished, id)
+	}
+	s.mu.Unlock()
+	if ok {
+		// the run may well have finished already
+		run.Cancel()
+	}
+	return ok
+}
+
+// without returns the given IDs, other than the given one.
+func without(ids []string, id string) []string {
+	kept := []string{}
+	for _, other := range ids {
+		if other != id {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+// AddMap makes the given map available under the given name, replacing any
+// existing map with the same name.
+func (s *Server) AddMap(name, worldMap string) error {
+	if !mapNamePattern.MatchString(name) {
+		return fmt.Errorf("Invalid map name \"%s\" (may only contain letters, digits, '_', '.' and '-').", name)
+	}
+	if _, err := aliensim.ParseWorldMap(strings.NewReader(worldMap)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.maps[name] = worldMap
+	s.mu.Unlock()
+	return nil
+}
+
+// LoadMaps makes all of the maps (files ending in ".txt") in the given
+// directory available, named after their files without the extension.
+func (s *Server) LoadMaps(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".txt" {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		if err := s.AddMap(strings.TrimSuffix(file.Name(), ".txt"), string(content)); err != nil {
+			return fmt.Errorf("%s: %s", file.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Submit queues a run of the simulation described by the given config.
+func (s *Server) Submit(cfg RunConfig) (*Run, int, error) {
+	worldMap := cfg.MapText
+	if len(worldMap) == 0 {
+		s.mu.Lock()
+		m, ok := s.maps[cfg.Map]
+		s.mu.Unlock()
+		if !ok {
+			return nil, http.StatusBadRequest, fmt.Errorf("Unknown map \"%s\".", cfg.Map)
+		}
+		worldMap = m
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, err := newRun(fmt.Sprintf("%d", s.nextRun+1), cfg, worldMap, s.metrics)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	run.queue, run.onFinish = s.queue, s.retire
+	select {
+	case s.queue <- run:
+	default:
+		return nil, http.StatusServiceUnavailable, fmt.Errorf("Too many runs are waiting to be executed.")
+	}
+	s.nextRun++
+	s.runs[run.id] = run
+	s.runIDs = append(s.runIDs, run.id)
+	return run, http.StatusAccepted, nil
+}
+
+// Run returns the run with the given ID, or nil if there is no such run.
+func (s *Server) Run(id string) *Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs[id]
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case req.URL.Path == "/" || req.URL.Path == "/viewer":
+		serveViewer(w, req)
+	case req.URL.Path == "/metrics":
+		s.metrics.Registry.ServeHTTP(w, req)
+	case len(parts) == 1 && parts[0] == "maps":
+		s.handleMaps(w, req)
+	case len(parts) == 2 && parts[0] == "maps":
+		s.handleMap(w, req, parts[1])
+	case len(parts) == 1 && parts[0] == "runs":
+		s.handleRuns(w, req)
+	case len(parts) >= 2 && len(parts) <= 3 && parts[0] == "runs":
+		run := s.Run(parts[1])
+		if run == nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("No such run: %s", parts[1]))
+			return
+		}
+		if len(parts) == 2 {
+			s.handleRun(w, req, run, "")
+		} else {
+			s.handleRun(w, req, run, parts[2])
+		}
+	default:
+		writeError(w, http.StatusNotFound, "Not found.")
+	}
+}
+
+func (s *Server) handleMaps(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+	s.mu.Lock()
+	names := []string{}
+	for name := range s.maps {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, names)
+}
+
+func (s *Server) handleMap(w http.ResponseWriter, req *http.Request, name string) {
+	switch req.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		worldMap, ok := s.maps[name]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("No such map: %s", name))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, worldMap)
+	case http.MethodPut, http.MethodPost:
+		content, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, MaxMapSize))
+		if err != nil {
+			writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		if err := s.AddMap(name, string(content)); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"name": name})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+	}
+}
+
+func (s *Server) handleRuns(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		runs := []*Run{}
+		for _, id := range s.runIDs {
+			runs = append(runs, s.runs[id])
+		}
+		s.mu.Unlock()
+		infos := []RunInfo{}
+		for _, run := range runs {
+			infos = append(infos, run.Info())
+		}
+		writeJSON(w, http.StatusOK, infos)
+	case http.MethodPost:
+		var cfg RunConfig
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, MaxMapSize)).Decode(&cfg); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid run configuration: %s", err))
+			return
+		}
+		run, status, err := s.Submit(cfg)
+		if err != nil {
+			writeError(w, status, err.Error())
+			return
+		}
+		w.Header().Set("Location", "/runs/"+run.id)
+		writeJSON(w, status, run.Info())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+	}
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, req *http.Request, run *Run, action string) {
+	switch {
+	case req.Method == http.MethodGet && action == "":
+		writeJSON(w, http.StatusOK, run.Info())
+	case req.Method == http.MethodGet && action == "result":
+		s.handleResult(w, run)
+	case req.Method == http.MethodGet && action == "events":
+		streamEvents(w, req, run)
+	case req.Method == http.MethodGet && action == "live":
+		streamLive(w, req, run)
+	case req.Method == http.MethodDelete && action == "":
+		s.Delete(run.id)
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPost && (action == "pause" || action == "resume" || action == "step" || action == "cancel"):
+		if err := controlRun(run, action); err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, run.Info())
+	case action == "" || action == "result" || action == "events" || action == "live" ||
+		action == "pause" || action == "resume" || action == "step" || action == "cancel":
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+	default:
+		writeError(w, http.StatusNotFound, "Not found.")
+	}
+}
+
+func (s *Server) handleResult(w http.ResponseWriter, run *Run) {
+	info := run.Info()
+	switch info.State {
+	case RunFinished, RunCancelled:
+		writeJSON(w, http.StatusOK, run.Result())
+	case RunFailed:
+		writeError(w, http.StatusUnprocessableEntity, info.Error)
+	default:
+		writeError(w, http.StatusConflict, fmt.Sprintf("Run %s is still %s.", info.ID, info.State))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write response:", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
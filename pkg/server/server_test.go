@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thanethomson/alien-invasion/pkg/aliensim"
+	"github.com/thanethomson/alien-invasion/pkg/metrics"
+)
+
+// startTestRun submits the given run config to the given test server,
+// returning the resulting run's ID.
+func startTestRun(t *testing.T, ts *httptest.Server, cfg string) string {
+	resp, err := http.Post(ts.URL+"/runs", "application/json", strings.NewReader(cfg))
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	defer resp.Body.Close()
+	var info RunInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal("Expected a description of the run, but got", err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatal("Expected status 202, but got", resp.StatusCode, info)
+	}
+	return info.ID
+}
+
+// waitForTestRun polls the given test server until the run with the given ID
+// has finished, and then fetches its result.
+func waitForTestRun(t *testing.T, ts *httptest.Server, id string) *RunResult {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(ts.URL + "/runs/" + id + "/result")
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		if resp.StatusCode == http.StatusConflict {
+			resp.Body.Close()
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("Expected status 200, but got", resp.StatusCode)
+		}
+		var result RunResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal("Expected a result, but got", err)
+		}
+		return &result
+	}
+	t.Fatal("Timed out waiting for run", id)
+	return nil
+}
+
+func TestServerRunsSimulations(t *testing.T) {
+	ts := httptest.NewServer(New(2, 10))
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/maps/example", strings.NewReader(aliensim.ExampleWorld))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatal("Expected status 201, but got", resp.StatusCode)
+	}
+
+	// runs with the same seed should have the same outcome
+	cfg := `{"map": "example", "aliens": 4, "seed": 42}`
+	first, second := startTestRun(t, ts, cfg), startTestRun(t, ts, cfg)
+	a, b := waitForTestRun(t, ts, first), waitForTestRun(t, ts, second)
+	if a.IterationsSimulated != b.IterationsSimulated || a.FinalMap != b.FinalMap || a.TotalMoves != b.TotalMoves {
+		t.Error("Expected runs with the same seed to match, but got", a, "and", b)
+	}
+	if len(a.StopReason) == 0 || a.AliensStillAlive+a.AliensDead != 4 {
+		t.Error("Expected a complete result for 4 aliens, but got", a)
+	}
+
+	resp, err = http.Get(ts.URL + "/runs/" + first)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	defer resp.Body.Close()
+	var info RunInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal("Expected a description of the run, but got", err)
+	}
+	if info.State != RunFinished || info.Seed != 42 || info.FinishedAt == nil {
+		t.Error("Expected run to have finished, but got", info)
+	}
+}
+
+func TestServerStreamsEvents(t *testing.T) {
+	ts := httptest.NewServer(New(1, 10))
+	defer ts.Close()
+
+	id := startTestRun(t, ts, `{"map_text": "Foo\n", "aliens": 2}`)
+	resp, err := http.Get(ts.URL + "/runs/" + id + "/events")
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Error("Expected an event stream, but got", contentType)
+	}
+	eventTypes := []string{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "event: ") {
+			eventTypes = append(eventTypes, strings.TrimPrefix(line, "event: "))
+		}
+	}
+	expected := []string{"city_destroyed", "iteration_completed", "all_aliens_dead", "done"}
+	if strings.Join(eventTypes, ",") != strings.Join(expected, ",") {
+		t.Error("Expected events", expected, "but got", eventTypes)
+	}
+}
+
+func TestServerRejectsInvalidRequests(t *testing.T) {
+	ts := httptest.NewServer(New(1, 10))
+	defer ts.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPut, "/maps/broken", "Foo sideways=Bar\n", http.StatusBadRequest},
+		{http.MethodPost, "/runs", `{"map": "missing", "aliens": 2}`, http.StatusBadRequest},
+		{http.MethodPost, "/runs", `{"map_text": "Foo\n", "aliens": 2, "placement": "sideways"}`, http.StatusBadRequest},
+		{http.MethodPost, "/runs", `{"map_text": "Foo\n", "aliens": 2, "spawn_pattern": "sideways"}`, http.StatusBadRequest},
+		{http.MethodPost, "/runs", `{"map_text": "Foo\n", "aliens": 100001}`, http.StatusBadRequest},
+		{http.MethodPost, "/runs", `{"map_text": "Foo\n", "aliens": 2, "step_delay_ms": 10001}`, http.StatusBadRequest},
+		{http.MethodPost, "/runs", `{"map_text": "Foo\n", "aliens": 2, "step_delay_ms": -1}`, http.StatusBadRequest},
+		{http.MethodPost, "/runs", `not json`, http.StatusBadRequest},
+		{http.MethodGet, "/runs/1234", "", http.StatusNotFound},
+		{http.MethodGet, "/maps/missing", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, ts.URL+test.path, strings.NewReader(test.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Expected no error, but got", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Error("Expected status", test.status, "for", test.method, test.path, "but got", resp.StatusCode)
+		}
+	}
+
+	// a run that can't be simulated fails, rather than producing a result
+	id := startTestRun(t, ts, `{"map_text": "Foo\n", "aliens": 1}`)
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if info := ts.Config.Handler.(*Server).Run(id).Info(); info.State == RunFailed {
+			return
+		}
+	}
+	t.Error("Expected run with too few aliens to fail")
+}
+
+func TestServerForgetsOldRuns(t *testing.T) {
+	srv := New(1, 10)
+	srv.keepFinished = 2
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ids := []string{}
+	for i := 0; i < 4; i++ {
+		id := startTestRun(t, ts, `{"map_text": "A east=B\nB east=C\n", "aliens": 2, "seed": 1}`)
+		waitForTestRun(t, ts, id)
+		ids = append(ids, id)
+	}
+	for deadline := time.Now().Add(10 * time.Second); srv.Run(ids[1]) != nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the oldest runs to be forgotten")
+		}
+	}
+	resp, err := http.Get(ts.URL + "/runs")
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	defer resp.Body.Close()
+	var infos []RunInfo
+	if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		t.Fatal("Expected a list of runs, but got", err)
+	}
+	if len(infos) != 2 || infos[0].ID != ids[2] || infos[1].ID != ids[3] {
+		t.Error("Expected only the last 2 runs to be listed, but got", infos)
+	}
+	if srv.Run(ids[0]) != nil {
+		t.Error("Expected the oldest run to have been forgotten")
+	}
+}
+
+func TestRunForgetsOldEvents(t *testing.T) {
+	run, err := newRun("1", RunConfig{MapText: "Foo\n", Aliens: 2}, "Foo\n", nil)
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	handler := &runHandler{run: run}
+	for i := 0; i <= MaxRunEvents; i++ {
+		handler.CityRebuilt("Foo")
+	}
+	events := run.eventsFrom(0)
+	if len(events) != MaxRunEvents/2+1 || events[0].ID != MaxRunEvents/2 || events[len(events)-1].ID != MaxRunEvents {
+		t.Error("Expected only the newest", MaxRunEvents/2+1, "events to be held on to, but got", len(events))
+	}
+	if events := run.eventsFrom(MaxRunEvents); len(events) != 1 || events[0].ID != MaxRunEvents {
+		t.Error("Expected to find the last event by its ID, but got", events)
+	}
+	if events := run.eventsFrom(MaxRunEvents + 1); len(events) != 0 {
+		t.Error("Expected no events after the last one, but got", events)
+	}
+}
+
+func TestServerReportsMetrics(t *testing.T) {
+	ts := httptest.NewServer(New(1, 10))
+	defer ts.Close()
+
+	waitForTestRun(t, ts, startTestRun(t, ts, `{"map_text": "A east=B\nB east=C\n", "aliens": 2, "seed": 1}`))
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal("Expected no error, but got", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Type") != metrics.ContentType {
+		t.Error("Expected the Prometheus text format, but got", resp.Header.Get("Content-Type"))
+	}
+	for _, line := range []string{
+		"alien_invasion_simulations_started_total 1\n",
+		"alien_invasion_simulations_completed_total 1\n",
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("Expected the metrics to include %q, but got:\n%s", line, body)
+		}
+	}
+}
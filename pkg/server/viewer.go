@@ -0,0 +1,319 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// serveViewer serves the single-page viewer through which runs can be started
+// and followed live. The viewer is entirely self-contained, so that it works
+// without access to the internet.
+func serveViewer(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, viewerHTML)
+}
+
+// viewerHTML is the viewer page, along with its styles and scripts.
+const viewerHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Alien invasion</title>
+<style>
+  body { margin: 0; font-family: sans-serif; font-size: 14px; display: flex; height: 100vh; color: #222; }
+  #sidebar { width: 300px; padding: 12px; border-right: 1px solid #ccc; overflow-y: auto; box-sizing: border-box; }
+  #main { flex: 1; display: flex; flex-direction: column; min-width: 0; }
+  #toolbar { padding: 8px 12px; border-bottom: 1px solid #ccc; display: flex; gap: 8px; align-items: center; }
+  #status { margin-left: auto; }
+  #view { flex: 1; position: relative; min-height: 0; }
+  canvas { position: absolute; top: 0; left: 0; width: 100%; height: 100%; }
+  #log { height: 160px; overflow-y: auto; border-top: 1px solid #ccc; padding: 4px 12px; font-family: monospace; font-size: 12px; }
+  h2 { font-size: 16px; margin: 12px 0 6px; }
+  label { display: block; margin: 6px 0; }
+  input[type=number], select { width: 100%; box-sizing: border-box; }
+  ul { list-style: none; padding: 0; margin: 0; }
+  li { padding: 4px 0; border-bottom: 1px solid #eee; display: flex; justify-content: space-between; align-items: center; }
+  .error { color: #b00; }
+</style>
+</head>
+<body>
+<div id="sidebar">
+  <h2>New run</h2>
+  <form id="new-run">
+    <label>Map <select id="map"></select></label>
+    <label>Aliens <input id="aliens" type="number" min="2" value="4"></label>
+    <label>Seed (optional) <input id="seed" type="number"></label>
+    <label>Delay between iterations (ms) <input id="delay" type="number" min="0" value="250"></label>
+    <label><input id="paused" type="checkbox" checked> Start paused</label>
+    <button type="submit">Start</button>
+    <div id="form-error" class="error"></div>
+  </form>
+  <h2>Runs <button id="refresh" type="button">Refresh</button></h2>
+  <ul id="runs"></ul>
+</div>
+<div id="main">
+  <div id="toolbar">
+    <button id="pause" disabled>Pause</button>
+    <button id="step" disabled>Step</button>
+    <button id="resume" disabled>Resume</button>
+    <button id="cancel" disabled>Cancel</button>
+    <select id="level" hidden></select>
+    <span id="status">No run selected.</span>
+  </div>
+  <div id="view"><canvas id="canvas"></canvas></div>
+  <div id="log"></div>
+</div>
+<script>
+(function () {
+  var socket = null, layout = null, state = null, run = null, level = 0;
+  var $ = function (id) { return document.getElementById(id); };
+
+  function request(method, path, body) {
+    return fetch(path, {
+      method: method,
+      headers: body ? { "Content-Type": "application/json" } : {},
+      body: body ? JSON.stringify(body) : undefined
+    }).then(function (resp) {
+      return resp.json().then(function (data) {
+        if (!resp.ok) { throw new Error(data.error || resp.statusText); }
+        return data;
+      });
+    });
+  }
+
+  function loadMaps() {
+    request("GET", "/maps").then(function (names) {
+      $("map").innerHTML = "";
+      names.forEach(function (name) {
+        var option = document.createElement("option");
+        option.value = option.textContent = name;
+        $("map").appendChild(option);
+      });
+    });
+  }
+
+  function loadRuns() {
+    request("GET", "/runs").then(function (runs) {
+      $("runs").innerHTML = "";
+      runs.slice().reverse().forEach(function (info) {
+        var li = document.createElement("li");
+        var label = document.createElement("span");
+        label.textContent = "Run " + info.id + " (" + info.state + ", seed " + info.seed + ")";
+        var button = document.createElement("button");
+        button.textContent = "Watch";
+        button.onclick = function () { watch(info.id); };
+        li.appendChild(label);
+        li.appendChild(button);
+        $("runs").appendChild(li);
+      });
+    });
+  }
+
+  function log(text) {
+    var line = document.createElement("div");
+    line.textContent = text;
+    $("log").appendChild(line);
+    while ($("log").childNodes.length > 200) { $("log").removeChild($("log").firstChild); }
+    $("log").scrollTop = $("log").scrollHeight;
+  }
+
+  function describe(event) {
+    var d = event.data || {};
+    switch (event.type) {
+      case "city_destroyed": return d.city + " has been destroyed by aliens " + d.aliens.join(", ");
+      case "road_destroyed": return "The road from " + d.from + " to " + d.to + " has been destroyed";
+      case "attack_repelled": return d.city + " repelled aliens " + d.aliens.join(", ") + " (defence left: " + d.defence_left + ")";
+      case "alien_killed_by_defenders": return "Alien " + d.alien + " was killed by the defenders of " + d.city;
+      case "city_rebuilt": return d.city + " has been rebuilt";
+      case "reinforcements_arrived": return "Reinforcements arrived: aliens " + d.aliens.join(", ");
+      case "fight_won": return "Alien " + d.alien + " (" + d.species + ") won a fight in " + d.city;
+      case "alien_born": return "Alien " + d.alien + " was born to alien " + d.parent + " in " + d.city;
+      case "species_eliminated": return "The last of the " + d.species + " aliens has been killed";
+      case "iteration_completed": return null;
+    }
+    return event.type.replace(/_/g, " ");
+  }
+
+  function updateStatus() {
+    if (!run) { $("status").textContent = "No run selected."; return; }
+    var text = "Run " + run.id + ": " + (run.paused ? "paused" : run.state);
+    if (state) {
+      text += ", iteration " + (state.iteration + 1) + ", " + state.aliens.length + " aliens alive";
+    }
+    $("status").textContent = text;
+    var active = run.state === "queued" || run.state === "running";
+    $("pause").disabled = !active || run.paused;
+    $("step").disabled = !active || !run.paused;
+    $("resume").disabled = !active || !run.paused;
+    $("cancel").disabled = !active;
+  }
+
+  function updateLevels() {
+    var levels = {};
+    layout.cities.forEach(function (city) { levels[city.z] = true; });
+    var keys = Object.keys(levels).map(Number).sort(function (a, b) { return a - b; });
+    $("level").innerHTML = "";
+    keys.forEach(function (z) {
+      var option = document.createElement("option");
+      option.value = z;
+      option.textContent = "Level " + z;
+      $("level").appendChild(option);
+    });
+    $("level").hidden = keys.length < 2;
+    level = keys[0] || 0;
+  }
+
+  function colour(name) {
+    var hash = 0;
+    for (var i = 0; i < name.length; i++) { hash = (hash * 31 + name.charCodeAt(i)) % 360; }
+    return "hsl(" + hash + ", 70%, 45%)";
+  }
+
+  function draw() {
+    var canvas = $("canvas"), ctx = canvas.getContext("2d");
+    canvas.width = canvas.clientWidth;
+    canvas.height = canvas.clientHeight;
+    ctx.clearRect(0, 0, canvas.width, canvas.height);
+    if (!layout) { return; }
+    var index = {}, onLevel = [];
+    layout.cities.forEach(function (city, i) {
+      index[city.name] = i;
+      if (city.z === level) { onLevel.push(city); }
+    });
+    if (onLevel.length === 0) { return; }
+    var minX = Infinity, maxX = -Infinity, minY = Infinity, maxY = -Infinity;
+    onLevel.forEach(function (c) {
+      minX = Math.min(minX, c.x); maxX = Math.max(maxX, c.x);
+      minY = Math.min(minY, c.y); maxY = Math.max(maxY, c.y);
+    });
+    var cell = Math.min(80, canvas.width / (maxX - minX + 1), canvas.height / (maxY - minY + 1));
+    var offsetX = (canvas.width - cell * (maxX - minX + 1)) / 2;
+    var offsetY = (canvas.height - cell * (maxY - minY + 1)) / 2;
+    function centre(city) {
+      return [offsetX + (city.x - minX + 0.5) * cell, offsetY + (maxY - city.y + 0.5) * cell];
+    }
+    var destroyedRoads = {};
+    if (state) { state.destroyed_roads.forEach(function (i) { destroyedRoads[i] = true; }); }
+    layout.roads.forEach(function (road, i) {
+      var from = layout.cities[index[road.from]], to = layout.cities[index[road.to]];
+      if (from.z !== level || to.z !== level) { return; }
+      var a = centre(from), b = centre(to);
+      ctx.beginPath();
+      ctx.setLineDash(destroyedRoads[i] ? [4, 4] : []);
+      ctx.strokeStyle = destroyedRoads[i] ? "#c33" : "#999";
+      ctx.lineWidth = Math.max(1, cell / 20);
+      ctx.moveTo(a[0], a[1]);
+      ctx.lineTo(b[0], b[1]);
+      ctx.stroke();
+    });
+    ctx.setLineDash([]);
+    var size = cell * 0.5;
+    onLevel.forEach(function (city) {
+      var c = centre(city), cityState = state ? state.city_states[index[city.name]] : "intact";
+      ctx.fillStyle = cityState === "destroyed" ? "#555" : cityState === "under siege" ? "#e90" : "#6b6";
+      ctx.fillRect(c[0] - size / 2, c[1] - size / 2, size, size);
+      if (cell >= 40) {
+        ctx.fillStyle = "#222";
+        ctx.font = Math.round(cell / 7) + "px sans-serif";
+        ctx.textAlign = "center";
+        ctx.fillText(city.name, c[0], c[1] + size / 2 + cell / 6);
+      }
+    });
+    if (!state) { return; }
+    var inCity = {};
+    state.aliens.forEach(function (alien) {
+      var from = layout.cities[index[alien.city]];
+      var pos = centre(from);
+      if (alien.to) {
+        var to = layout.cities[index[alien.to]];
+        if (from.z !== level && to.z !== level) { return; }
+        var end = centre(to);
+        pos = [(pos[0] + end[0]) / 2, (pos[1] + end[1]) / 2];
+      } else {
+        if (from.z !== level) { return; }
+        var n = inCity[alien.city] = (inCity[alien.city] || 0) + 1;
+        pos = [pos[0] + ((n - 1) % 3 - 1) * size / 3, pos[1] + (Math.floor((n - 1) / 3) % 3 - 1) * size / 3];
+      }
+      ctx.beginPath();
+      ctx.fillStyle = colour(alien.species);
+      ctx.arc(pos[0], pos[1], Math.max(2, size / 8), 0, 2 * Math.PI);
+      ctx.fill();
+    });
+  }
+
+  function watch(id) {
+    if (socket) { socket.onclose = null; socket.close(); }
+    layout = state = null;
+    run = { id: id, state: "connecting" };
+    $("log").innerHTML = "";
+    updateStatus();
+    draw();
+    var scheme = location.protocol === "https:" ? "wss://" : "ws://";
+    socket = new WebSocket(scheme + location.host + "/runs/" + id + "/live");
+    socket.onmessage = function (msg) {
+      var m = JSON.parse(msg.data);
+      switch (m.type) {
+        case "run":
+          run = m.run;
+          if (run.error) { log("Run failed: " + run.error); }
+          if (run.state === "finished" || run.state === "failed") { loadRuns(); }
+          break;
+        case "layout": layout = m.layout; updateLevels(); break;
+        case "state": state = m.state; break;
+        case "event":
+          var text = describe(m.event);
+          if (text) { log("[" + (m.event.iteration + 1) + "] " + text); }
+          break;
+        case "error": log("Error: " + m.error); break;
+      }
+      updateStatus();
+      if (m.type === "layout" || m.type === "state") { draw(); }
+    };
+    socket.onclose = function () {
+      if (run && run.state === "finished") {
+        request("GET", "/runs/" + id + "/result").then(function (res) {
+          log("Simulation stopped after " + res.iterations_simulated + " iterations, because " + res.stop_reason + ".");
+        });
+      }
+    };
+  }
+
+  function send(command) {
+    if (socket && socket.readyState === WebSocket.OPEN) {
+      socket.send(JSON.stringify({ command: command }));
+    }
+  }
+
+  $("new-run").onsubmit = function (e) {
+    e.preventDefault();
+    $("form-error").textContent = "";
+    var cfg = {
+      map: $("map").value,
+      aliens: parseInt($("aliens").value, 10),
+      step_delay_ms: parseInt($("delay").value, 10) || 0,
+      paused: $("paused").checked
+    };
+    if ($("seed").value !== "") { cfg.seed = parseInt($("seed").value, 10); }
+    request("POST", "/runs", cfg).then(function (info) {
+      loadRuns();
+      watch(info.id);
+    }).catch(function (err) { $("form-error").textContent = err.message; });
+  };
+  $("pause").onclick = function () { send("pause"); };
+  $("step").onclick = function () { send("step"); };
+  $("resume").onclick = function () { send("resume"); };
+  $("cancel").onclick = function () { send("cancel"); };
+  $("refresh").onclick = loadRuns;
+  $("level").onchange = function () { level = parseInt($("level").value, 10); draw(); };
+  window.onresize = draw;
+  loadMaps();
+  loadRuns();
+})();
+</script>
+</body>
+</html>
+`